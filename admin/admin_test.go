@@ -0,0 +1,80 @@
+package admin_test
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/sirupsen/logrus"
+
+	"github.com/smartatransit/api-gateway/admin"
+)
+
+var _ = Describe("NewHandler", func() {
+	var (
+		readiness *admin.Readiness
+		ready     error
+		handler   http.Handler
+	)
+	BeforeEach(func() {
+		readiness = admin.NewReadiness()
+		ready = nil
+		readiness.Add("jwks", func(context.Context) error { return ready })
+
+		logger := logrus.New()
+		logger.SetOutput(ioutil.Discard)
+		handler = admin.NewHandler(logger, readiness)
+	})
+
+	get := func(path string) *http.Response {
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("GET", path, nil)
+		handler.ServeHTTP(w, r)
+		return w.Result()
+	}
+
+	It("serves /healthz", func() {
+		Expect(get("/healthz").StatusCode).To(Equal(http.StatusOK))
+	})
+	Describe("/readyz", func() {
+		It("fails while a check fails", func() {
+			ready = errors.New("connection refused")
+			resp := get("/readyz")
+			Expect(resp.StatusCode).To(Equal(http.StatusServiceUnavailable))
+
+			body, _ := ioutil.ReadAll(resp.Body)
+			Expect(string(body)).To(ContainSubstring("jwks: connection refused"))
+		})
+		It("succeeds once the checks pass", func() {
+			Expect(get("/readyz").StatusCode).To(Equal(http.StatusOK))
+		})
+		It("fails while draining, but stays healthy", func() {
+			readiness.Drain()
+			Expect(get("/readyz").StatusCode).To(Equal(http.StatusServiceUnavailable))
+			Expect(get("/healthz").StatusCode).To(Equal(http.StatusOK))
+		})
+	})
+	It("serves metrics", func() {
+		resp := get("/metrics")
+		Expect(resp.StatusCode).To(Equal(http.StatusOK))
+		body, _ := ioutil.ReadAll(resp.Body)
+		Expect(string(body)).To(ContainSubstring("go_goroutines"))
+	})
+	It("serves expvar", func() {
+		resp := get("/debug/vars")
+		Expect(resp.StatusCode).To(Equal(http.StatusOK))
+		body, _ := ioutil.ReadAll(resp.Body)
+		Expect(string(body)).To(ContainSubstring("memstats"))
+	})
+	It("serves pprof", func() {
+		Expect(get("/debug/pprof/").StatusCode).To(Equal(http.StatusOK))
+		Expect(get("/debug/pprof/goroutine?debug=1").StatusCode).To(Equal(http.StatusOK))
+	})
+	It("doesn't serve anything else", func() {
+		Expect(get("/v1/verify").StatusCode).To(Equal(http.StatusNotFound))
+	})
+})
@@ -0,0 +1,87 @@
+package admin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// ErrShuttingDown is reported by /readyz once the gateway has started to
+// shut down
+var ErrShuttingDown = errors.New("shutting down")
+
+// Check reports whether something the gateway depends on is ready
+type Check func(ctx context.Context) error
+
+// NewReadiness creates a Readiness with no checks
+func NewReadiness() *Readiness {
+	return &Readiness{
+		checks: map[string]Check{},
+		passed: map[string]bool{},
+	}
+}
+
+// Readiness decides whether the gateway is ready to take traffic. Each
+// check only has to pass once: readiness gates startup, and an outage of a
+// dependency afterwards shouldn't take every replica out of rotation. It is
+// safe for concurrent use.
+type Readiness struct {
+	mutex    sync.Mutex
+	names    []string
+	checks   map[string]Check
+	passed   map[string]bool
+	draining bool
+}
+
+// Add registers a named check
+func (r *Readiness) Add(name string, check Check) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if _, ok := r.checks[name]; !ok {
+		r.names = append(r.names, name)
+	}
+	r.checks[name] = check
+}
+
+// Drain makes the gateway report that it isn't ready from now on, so that
+// it's taken out of rotation before it shuts down.
+func (r *Readiness) Drain() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.draining = true
+}
+
+// Ready runs any checks that haven't passed yet, in the order they were
+// added, and returns the first failure.
+func (r *Readiness) Ready(ctx context.Context) error {
+	r.mutex.Lock()
+	if r.draining {
+		r.mutex.Unlock()
+		return ErrShuttingDown
+	}
+
+	var pending []string
+	for _, name := range r.names {
+		if !r.passed[name] {
+			pending = append(pending, name)
+		}
+	}
+	checks := r.checks
+	r.mutex.Unlock()
+
+	// Checks may make network calls, so they're run without the lock
+	for _, name := range pending {
+		if err := checks[name](ctx); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+
+		r.mutex.Lock()
+		r.passed[name] = true
+		r.mutex.Unlock()
+	}
+
+	return nil
+}
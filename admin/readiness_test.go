@@ -0,0 +1,61 @@
+package admin_test
+
+import (
+	"context"
+	"errors"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/smartatransit/api-gateway/admin"
+)
+
+var _ = Describe("Readiness", func() {
+	var (
+		readiness *admin.Readiness
+		jwksErr   error
+		jwksCalls int
+		anonCalls int
+	)
+	BeforeEach(func() {
+		readiness = admin.NewReadiness()
+		jwksErr = errors.New("connection refused")
+		jwksCalls, anonCalls = 0, 0
+
+		readiness.Add("jwks", func(context.Context) error {
+			jwksCalls++
+			return jwksErr
+		})
+		readiness.Add("anonymous_token", func(context.Context) error {
+			anonCalls++
+			return nil
+		})
+	})
+	It("fails until every check passes, in order", func() {
+		Expect(readiness.Ready(context.Background())).To(MatchError("jwks: connection refused"))
+		Expect(anonCalls).To(Equal(0))
+
+		jwksErr = nil
+		Expect(readiness.Ready(context.Background())).To(Succeed())
+		Expect(anonCalls).To(Equal(1))
+	})
+	It("doesn't rerun checks that have passed", func() {
+		jwksErr = nil
+		Expect(readiness.Ready(context.Background())).To(Succeed())
+
+		jwksErr = errors.New("connection refused")
+		Expect(readiness.Ready(context.Background())).To(Succeed())
+		Expect(jwksCalls).To(Equal(1))
+		Expect(anonCalls).To(Equal(1))
+	})
+	It("fails once it's draining", func() {
+		jwksErr = nil
+		Expect(readiness.Ready(context.Background())).To(Succeed())
+
+		readiness.Drain()
+		Expect(readiness.Ready(context.Background())).To(Equal(admin.ErrShuttingDown))
+	})
+	It("is ready without any checks", func() {
+		Expect(admin.NewReadiness().Ready(context.Background())).To(Succeed())
+	})
+})
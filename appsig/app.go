@@ -0,0 +1,55 @@
+package appsig
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// minSecretLength is the shortest secret accepted, in bytes
+const minSecretLength = 32
+
+// App is a version of one of our apps, and the secret it signs requests
+// with. Each version has its own secret, so that a secret extracted from an
+// old build can be retired without breaking the others.
+type App struct {
+	ID      string `json:"id"`
+	Version string `json:"version"`
+	Secret  string `json:"secret"`
+}
+
+// Validate checks that the app has an ID, a version, and a long enough
+// secret
+func (a App) Validate() error {
+	if a.ID == "" || a.Version == "" {
+		return fmt.Errorf("must have an id and a version")
+	}
+	if len(a.Secret) < minSecretLength {
+		return fmt.Errorf("must have a secret of at least %v bytes", minSecretLength)
+	}
+	return nil
+}
+
+// LoadApps reads a JSON list of apps from disk
+func LoadApps(filename string) ([]App, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed opening apps file: %w", err)
+	}
+	defer f.Close()
+
+	var apps []App
+	dec := json.NewDecoder(f)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&apps); err != nil {
+		return nil, fmt.Errorf("malformed apps file: %w", err)
+	}
+
+	for i, app := range apps {
+		if err := app.Validate(); err != nil {
+			return nil, fmt.Errorf("malformed apps file: app %v %w", i, err)
+		}
+	}
+
+	return apps, nil
+}
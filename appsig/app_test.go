@@ -0,0 +1,49 @@
+package appsig_test
+
+import (
+	"io/ioutil"
+	"os"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/smartatransit/api-gateway/appsig"
+)
+
+var _ = Describe("LoadApps", func() {
+	var filename string
+	write := func(contents string) {
+		f, err := ioutil.TempFile("", "appsig-*")
+		Expect(err).To(BeNil())
+		_, err = f.WriteString(contents)
+		Expect(err).To(BeNil())
+		Expect(f.Close()).To(Succeed())
+		filename = f.Name()
+	}
+	AfterEach(func() {
+		os.Remove(filename)
+	})
+
+	It("loads the apps", func() {
+		write(`[{"id": "ios", "version": "4.2.0", "secret": "0123456789abcdef0123456789abcdef"}]`)
+		apps, err := appsig.LoadApps(filename)
+		Expect(err).To(BeNil())
+		Expect(apps).To(Equal([]appsig.App{
+			{ID: "ios", Version: "4.2.0", Secret: "0123456789abcdef0123456789abcdef"},
+		}))
+	})
+	It("rejects apps without a version", func() {
+		write(`[{"id": "ios", "secret": "0123456789abcdef0123456789abcdef"}]`)
+		_, err := appsig.LoadApps(filename)
+		Expect(err).To(MatchError("malformed apps file: app 0 must have an id and a version"))
+	})
+	It("rejects short secrets", func() {
+		write(`[{"id": "ios", "version": "4.2.0", "secret": "hunter2"}]`)
+		_, err := appsig.LoadApps(filename)
+		Expect(err).To(MatchError("malformed apps file: app 0 must have a secret of at least 32 bytes"))
+	})
+	It("rejects unknown fields", func() {
+		write(`[{"id": "ios", "version": "4.2.0", "secret": "0123456789abcdef0123456789abcdef", "platform": "ios"}]`)
+		_, err := appsig.LoadApps(filename)
+		Expect(err).To(MatchError(ContainSubstring("malformed apps file")))
+	})
+})
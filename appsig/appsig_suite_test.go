@@ -0,0 +1,13 @@
+package appsig_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestAppsig(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Appsig Suite")
+}
@@ -0,0 +1,13 @@
+package appsig
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var signedRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "api_gateway",
+	Subsystem: "app_signature",
+	Name:      "verified_total",
+	Help:      "Requests with a valid app signature, by app and version.",
+}, []string{"app", "version"})
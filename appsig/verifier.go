@@ -0,0 +1,181 @@
+package appsig
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/smartatransit/api-gateway/store"
+)
+
+// The headers that a signed request carries
+const (
+	AppHeader       = "X-Smarta-App"
+	VersionHeader   = "X-Smarta-App-Version"
+	TimestampHeader = "X-Smarta-Timestamp"
+	NonceHeader     = "X-Smarta-Nonce"
+	SignatureHeader = "X-Smarta-Signature"
+)
+
+// nonces are 16 to 128 URL-safe characters
+var noncePattern = regexp.MustCompile(`^[A-Za-z0-9_-]{16,128}$`)
+
+var (
+	// ErrUnsigned is returned when a request has no signature
+	ErrUnsigned = errors.New("request isn't signed")
+	// ErrMalformedSignature is returned when a request's signature headers
+	// are incomplete or can't be decoded
+	ErrMalformedSignature = errors.New("malformed request signature")
+	// ErrUnknownApp is returned when no secret is configured for the app
+	// version a request claims to be from
+	ErrUnknownApp = errors.New("unknown app version")
+	// ErrInvalidSignature is returned when a signature doesn't match
+	ErrInvalidSignature = errors.New("invalid request signature")
+	// ErrExpiredSignature is returned when a signature's timestamp is
+	// outside the accepted window
+	ErrExpiredSignature = errors.New("request signature timestamp is outside the accepted window")
+	// ErrReplayedNonce is returned when a nonce has already been used
+	ErrReplayedNonce = errors.New("request signature nonce has already been used")
+)
+
+// Identity is the app version a request was verified to come from
+type Identity struct {
+	App     string
+	Version string
+}
+
+// SetHeaders forwards the app identity upstream
+func (id Identity) SetHeaders(w http.ResponseWriter) {
+	w.Header().Set(AppHeader, id.App)
+	w.Header().Set(VersionHeader, id.Version)
+}
+
+// Authenticator identifies requests by their signatures
+//go:generate counterfeiter . Authenticator
+type Authenticator interface {
+	Authenticate(ctx context.Context, r *http.Request) (Identity, error)
+}
+
+type appVersion struct {
+	app, version string
+}
+
+// NewVerifier creates a Verifier for the apps' signatures. Nonces are
+// remembered in nonces, so that replicas sharing a store reject each
+// other's replays. The store mustn't evict nonces before they expire.
+func NewVerifier(apps []App, nonces store.Store) (*Verifier, error) {
+	secrets := map[appVersion][]byte{}
+	for _, app := range apps {
+		key := appVersion{app.ID, app.Version}
+		if _, ok := secrets[key]; ok {
+			return nil, fmt.Errorf("app `%s` version `%s` is configured more than once", app.ID, app.Version)
+		}
+		secrets[key] = []byte(app.Secret)
+	}
+
+	return &Verifier{
+		secrets: secrets,
+		nonces:  nonces,
+		Window:  5 * time.Minute,
+		Now:     time.Now,
+	}, nil
+}
+
+// Verifier implements Authenticator for requests signed with HMAC-SHA256.
+// The signature is the base64 HMAC of the method, the request URI as sent
+// (path and query), the timestamp in Unix seconds, and the nonce, each
+// followed by a newline. The method and URI are taken from the
+// X-Forwarded-* headers that traefik sets.
+type Verifier struct {
+	secrets map[appVersion][]byte
+	nonces  store.Store
+
+	// Window is how far a signature's timestamp may be from the current
+	// time, either way
+	Window time.Duration
+	Now    func() time.Time
+}
+
+// Authenticate verifies the request's signature, and rejects its nonce if
+// it's been used before
+func (v *Verifier) Authenticate(ctx context.Context, r *http.Request) (Identity, error) {
+	id := Identity{
+		App:     r.Header.Get(AppHeader),
+		Version: r.Header.Get(VersionHeader),
+	}
+	timestamp := r.Header.Get(TimestampHeader)
+	nonce := r.Header.Get(NonceHeader)
+	signature := r.Header.Get(SignatureHeader)
+
+	if signature == "" {
+		return Identity{}, ErrUnsigned
+	}
+	if id.App == "" || id.Version == "" || timestamp == "" || !noncePattern.MatchString(nonce) {
+		return Identity{}, ErrMalformedSignature
+	}
+
+	mac, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil {
+		return Identity{}, fmt.Errorf("%w: %s", ErrMalformedSignature, err.Error())
+	}
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return Identity{}, fmt.Errorf("%w: %s", ErrMalformedSignature, err.Error())
+	}
+
+	secret, ok := v.secrets[appVersion{id.App, id.Version}]
+	if !ok {
+		return Identity{}, fmt.Errorf("%w: `%s` version `%s`", ErrUnknownApp, id.App, id.Version)
+	}
+
+	method, uri := forwarded(r)
+	h := hmac.New(sha256.New, secret)
+	for _, part := range []string{method, uri, timestamp, nonce} {
+		h.Write([]byte(part + "\n"))
+	}
+	if !hmac.Equal(mac, h.Sum(nil)) {
+		return Identity{}, ErrInvalidSignature
+	}
+
+	skew := v.Now().Sub(time.Unix(seconds, 0))
+	if skew > v.Window || skew < -v.Window {
+		return Identity{}, fmt.Errorf("%w: signed %s ago", ErrExpiredSignature, skew)
+	}
+
+	// The nonce only has to be remembered for as long as its timestamp is
+	// accepted. It's only checked once the signature is known to be good,
+	// so that nobody else can use it up.
+	fresh, err := v.nonces.SetNX(ctx, "app-nonce:"+id.App+":"+nonce, "1", 2*v.Window)
+	if err != nil {
+		return Identity{}, fmt.Errorf("failed checking nonce: %w", err)
+	}
+	if !fresh {
+		return Identity{}, ErrReplayedNonce
+	}
+
+	signedRequests.WithLabelValues(id.App, id.Version).Inc()
+	return id, nil
+}
+
+// forwarded returns the method and request URI of the original request
+func forwarded(r *http.Request) (string, string) {
+	method := r.Header.Get("X-Forwarded-Method")
+	if method == "" {
+		method = r.Method
+	}
+
+	uri := r.Header.Get("X-Forwarded-Uri")
+	if uri == "" {
+		uri = r.URL.RequestURI()
+	}
+
+	return strings.ToUpper(method), uri
+}
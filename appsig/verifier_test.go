@@ -0,0 +1,178 @@
+package appsig_test
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/smartatransit/api-gateway/appsig"
+	"github.com/smartatransit/api-gateway/store"
+	"github.com/smartatransit/api-gateway/store/storefakes"
+)
+
+const secret = "0123456789abcdef0123456789abcdef"
+
+// sign signs the request the way the apps do
+func sign(r *http.Request, secret, method, uri string, at time.Time, nonce string) {
+	timestamp := strconv.FormatInt(at.Unix(), 10)
+	h := hmac.New(sha256.New, []byte(secret))
+	h.Write([]byte(method + "\n" + uri + "\n" + timestamp + "\n" + nonce + "\n"))
+
+	r.Header.Set(appsig.AppHeader, "ios")
+	r.Header.Set(appsig.VersionHeader, "4.2.0")
+	r.Header.Set(appsig.TimestampHeader, timestamp)
+	r.Header.Set(appsig.NonceHeader, nonce)
+	r.Header.Set(appsig.SignatureHeader, base64.StdEncoding.EncodeToString(h.Sum(nil)))
+}
+
+var _ = Describe("Verifier", func() {
+	var (
+		now    time.Time
+		nonces store.Store
+		r      *http.Request
+
+		v   *appsig.Verifier
+		id  appsig.Identity
+		err error
+	)
+	BeforeEach(func() {
+		now = time.Unix(1600000000, 0)
+		nonces = store.NewMemory("test", 100)
+
+		r, _ = http.NewRequest("GET", "/", nil)
+		r.Header.Set("X-Forwarded-Method", "GET")
+		r.Header.Set("X-Forwarded-Uri", "/v1/arrivals?station=FIVE%20POINTS")
+		sign(r, secret, "GET", "/v1/arrivals?station=FIVE%20POINTS", now, "n0nce-0123456789")
+	})
+	JustBeforeEach(func() {
+		v, err = appsig.NewVerifier([]appsig.App{
+			{ID: "ios", Version: "4.1.0", Secret: "fedcba9876543210fedcba9876543210"},
+			{ID: "ios", Version: "4.2.0", Secret: secret},
+		}, nonces)
+		Expect(err).To(BeNil())
+		v.Now = func() time.Time { return now }
+
+		id, err = v.Authenticate(context.Background(), r)
+	})
+
+	It("identifies the app version", func() {
+		Expect(err).To(BeNil())
+		Expect(id).To(Equal(appsig.Identity{App: "ios", Version: "4.2.0"}))
+
+		w := httptest.NewRecorder()
+		id.SetHeaders(w)
+		Expect(w.Header().Get("X-Smarta-App")).To(Equal("ios"))
+		Expect(w.Header().Get("X-Smarta-App-Version")).To(Equal("4.2.0"))
+	})
+	It("rejects the nonce the second time", func() {
+		_, err := v.Authenticate(context.Background(), r)
+		Expect(err).To(MatchError(appsig.ErrReplayedNonce))
+	})
+	When("the request isn't signed", func() {
+		BeforeEach(func() {
+			r.Header.Del(appsig.SignatureHeader)
+		})
+		It("fails", func() {
+			Expect(err).To(MatchError(appsig.ErrUnsigned))
+		})
+	})
+	When("the nonce is missing", func() {
+		BeforeEach(func() {
+			r.Header.Del(appsig.NonceHeader)
+		})
+		It("fails", func() {
+			Expect(err).To(MatchError(appsig.ErrMalformedSignature))
+		})
+	})
+	When("the timestamp isn't a number", func() {
+		BeforeEach(func() {
+			r.Header.Set(appsig.TimestampHeader, "yesterday")
+		})
+		It("fails", func() {
+			Expect(err).To(MatchError(appsig.ErrMalformedSignature))
+		})
+	})
+	When("the app version isn't configured", func() {
+		BeforeEach(func() {
+			r.Header.Set(appsig.VersionHeader, "3.0.0")
+		})
+		It("fails", func() {
+			Expect(err).To(MatchError(appsig.ErrUnknownApp))
+		})
+	})
+	When("it's signed with another version's secret", func() {
+		BeforeEach(func() {
+			sign(r, "fedcba9876543210fedcba9876543210", "GET", "/v1/arrivals?station=FIVE%20POINTS", now, "n0nce-0123456789")
+		})
+		It("fails", func() {
+			Expect(err).To(MatchError(appsig.ErrInvalidSignature))
+		})
+	})
+	When("the signature was for another path", func() {
+		BeforeEach(func() {
+			r.Header.Set("X-Forwarded-Uri", "/v1/admin")
+		})
+		It("fails", func() {
+			Expect(err).To(MatchError(appsig.ErrInvalidSignature))
+		})
+	})
+	When("the signature was for another method", func() {
+		BeforeEach(func() {
+			r.Header.Set("X-Forwarded-Method", "DELETE")
+		})
+		It("fails", func() {
+			Expect(err).To(MatchError(appsig.ErrInvalidSignature))
+		})
+	})
+	When("the timestamp is too old", func() {
+		BeforeEach(func() {
+			sign(r, secret, "GET", "/v1/arrivals?station=FIVE%20POINTS", now.Add(-6*time.Minute), "n0nce-0123456789")
+		})
+		It("fails", func() {
+			Expect(err).To(MatchError(appsig.ErrExpiredSignature))
+		})
+	})
+	When("the timestamp is too far in the future", func() {
+		BeforeEach(func() {
+			sign(r, secret, "GET", "/v1/arrivals?station=FIVE%20POINTS", now.Add(6*time.Minute), "n0nce-0123456789")
+		})
+		It("fails", func() {
+			Expect(err).To(MatchError(appsig.ErrExpiredSignature))
+		})
+	})
+	When("the timestamp is a little off", func() {
+		BeforeEach(func() {
+			sign(r, secret, "GET", "/v1/arrivals?station=FIVE%20POINTS", now.Add(2*time.Minute), "n0nce-0123456789")
+		})
+		It("succeeds", func() {
+			Expect(err).To(BeNil())
+		})
+	})
+	When("the nonces can't be checked", func() {
+		BeforeEach(func() {
+			fake := &storefakes.FakeStore{}
+			fake.SetNXReturns(false, errors.New("connection refused"))
+			nonces = fake
+		})
+		It("fails", func() {
+			Expect(err).To(MatchError("failed checking nonce: connection refused"))
+		})
+	})
+	When("an app version is configured twice", func() {
+		It("fails", func() {
+			_, err := appsig.NewVerifier([]appsig.App{
+				{ID: "ios", Version: "4.2.0", Secret: secret},
+				{ID: "ios", Version: "4.2.0", Secret: secret},
+			}, nonces)
+			Expect(err).To(MatchError("app `ios` version `4.2.0` is configured more than once"))
+		})
+	})
+})
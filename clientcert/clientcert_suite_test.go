@@ -0,0 +1,13 @@
+package clientcert_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestClientcert(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Clientcert Suite")
+}
@@ -0,0 +1,109 @@
+package clientcert
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+)
+
+// Identity maps the client certificates that match it to a role and
+// session. Exactly one of CommonName, DNSName, URI, or Email is matched
+// against the certificate's subject common name or SANs. Session defaults
+// to the matched name.
+type Identity struct {
+	CommonName string `json:"common_name"`
+	DNSName    string `json:"dns_name"`
+	URI        string `json:"uri"`
+	Email      string `json:"email"`
+
+	Session string `json:"session"`
+	Role    string `json:"role"`
+}
+
+// Validate checks that the identity matches on exactly one name and has a
+// role
+func (id Identity) Validate() error {
+	var matchers int
+	for _, name := range []string{id.CommonName, id.DNSName, id.URI, id.Email} {
+		if name != "" {
+			matchers++
+		}
+	}
+	if matchers != 1 {
+		return fmt.Errorf("must match exactly one of common_name, dns_name, uri, or email")
+	}
+	if id.Role == "" {
+		return fmt.Errorf("must have a role")
+	}
+	return nil
+}
+
+// match reports whether the certificate has the identity's name, and
+// returns that name
+func (id Identity) match(cert *x509.Certificate) (string, bool) {
+	switch {
+	case id.CommonName != "":
+		return id.CommonName, cert.Subject.CommonName == id.CommonName
+	case id.DNSName != "":
+		return id.DNSName, contains(cert.DNSNames, id.DNSName)
+	case id.URI != "":
+		for _, uri := range cert.URIs {
+			if uri.String() == id.URI {
+				return id.URI, true
+			}
+		}
+		return id.URI, false
+	default:
+		return id.Email, contains(cert.EmailAddresses, id.Email)
+	}
+}
+
+func contains(names []string, name string) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+// LoadIdentities reads a JSON list of identities from disk
+func LoadIdentities(filename string) ([]Identity, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed opening client certificate identities file: %w", err)
+	}
+	defer f.Close()
+
+	var identities []Identity
+	dec := json.NewDecoder(f)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&identities); err != nil {
+		return nil, fmt.Errorf("malformed client certificate identities file: %w", err)
+	}
+
+	for i, id := range identities {
+		if err := id.Validate(); err != nil {
+			return nil, fmt.Errorf("malformed client certificate identities file: identity %v %w", i, err)
+		}
+	}
+
+	return identities, nil
+}
+
+// LoadRoots reads a PEM bundle of the CAs that client certificates must
+// chain to
+func LoadRoots(filename string) (*x509.CertPool, error) {
+	pem, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed reading client CA file: %w", err)
+	}
+
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("client CA file has no PEM certificates")
+	}
+	return roots, nil
+}
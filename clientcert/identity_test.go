@@ -0,0 +1,65 @@
+package clientcert_test
+
+import (
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/smartatransit/api-gateway/clientcert"
+)
+
+var _ = Describe("Identity", func() {
+	var filename string
+	write := func(contents string) {
+		f, err := ioutil.TempFile("", "clientcert-*")
+		Expect(err).To(BeNil())
+		_, err = f.WriteString(contents)
+		Expect(err).To(BeNil())
+		Expect(f.Close()).To(Succeed())
+		filename = f.Name()
+	}
+	AfterEach(func() {
+		os.Remove(filename)
+	})
+
+	Describe("LoadIdentities", func() {
+		It("loads the identities", func() {
+			write(`[{"dns_name": "fares.partner.example", "session": "fare-system", "role": "partner_fares"}]`)
+			identities, err := clientcert.LoadIdentities(filename)
+			Expect(err).To(BeNil())
+			Expect(identities).To(Equal([]clientcert.Identity{
+				{DNSName: "fares.partner.example", Session: "fare-system", Role: "partner_fares"},
+			}))
+		})
+		It("rejects identities that match on more than one name", func() {
+			write(`[{"common_name": "a", "dns_name": "b", "role": "r"}]`)
+			_, err := clientcert.LoadIdentities(filename)
+			Expect(err).To(MatchError("malformed client certificate identities file: identity 0 must match exactly one of common_name, dns_name, uri, or email"))
+		})
+		It("rejects identities without a role", func() {
+			write(`[{"email": "ops@partner.example"}]`)
+			_, err := clientcert.LoadIdentities(filename)
+			Expect(err).To(MatchError("malformed client certificate identities file: identity 0 must have a role"))
+		})
+		It("rejects unknown fields", func() {
+			write(`[{"subject": "CN=a", "role": "r"}]`)
+			_, err := clientcert.LoadIdentities(filename)
+			Expect(err).To(MatchError(ContainSubstring("malformed client certificate identities file")))
+		})
+	})
+	Describe("LoadRoots", func() {
+		It("loads a PEM bundle", func() {
+			ca := newCA("Smarta Partner CA")
+			write(string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.cert.Raw})))
+			_, err := clientcert.LoadRoots(filename)
+			Expect(err).To(BeNil())
+		})
+		It("rejects files without certificates", func() {
+			write("not a certificate")
+			_, err := clientcert.LoadRoots(filename)
+			Expect(err).To(MatchError("client CA file has no PEM certificates"))
+		})
+	})
+})
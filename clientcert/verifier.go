@@ -0,0 +1,179 @@
+package clientcert
+
+import (
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	jwtgo "github.com/dgrijalva/jwt-go"
+	"github.com/smartatransit/api-gateway/jwt"
+)
+
+// ForwardedHeader is the header that traefik's passTLSClientCert middleware
+// forwards the client certificate chain in
+const ForwardedHeader = "X-Forwarded-Tls-Client-Cert"
+
+// IssuerName is reported in X-Smarta-Auth-Issuer for requests authenticated
+// by a client certificate
+const IssuerName = "client_certificate"
+
+// maxChainLength bounds the certificates accepted in a forwarded chain
+const maxChainLength = 8
+
+var (
+	// ErrNoCertificate is returned when a request has no client certificate
+	ErrNoCertificate = errors.New("no client certificate")
+	// ErrMalformedCertificate is returned when a forwarded certificate
+	// can't be decoded
+	ErrMalformedCertificate = errors.New("malformed client certificate")
+	// ErrUntrustedCertificate is returned when a certificate doesn't chain
+	// to a configured CA, or isn't valid for client authentication
+	ErrUntrustedCertificate = errors.New("untrusted client certificate")
+	// ErrUnmappedCertificate is returned when a trusted certificate matches
+	// no configured identity
+	ErrUnmappedCertificate = errors.New("client certificate matches no identity")
+)
+
+// Authenticator identifies requests by their client certificates
+//go:generate counterfeiter . Authenticator
+type Authenticator interface {
+	Authenticate(r *http.Request) (jwt.Authorization, error)
+}
+
+// NewVerifier creates a Verifier that trusts certificates chaining to roots
+// and maps them to the first identity that matches. Certificates forwarded
+// in ForwardedHeader are only considered if trustForwarded is set, since
+// anyone could send one that traefik doesn't overwrite.
+func NewVerifier(roots *x509.CertPool, identities []Identity, trustForwarded bool) *Verifier {
+	return &Verifier{
+		roots:          roots,
+		identities:     identities,
+		trustForwarded: trustForwarded,
+		Now:            time.Now,
+	}
+}
+
+// Verifier implements Authenticator
+type Verifier struct {
+	roots          *x509.CertPool
+	identities     []Identity
+	trustForwarded bool
+
+	Now func() time.Time
+}
+
+// Authenticate verifies the client certificate presented on the request's
+// TLS connection, or forwarded by traefik, and maps it to an identity
+func (v *Verifier) Authenticate(r *http.Request) (jwt.Authorization, error) {
+	chain, err := v.chain(r)
+	if err != nil {
+		return jwt.Authorization{}, err
+	}
+	leaf := chain[0]
+
+	intermediates := x509.NewCertPool()
+	for _, cert := range chain[1:] {
+		intermediates.AddCert(cert)
+	}
+	_, err = leaf.Verify(x509.VerifyOptions{
+		Roots:         v.roots,
+		Intermediates: intermediates,
+		CurrentTime:   v.Now(),
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+	if err != nil {
+		return jwt.Authorization{}, fmt.Errorf("%w: %s", ErrUntrustedCertificate, err.Error())
+	}
+
+	for _, id := range v.identities {
+		name, ok := id.match(leaf)
+		if !ok {
+			continue
+		}
+
+		session := id.Session
+		if session == "" {
+			session = name
+		}
+		return jwt.Authorization{
+			StandardClaims: jwtgo.StandardClaims{Subject: leaf.Subject.String()},
+			Session:        session,
+			Role:           id.Role,
+			IssuerName:     IssuerName,
+		}, nil
+	}
+
+	return jwt.Authorization{}, fmt.Errorf("%w: `%s`", ErrUnmappedCertificate, leaf.Subject.String())
+}
+
+// chain returns the client certificate chain, leaf first
+func (v *Verifier) chain(r *http.Request) ([]*x509.Certificate, error) {
+	if r.TLS != nil && len(r.TLS.PeerCertificates) > 0 {
+		return r.TLS.PeerCertificates, nil
+	}
+
+	forwarded := r.Header.Get(ForwardedHeader)
+	if !v.trustForwarded || forwarded == "" {
+		return nil, ErrNoCertificate
+	}
+
+	return parseForwarded(forwarded)
+}
+
+// parseForwarded decodes a forwarded certificate chain. Traefik sends each
+// certificate as URL-escaped base64 DER, separated by commas. A URL-escaped
+// PEM bundle is accepted too.
+func parseForwarded(value string) ([]*x509.Certificate, error) {
+	// An escaped PEM bundle may have its spaces escaped as `+`, but bare
+	// base64 may have unescaped `+`s, which PathUnescape leaves alone
+	pemBundle := strings.Contains(value, "-----BEGIN")
+	unescape := url.PathUnescape
+	if pemBundle {
+		unescape = url.QueryUnescape
+	}
+	unescaped, err := unescape(value)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrMalformedCertificate, err.Error())
+	}
+
+	var ders [][]byte
+	if pemBundle {
+		rest := []byte(unescaped)
+		for {
+			var block *pem.Block
+			block, rest = pem.Decode(rest)
+			if block == nil {
+				break
+			}
+			ders = append(ders, block.Bytes)
+		}
+	} else {
+		for _, encoded := range strings.Split(unescaped, ",") {
+			der, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+			if err != nil {
+				return nil, fmt.Errorf("%w: %s", ErrMalformedCertificate, err.Error())
+			}
+			ders = append(ders, der)
+		}
+	}
+
+	if len(ders) == 0 || len(ders) > maxChainLength {
+		return nil, fmt.Errorf("%w: %v certificates", ErrMalformedCertificate, len(ders))
+	}
+
+	var chain []*x509.Certificate
+	for _, der := range ders {
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrMalformedCertificate, err.Error())
+		}
+		chain = append(chain, cert)
+	}
+	return chain, nil
+}
@@ -0,0 +1,249 @@
+package clientcert_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/smartatransit/api-gateway/clientcert"
+	"github.com/smartatransit/api-gateway/jwt"
+)
+
+// issuer is a CA that can sign test certificates
+type issuer struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+var serial int64
+
+// newCertificate creates a certificate from template, signed by parent, or
+// self-signed if parent is nil
+func newCertificate(template *x509.Certificate, parent *issuer) issuer {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	Expect(err).To(BeNil())
+
+	serial++
+	template.SerialNumber = big.NewInt(serial)
+	if template.NotBefore.IsZero() {
+		template.NotBefore = time.Now().Add(-time.Hour)
+		template.NotAfter = time.Now().Add(time.Hour)
+	}
+
+	signer := issuer{cert: template, key: key}
+	if parent != nil {
+		signer = *parent
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, signer.cert, &key.PublicKey, signer.key)
+	Expect(err).To(BeNil())
+	cert, err := x509.ParseCertificate(der)
+	Expect(err).To(BeNil())
+
+	return issuer{cert: cert, key: key}
+}
+
+func newCA(name string) issuer {
+	return newCertificate(&x509.Certificate{
+		Subject:               pkix.Name{CommonName: name},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}, nil)
+}
+
+// traefikEncode encodes a chain the way traefik's passTLSClientCert does
+func traefikEncode(certs ...*x509.Certificate) string {
+	var encoded []string
+	for _, cert := range certs {
+		encoded = append(encoded, url.QueryEscape(base64.StdEncoding.EncodeToString(cert.Raw)))
+	}
+	return strings.Join(encoded, ",")
+}
+
+var _ = Describe("Verifier", func() {
+	var (
+		ca         issuer
+		client     issuer
+		identities []clientcert.Identity
+		trust      bool
+
+		r    *http.Request
+		v    *clientcert.Verifier
+		auth jwt.Authorization
+		err  error
+	)
+	BeforeEach(func() {
+		ca = newCA("Smarta Partner CA")
+		client = newCertificate(&x509.Certificate{
+			Subject:     pkix.Name{CommonName: "fares.partner.example", Organization: []string{"Partner"}},
+			DNSNames:    []string{"fares.partner.example"},
+			ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		}, &ca)
+		identities = []clientcert.Identity{
+			{CommonName: "someone.else.example", Role: "other"},
+			{DNSName: "fares.partner.example", Session: "fare-system", Role: "partner_fares"},
+		}
+		trust = false
+
+		r, _ = http.NewRequest("GET", "/", nil)
+		r.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{client.cert}}
+	})
+	JustBeforeEach(func() {
+		roots := x509.NewCertPool()
+		roots.AddCert(ca.cert)
+		v = clientcert.NewVerifier(roots, identities, trust)
+
+		auth, err = v.Authenticate(r)
+	})
+	It("maps a trusted certificate to its identity", func() {
+		Expect(err).To(BeNil())
+		Expect(auth.Session).To(Equal("fare-system"))
+		Expect(auth.Role).To(Equal("partner_fares"))
+		Expect(auth.IssuerName).To(Equal("client_certificate"))
+		Expect(auth.Subject).To(Equal("CN=fares.partner.example,O=Partner"))
+	})
+	When("the identity has no session", func() {
+		BeforeEach(func() {
+			identities = []clientcert.Identity{{CommonName: "fares.partner.example", Role: "partner_fares"}}
+		})
+		It("uses the matched name", func() {
+			Expect(err).To(BeNil())
+			Expect(auth.Session).To(Equal("fares.partner.example"))
+		})
+	})
+	When("the certificate matches no identity", func() {
+		BeforeEach(func() {
+			identities = identities[:1]
+		})
+		It("fails", func() {
+			Expect(err).To(MatchError(clientcert.ErrUnmappedCertificate))
+		})
+	})
+	When("the certificate isn't signed by a trusted CA", func() {
+		BeforeEach(func() {
+			other := newCA("Someone Else's CA")
+			client = newCertificate(&x509.Certificate{
+				Subject:     pkix.Name{CommonName: "fares.partner.example"},
+				DNSNames:    []string{"fares.partner.example"},
+				ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+			}, &other)
+			r.TLS.PeerCertificates = []*x509.Certificate{client.cert}
+		})
+		It("fails", func() {
+			Expect(err).To(MatchError(clientcert.ErrUntrustedCertificate))
+		})
+	})
+	When("the certificate has expired", func() {
+		BeforeEach(func() {
+			client = newCertificate(&x509.Certificate{
+				Subject:     pkix.Name{CommonName: "fares.partner.example"},
+				DNSNames:    []string{"fares.partner.example"},
+				ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+				NotBefore:   time.Now().Add(-2 * time.Hour),
+				NotAfter:    time.Now().Add(-time.Hour),
+			}, &ca)
+			r.TLS.PeerCertificates = []*x509.Certificate{client.cert}
+		})
+		It("fails", func() {
+			Expect(err).To(MatchError(clientcert.ErrUntrustedCertificate))
+		})
+	})
+	When("the certificate isn't for client authentication", func() {
+		BeforeEach(func() {
+			client = newCertificate(&x509.Certificate{
+				Subject:     pkix.Name{CommonName: "fares.partner.example"},
+				DNSNames:    []string{"fares.partner.example"},
+				ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+			}, &ca)
+			r.TLS.PeerCertificates = []*x509.Certificate{client.cert}
+		})
+		It("fails", func() {
+			Expect(err).To(MatchError(clientcert.ErrUntrustedCertificate))
+		})
+	})
+	When("the certificate is signed by an intermediate", func() {
+		var intermediate issuer
+		BeforeEach(func() {
+			intermediate = newCertificate(&x509.Certificate{
+				Subject:               pkix.Name{CommonName: "Smarta Partner Intermediate"},
+				IsCA:                  true,
+				BasicConstraintsValid: true,
+				KeyUsage:              x509.KeyUsageCertSign,
+			}, &ca)
+			client = newCertificate(&x509.Certificate{
+				Subject:     pkix.Name{CommonName: "fares.partner.example"},
+				URIs:        []*url.URL{{Scheme: "spiffe", Host: "partner.example", Path: "/fares"}},
+				ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+			}, &intermediate)
+			identities = []clientcert.Identity{{URI: "spiffe://partner.example/fares", Role: "partner_fares"}}
+		})
+		It("verifies the chain that was presented", func() {
+			r.TLS.PeerCertificates = []*x509.Certificate{client.cert, intermediate.cert}
+			_, err := v.Authenticate(r)
+			Expect(err).To(BeNil())
+		})
+		It("fails without the intermediate", func() {
+			r.TLS.PeerCertificates = []*x509.Certificate{client.cert}
+			_, err := v.Authenticate(r)
+			Expect(err).To(MatchError(clientcert.ErrUntrustedCertificate))
+		})
+	})
+	When("no certificate is presented", func() {
+		BeforeEach(func() {
+			r.TLS = nil
+		})
+		It("fails", func() {
+			Expect(err).To(MatchError(clientcert.ErrNoCertificate))
+		})
+	})
+	When("the certificate is forwarded by traefik", func() {
+		BeforeEach(func() {
+			r.TLS = nil
+			r.Header.Set(clientcert.ForwardedHeader, traefikEncode(client.cert))
+		})
+		It("is ignored unless forwarded certificates are trusted", func() {
+			Expect(err).To(MatchError(clientcert.ErrNoCertificate))
+		})
+		When("forwarded certificates are trusted", func() {
+			BeforeEach(func() {
+				trust = true
+			})
+			It("is verified", func() {
+				Expect(err).To(BeNil())
+				Expect(auth.Role).To(Equal("partner_fares"))
+			})
+		})
+		When("it's URL-escaped PEM", func() {
+			BeforeEach(func() {
+				trust = true
+				block := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: client.cert.Raw})
+				r.Header.Set(clientcert.ForwardedHeader, url.QueryEscape(string(block)))
+			})
+			It("is verified", func() {
+				Expect(err).To(BeNil())
+			})
+		})
+		When("it's malformed", func() {
+			BeforeEach(func() {
+				trust = true
+				r.Header.Set(clientcert.ForwardedHeader, "not-a-certificate")
+			})
+			It("fails", func() {
+				Expect(err).To(MatchError(clientcert.ErrMalformedCertificate))
+			})
+		})
+	})
+})
@@ -0,0 +1,76 @@
+package endpoint_test
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+
+	"github.com/smartatransit/api-gateway/endpoint"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Callers", func() {
+	Describe("NewCallers", func() {
+		It("requires an id and a secret", func() {
+			_, err := endpoint.NewCallers(endpoint.Caller{ID: "batch"})
+			Expect(err).To(MatchError("caller 0 must have an id and a secret"))
+		})
+		It("rejects duplicate ids", func() {
+			_, err := endpoint.NewCallers(
+				endpoint.Caller{ID: "batch", Secret: "one"},
+				endpoint.Caller{ID: "batch", Secret: "two"},
+			)
+			Expect(err).To(MatchError("caller `batch` is configured more than once"))
+		})
+	})
+	Describe("LoadCallers", func() {
+		var filename string
+		write := func(contents string) {
+			f, err := ioutil.TempFile("", "callers-*.json")
+			Expect(err).To(BeNil())
+			_, err = f.WriteString(contents)
+			Expect(err).To(BeNil())
+			Expect(f.Close()).To(Succeed())
+			filename = f.Name()
+		}
+		AfterEach(func() {
+			os.Remove(filename)
+		})
+		It("loads the callers", func() {
+			write(`[{"id": "batch", "secret": "s3cret"}, {"id": "websockets", "secret": "other"}]`)
+			callers, err := endpoint.LoadCallers(filename)
+			Expect(err).To(BeNil())
+
+			r, _ := http.NewRequest("POST", "/", nil)
+			r.SetBasicAuth("websockets", "other")
+			id, ok := callers.Authenticate(r)
+			Expect(ok).To(BeTrue())
+			Expect(id).To(Equal("websockets"))
+		})
+		It("rejects unknown fields", func() {
+			write(`[{"id": "batch", "password": "s3cret"}]`)
+			_, err := endpoint.LoadCallers(filename)
+			Expect(err).To(MatchError(ContainSubstring("malformed callers file")))
+		})
+		It("rejects invalid callers", func() {
+			write(`[{"id": "batch"}]`)
+			_, err := endpoint.LoadCallers(filename)
+			Expect(err).To(MatchError("malformed callers file: caller 0 must have an id and a secret"))
+		})
+		It("fails when the file is missing", func() {
+			filename = "/nonexistent/callers.json"
+			_, err := endpoint.LoadCallers(filename)
+			Expect(err).To(MatchError(ContainSubstring("failed opening callers file")))
+		})
+	})
+	Describe("Authenticate", func() {
+		It("rejects everyone when there are no callers", func() {
+			r, _ := http.NewRequest("POST", "/", nil)
+			r.SetBasicAuth("", "")
+			_, ok := endpoint.Callers{}.Authenticate(r)
+			Expect(ok).To(BeFalse())
+		})
+	})
+})
@@ -0,0 +1,90 @@
+package endpoint
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/smartatransit/api-gateway/policy"
+)
+
+// APIKeyHeader carries `id|secret` credentials for clients that can't set
+// the Authorization header
+const APIKeyHeader = "X-API-Key"
+
+// AccessTokenParameter is the query parameter that carries bearer tokens
+const AccessTokenParameter = "access_token"
+
+// Carriers configures where credentials are found, besides the
+// Authorization header, on routes that enable them. Browsers opening
+// event streams or websockets, and embedded widgets, can't set headers.
+type Carriers struct {
+	// Cookie is the name of the cookie that carries bearer tokens
+	Cookie string
+}
+
+// carrierPrecedence is the order in which enabled carriers are checked;
+// only the first one present is used. Headers come first, and the query
+// last, since URLs are the likeliest to be logged.
+var carrierPrecedence = []string{policy.CarrierAPIKey, policy.CarrierCookie, policy.CarrierQuery}
+
+// authorization returns the credentials in the first of the enabled
+// carriers that the request has, as the Authorization header they stand in
+// for
+func (c Carriers) authorization(r *http.Request, enabled []string) (string, bool) {
+	for _, carrier := range carrierPrecedence {
+		if !contains(enabled, carrier) {
+			continue
+		}
+
+		switch carrier {
+		case policy.CarrierAPIKey:
+			if key := r.Header.Get(APIKeyHeader); key != "" {
+				return "Key " + key, true
+			}
+		case policy.CarrierCookie:
+			if c.Cookie == "" {
+				continue
+			}
+			if cookie, err := r.Cookie(c.Cookie); err == nil && cookie.Value != "" {
+				return "Bearer " + cookie.Value, true
+			}
+		case policy.CarrierQuery:
+			if token := forwardedQuery(r).Get(AccessTokenParameter); token != "" {
+				return "Bearer " + token, true
+			}
+		}
+	}
+
+	return "", false
+}
+
+// forwardedQuery parses the query of the original request. Malformed pairs
+// are skipped.
+func forwardedQuery(r *http.Request) url.Values {
+	uri := r.Header.Get("X-Forwarded-Uri")
+	if uri == "" {
+		uri = r.URL.RequestURI()
+	}
+
+	i := strings.IndexByte(uri, '?')
+	if i < 0 {
+		return url.Values{}
+	}
+	query := uri[i+1:]
+	if j := strings.IndexByte(query, '#'); j >= 0 {
+		query = query[:j]
+	}
+
+	values, _ := url.ParseQuery(query)
+	return values
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,136 @@
+package endpoint
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+
+	"github.com/smartatransit/api-gateway/jwt"
+)
+
+// RequestIDHeader identifies a request in error responses and logs. An ID
+// set by traefik or the client is kept if it's well-formed.
+const RequestIDHeader = "X-Request-Id"
+
+// bearerChallenge is sent with rejections of credentials other than Basic
+// ones, as RFC 7235 requires a challenge with every 401
+const bearerChallenge = `Bearer realm="api"`
+
+// retryAfterSeconds is how long clients are asked to wait when the identity
+// provider is failing. Calls to it fail fast while its circuit breaker is
+// open, so retries are cheap.
+const retryAfterSeconds = "5"
+
+var requestIDPattern = regexp.MustCompile(`^[A-Za-z0-9._-]{1,128}$`)
+
+// errorBody is the JSON body of every rejection
+type errorBody struct {
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+	RequestID        string `json:"request_id"`
+}
+
+// rejection describes a rejection reason to clients. Code is the RFC 6750
+// error code for the WWW-Authenticate challenge, if there is one.
+type rejection struct {
+	code        string
+	description string
+}
+
+var rejections = map[string]rejection{
+	"malformed_authorization": {"invalid_request", "The credentials are malformed."},
+	"key_exchange_failed":     {"", "The client credentials were rejected."},
+	"forbidden":               {"insufficient_scope", "The credentials don't allow access to this route."},
+	"unsigned_request":        {"", "The request must be signed by an app."},
+
+	"malformed_token":      {"invalid_token", "The token is malformed."},
+	"disallowed_algorithm": {"invalid_token", "The token is signed with an algorithm that isn't allowed."},
+	"unknown_key":          {"invalid_token", "The token is signed by an unknown key."},
+	"invalid_signature":    {"invalid_token", "The token's signature is invalid."},
+	"expired":              {"invalid_token", "The token has expired."},
+	"not_yet_valid":        {"invalid_token", "The token isn't valid yet."},
+	"issued_in_future":     {"invalid_token", "The token was issued in the future."},
+	"invalid_issuer":       {"invalid_token", "The token's issuer isn't trusted."},
+	"invalid_audience":     {"invalid_token", "The token isn't intended for this API."},
+	"invalid_token":        {"invalid_token", "The token is invalid."},
+
+	"malformed_certificate": {"", "The client certificate is malformed."},
+	"untrusted_certificate": {"", "The client certificate isn't trusted."},
+	"unmapped_certificate":  {"", "The client certificate isn't allowed."},
+	"invalid_certificate":   {"", "The client certificate is invalid."},
+
+	"malformed_app_signature": {"", "The request signature is malformed."},
+	"unknown_app":             {"", "The app version isn't recognized."},
+	"invalid_app_signature":   {"", "The request signature is invalid."},
+	"expired_app_signature":   {"", "The request signature has expired."},
+	"replayed_nonce":          {"", "The request signature's nonce has already been used."},
+
+	"malformed_forwarded_request":   {"", "The request path is malformed."},
+	"identity_provider_unavailable": {"", "The identity provider is unavailable."},
+	"identity_provider_failed":      {"", "The identity provider failed."},
+}
+
+// identityProviderError decides how a failure to obtain a token or keys
+// from the identity provider is reported, if it was the provider's fault.
+// Running out of time waiting for it counts as it being unavailable.
+func identityProviderError(err error) (int, string, bool) {
+	switch {
+	case errors.Is(err, jwt.ErrTokenEndpointUnavailable), errors.Is(err, jwt.ErrKeysUnavailable), errors.Is(err, context.DeadlineExceeded):
+		return http.StatusServiceUnavailable, "identity_provider_unavailable", true
+	case errors.Is(err, jwt.ErrTokenEndpointFailed):
+		return http.StatusBadGateway, "identity_provider_failed", true
+	default:
+		return 0, "", false
+	}
+}
+
+// requestID returns the request's ID, or a new one if it has none
+func requestID(r *http.Request) string {
+	if id := r.Header.Get(RequestIDHeader); requestIDPattern.MatchString(id) {
+		return id
+	}
+
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(b)
+}
+
+// writeError responds with a JSON error body naming the reason, and the
+// challenge or Retry-After that the status calls for. A challenge that has
+// already been set, such as a Basic one, is kept.
+func writeError(w http.ResponseWriter, id string, status int, reason string) {
+	rej, ok := rejections[reason]
+	if !ok {
+		rej.description = http.StatusText(status)
+	}
+
+	switch {
+	case status == http.StatusUnauthorized || status == http.StatusForbidden:
+		if w.Header().Get("WWW-Authenticate") == "" {
+			challenge := bearerChallenge
+			if rej.code != "" {
+				challenge += fmt.Sprintf(`, error="%s", error_description="%s"`, rej.code, rej.description)
+			}
+			w.Header().Set("WWW-Authenticate", challenge)
+		}
+	case status == http.StatusBadGateway || status == http.StatusServiceUnavailable:
+		w.Header().Set("Retry-After", retryAfterSeconds)
+	}
+
+	w.Header().Set(RequestIDHeader, id)
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(errorBody{
+		Error:            reason,
+		ErrorDescription: rej.description,
+		RequestID:        id,
+	})
+}
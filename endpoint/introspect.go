@@ -0,0 +1,125 @@
+package endpoint
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"github.com/smartatransit/api-gateway/jwt"
+)
+
+// maxIntrospectionRequest bounds the form body of an introspection request
+const maxIntrospectionRequest = 64 << 10
+
+// introspection is the RFC 7662 response for an active token. The session
+// and role are extensions for Smarta services.
+type introspection struct {
+	Active   bool         `json:"active"`
+	Subject  string       `json:"sub,omitempty"`
+	ClientID string       `json:"client_id,omitempty"`
+	Scope    string       `json:"scope,omitempty"`
+	Expiry   int64        `json:"exp,omitempty"`
+	IssuedAt int64        `json:"iat,omitempty"`
+	Issuer   string       `json:"iss,omitempty"`
+	Audience jwt.Audience `json:"aud,omitempty"`
+	Session  string       `json:"session,omitempty"`
+	Role     string       `json:"role,omitempty"`
+}
+
+// NewIntrospectEndpoint returns a new HTTP handler for the RFC 7662
+// /introspect endpoint, which lets internal services that receive tokens
+// outside of forward-auth have the gateway verify them. Callers
+// authenticate with HTTP Basic credentials.
+func NewIntrospectEndpoint(logger *logrus.Logger, parser jwt.Parser, callers Callers) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "no-store")
+
+		respond := func(status int, result string, body interface{}) {
+			introspections.WithLabelValues(result).Inc()
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(status)
+			_ = json.NewEncoder(w).Encode(body)
+		}
+
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			respond(http.StatusMethodNotAllowed, "invalid_request", map[string]string{"error": "invalid_request"})
+			return
+		}
+
+		caller, ok := callers.Authenticate(r)
+		if !ok {
+			w.Header().Set("WWW-Authenticate", `Basic realm="introspect"`)
+			respond(http.StatusUnauthorized, "unauthorized", map[string]string{"error": "invalid_client"})
+			return
+		}
+
+		r.Body = http.MaxBytesReader(w, r.Body, maxIntrospectionRequest)
+		token := ""
+		if err := r.ParseForm(); err == nil {
+			token = r.PostForm.Get("token")
+		}
+		if token == "" {
+			respond(http.StatusBadRequest, "invalid_request", map[string]string{"error": "invalid_request"})
+			return
+		}
+
+		// RFC 7662 doesn't let us say why a token isn't active, so the
+		// reason is only logged. Tokens that can't be verified because the
+		// identity provider is failing aren't known to be inactive, though,
+		// and callers mustn't treat them as revoked.
+		auth, err := parser.ParseToken(r.Context(), token)
+		if status, reason, ok := identityProviderError(err); ok {
+			logger.Errorf("failed introspecting a token for `%s`: %s", caller, err.Error())
+			w.Header().Set("Retry-After", retryAfterSeconds)
+			respond(status, reason, map[string]string{"error": reason})
+			return
+		}
+		if err != nil {
+			logger.Debugf("introspected an inactive token for `%s`: %s", caller, err.Error())
+			respond(http.StatusOK, "inactive", introspection{Active: false})
+			return
+		}
+
+		respond(http.StatusOK, "active", introspection{
+			Active:   true,
+			Subject:  auth.Subject,
+			ClientID: auth.ClientID(),
+			Scope:    scope(auth.Claims),
+			Expiry:   auth.ExpiresAt,
+			IssuedAt: auth.IssuedAt,
+			Issuer:   auth.Issuer,
+			Audience: auth.Audience,
+			Session:  auth.Session,
+			Role:     auth.Role,
+		})
+	})
+}
+
+// stringClaim returns the first of the named claims that's a string
+func stringClaim(claims map[string]interface{}, names ...string) string {
+	for _, name := range names {
+		if s, ok := claims[name].(string); ok {
+			return s
+		}
+	}
+	return ""
+}
+
+// scope reads the token's scopes from `scope`, or from `scp`, which some
+// providers send as an array
+func scope(claims map[string]interface{}) string {
+	if s := stringClaim(claims, "scope", "scp"); s != "" {
+		return s
+	}
+
+	list, _ := claims["scp"].([]interface{})
+	var scopes []string
+	for _, s := range list {
+		if s, ok := s.(string); ok {
+			scopes = append(scopes, s)
+		}
+	}
+	return strings.Join(scopes, " ")
+}
@@ -0,0 +1,189 @@
+package endpoint_test
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+
+	jwtgo "github.com/dgrijalva/jwt-go"
+	"github.com/sirupsen/logrus"
+	"github.com/smartatransit/api-gateway/endpoint"
+	"github.com/smartatransit/api-gateway/jwt"
+	"github.com/smartatransit/api-gateway/jwt/jwtfakes"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("NewIntrospectEndpoint", func() {
+	var (
+		log     *logrus.Logger
+		parser  *jwtfakes.FakeParser
+		callers endpoint.Callers
+
+		r    *http.Request
+		w    *httptest.ResponseRecorder
+		resp *http.Response
+		body map[string]interface{}
+	)
+
+	BeforeEach(func() {
+		log = logrus.New()
+		log.SetOutput(ioutil.Discard)
+
+		parser = &jwtfakes.FakeParser{}
+		parser.ParseTokenReturns(jwt.Authorization{
+			StandardClaims: jwtgo.StandardClaims{
+				Subject:   "auth0|user",
+				ExpiresAt: 1600003600,
+				IssuedAt:  1600000000,
+				Issuer:    "https://issuer/",
+			},
+			Audience: jwt.Audience{"https://api.smartatransit.com"},
+			Session:  "Session-Value",
+			Role:     "Role-Value",
+			Claims: map[string]interface{}{
+				"azp":   "client",
+				"scope": "read:schedules write:favorites",
+			},
+		}, nil)
+
+		var err error
+		callers, err = endpoint.NewCallers(endpoint.Caller{ID: "batch", Secret: "s3cret"})
+		Expect(err).To(BeNil())
+
+		form := url.Values{"token": {"token"}, "token_type_hint": {"access_token"}}
+		r, _ = http.NewRequest("POST", "/introspect", strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		r.SetBasicAuth("batch", "s3cret")
+		w = httptest.NewRecorder()
+	})
+
+	JustBeforeEach(func() {
+		endpoint.NewIntrospectEndpoint(log, parser, callers).ServeHTTP(w, r)
+
+		resp = w.Result()
+		body = nil
+		Expect(json.NewDecoder(resp.Body).Decode(&body)).To(BeNil())
+		Expect(resp.Header.Get("Content-Type")).To(Equal("application/json"))
+		Expect(resp.Header.Get("Cache-Control")).To(Equal("no-store"))
+	})
+
+	When("the token is valid", func() {
+		It("describes it", func() {
+			Expect(resp.StatusCode).To(Equal(http.StatusOK))
+			Expect(body).To(Equal(map[string]interface{}{
+				"active":    true,
+				"sub":       "auth0|user",
+				"client_id": "client",
+				"scope":     "read:schedules write:favorites",
+				"exp":       1600003600.0,
+				"iat":       1600000000.0,
+				"iss":       "https://issuer/",
+				"aud":       []interface{}{"https://api.smartatransit.com"},
+				"session":   "Session-Value",
+				"role":      "Role-Value",
+			}))
+
+			_, token := parser.ParseTokenArgsForCall(0)
+			Expect(token).To(Equal("token"))
+		})
+	})
+	When("the scopes are an scp array", func() {
+		BeforeEach(func() {
+			parser.ParseTokenReturns(jwt.Authorization{
+				Claims: map[string]interface{}{"scp": []interface{}{"read", "write"}},
+			}, nil)
+		})
+		It("joins them", func() {
+			Expect(body["scope"]).To(Equal("read write"))
+		})
+	})
+	When("the token isn't valid", func() {
+		BeforeEach(func() {
+			parser.ParseTokenReturns(jwt.Authorization{}, jwt.ErrTokenExpired)
+		})
+		It("is inactive, without saying why", func() {
+			Expect(resp.StatusCode).To(Equal(http.StatusOK))
+			Expect(body).To(Equal(map[string]interface{}{"active": false}))
+		})
+	})
+	When("the parser fails", func() {
+		BeforeEach(func() {
+			parser.ParseTokenReturns(jwt.Authorization{}, errors.New("failed fetching JWKs"))
+		})
+		It("is inactive", func() {
+			Expect(body).To(Equal(map[string]interface{}{"active": false}))
+		})
+	})
+	When("the keys to verify the token can't be fetched", func() {
+		BeforeEach(func() {
+			parser.ParseTokenReturns(jwt.Authorization{}, fmt.Errorf("failed parsing JWT: %w", jwt.ErrKeysUnavailable))
+		})
+		It("asks the caller to retry, rather than saying the token is inactive", func() {
+			Expect(resp.StatusCode).To(Equal(http.StatusServiceUnavailable))
+			Expect(resp.Header.Get("Retry-After")).To(Equal("5"))
+			Expect(body).To(Equal(map[string]interface{}{"error": "identity_provider_unavailable"}))
+		})
+	})
+	When("the caller has no credentials", func() {
+		BeforeEach(func() {
+			r.Header.Del("Authorization")
+		})
+		It("is unauthorized", func() {
+			Expect(resp.StatusCode).To(Equal(http.StatusUnauthorized))
+			Expect(resp.Header.Get("WWW-Authenticate")).To(Equal(`Basic realm="introspect"`))
+			Expect(body).To(Equal(map[string]interface{}{"error": "invalid_client"}))
+			Expect(parser.ParseTokenCallCount()).To(Equal(0))
+		})
+	})
+	When("the caller's secret is wrong", func() {
+		BeforeEach(func() {
+			r.SetBasicAuth("batch", "wrong")
+		})
+		It("is unauthorized", func() {
+			Expect(resp.StatusCode).To(Equal(http.StatusUnauthorized))
+			Expect(parser.ParseTokenCallCount()).To(Equal(0))
+		})
+	})
+	When("the caller isn't known", func() {
+		BeforeEach(func() {
+			r.SetBasicAuth("stranger", "s3cret")
+		})
+		It("is unauthorized", func() {
+			Expect(resp.StatusCode).To(Equal(http.StatusUnauthorized))
+		})
+	})
+	When("there's no token", func() {
+		BeforeEach(func() {
+			r.Body = ioutil.NopCloser(strings.NewReader("token_type_hint=access_token"))
+		})
+		It("is a bad request", func() {
+			Expect(resp.StatusCode).To(Equal(http.StatusBadRequest))
+			Expect(body).To(Equal(map[string]interface{}{"error": "invalid_request"}))
+		})
+	})
+	When("the token is in the query string", func() {
+		BeforeEach(func() {
+			r, _ = http.NewRequest("POST", "/introspect?token=token", nil)
+			r.SetBasicAuth("batch", "s3cret")
+		})
+		It("is a bad request", func() {
+			Expect(resp.StatusCode).To(Equal(http.StatusBadRequest))
+		})
+	})
+	When("the method isn't POST", func() {
+		BeforeEach(func() {
+			r.Method = "GET"
+		})
+		It("isn't allowed", func() {
+			Expect(resp.StatusCode).To(Equal(http.StatusMethodNotAllowed))
+			Expect(resp.Header.Get("Allow")).To(Equal("POST"))
+		})
+	})
+})
@@ -0,0 +1,38 @@
+package endpoint
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/smartatransit/api-gateway/jwt"
+	jose "gopkg.in/square/go-jose.v2"
+)
+
+// NewJWKSEndpoint returns a new HTTP handler that publishes the public keys
+// of the gateway's internal tokens, so that upstream services can verify
+// them. Verifiers may cache the keys for maxAge.
+func NewJWKSEndpoint(logger *logrus.Logger, keys *jwt.SigningKeys, maxAge time.Duration) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		published, err := keys.PublicKeys(r.Context())
+		if err != nil {
+			logger.Errorf("failed loading internal token keys: %s", err.Error())
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%v", int64(maxAge/time.Second)))
+		_ = json.NewEncoder(w).Encode(struct {
+			Keys []jose.JSONWebKey `json:"keys"`
+		}{published})
+	})
+}
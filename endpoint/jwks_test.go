@@ -0,0 +1,80 @@
+package endpoint_test
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/smartatransit/api-gateway/endpoint"
+	"github.com/smartatransit/api-gateway/jwt"
+	"github.com/smartatransit/api-gateway/store"
+	"github.com/smartatransit/api-gateway/store/storefakes"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("NewJWKSEndpoint", func() {
+	var (
+		log  *logrus.Logger
+		s    store.Store
+		r    *http.Request
+		resp *http.Response
+	)
+
+	BeforeEach(func() {
+		log = logrus.New()
+		log.SetOutput(ioutil.Discard)
+
+		s = store.NewMemory("test", 100)
+		r, _ = http.NewRequest("GET", "/.well-known/jwks.json", nil)
+	})
+
+	JustBeforeEach(func() {
+		keys, err := jwt.NewSigningKeys(s, []byte("0123456789abcdef0123456789abcdef"), time.Hour)
+		Expect(err).To(BeNil())
+
+		w := httptest.NewRecorder()
+		endpoint.NewJWKSEndpoint(log, keys, 5*time.Minute).ServeHTTP(w, r)
+		resp = w.Result()
+	})
+
+	It("publishes the public keys", func() {
+		Expect(resp.StatusCode).To(Equal(http.StatusOK))
+		Expect(resp.Header.Get("Content-Type")).To(Equal("application/json"))
+		Expect(resp.Header.Get("Cache-Control")).To(Equal("public, max-age=300"))
+
+		var body struct {
+			Keys []map[string]interface{} `json:"keys"`
+		}
+		Expect(json.NewDecoder(resp.Body).Decode(&body)).To(Succeed())
+		Expect(body.Keys).To(HaveLen(3))
+		for _, k := range body.Keys {
+			Expect(k).To(HaveKeyWithValue("kty", "EC"))
+			Expect(k).To(HaveKeyWithValue("alg", "ES256"))
+			Expect(k).NotTo(HaveKey("d"))
+		}
+	})
+	When("the keys can't be loaded", func() {
+		BeforeEach(func() {
+			fake := &storefakes.FakeStore{}
+			fake.GetReturns("", errors.New("connection refused"))
+			s = fake
+		})
+		It("fails", func() {
+			Expect(resp.StatusCode).To(Equal(http.StatusInternalServerError))
+		})
+	})
+	When("the method isn't GET", func() {
+		BeforeEach(func() {
+			r.Method = "POST"
+		})
+		It("isn't allowed", func() {
+			Expect(resp.StatusCode).To(Equal(http.StatusMethodNotAllowed))
+		})
+	})
+})
@@ -0,0 +1,105 @@
+package endpoint
+
+import (
+	"errors"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/smartatransit/api-gateway/appsig"
+	"github.com/smartatransit/api-gateway/clientcert"
+	"github.com/smartatransit/api-gateway/jwt"
+)
+
+var (
+	verifyDecisions = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "api_gateway",
+		Subsystem: "verify",
+		Name:      "decisions_total",
+		Help:      "Verify decisions, by outcome (anonymous, bearer_ok, key_ok, basic_ok, cert_ok, rejected, or error) and the reason for rejections and errors.",
+	}, []string{"outcome", "reason"})
+
+	verifyDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: "api_gateway",
+		Subsystem: "verify",
+		Name:      "duration_seconds",
+		Help:      "Time taken to handle a verify request.",
+		Buckets:   prometheus.DefBuckets,
+	})
+
+	introspections = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "api_gateway",
+		Subsystem: "introspect",
+		Name:      "requests_total",
+		Help:      "Introspection requests, by result (active, inactive, unauthorized, invalid_request, or an identity provider failure).",
+	}, []string{"result"})
+)
+
+// parseErrorReasons label the verify rejections caused by each parser error
+var parseErrorReasons = []struct {
+	err    error
+	reason string
+}{
+	{jwt.ErrMalformedToken, "malformed_token"},
+	{jwt.ErrDisallowedAlgorithm, "disallowed_algorithm"},
+	{jwt.ErrUnrecognizedPublicKey, "unknown_key"},
+	{jwt.ErrInvalidSignature, "invalid_signature"},
+	{jwt.ErrTokenExpired, "expired"},
+	{jwt.ErrTokenNotYetValid, "not_yet_valid"},
+	{jwt.ErrTokenIssuedInFuture, "issued_in_future"},
+	{jwt.ErrInvalidIssuer, "invalid_issuer"},
+	{jwt.ErrInvalidAudience, "invalid_audience"},
+}
+
+// certErrorReasons label the verify rejections caused by each client
+// certificate error
+var certErrorReasons = []struct {
+	err    error
+	reason string
+}{
+	{clientcert.ErrMalformedCertificate, "malformed_certificate"},
+	{clientcert.ErrUntrustedCertificate, "untrusted_certificate"},
+	{clientcert.ErrUnmappedCertificate, "unmapped_certificate"},
+}
+
+func certErrorReason(err error) string {
+	for _, r := range certErrorReasons {
+		if errors.Is(err, r.err) {
+			return r.reason
+		}
+	}
+	return "invalid_certificate"
+}
+
+// appSignatureErrorReasons label the verify rejections caused by each app
+// signature error
+var appSignatureErrorReasons = []struct {
+	err    error
+	reason string
+}{
+	{appsig.ErrMalformedSignature, "malformed_app_signature"},
+	{appsig.ErrUnknownApp, "unknown_app"},
+	{appsig.ErrInvalidSignature, "invalid_app_signature"},
+	{appsig.ErrExpiredSignature, "expired_app_signature"},
+	{appsig.ErrReplayedNonce, "replayed_nonce"},
+}
+
+// appSignatureErrorReason reports whether err means the signature was bad,
+// rather than that it couldn't be checked, and labels it
+func appSignatureErrorReason(err error) (string, bool) {
+	for _, r := range appSignatureErrorReasons {
+		if errors.Is(err, r.err) {
+			return r.reason, true
+		}
+	}
+	return "", false
+}
+
+func parseErrorReason(err error) string {
+	for _, r := range parseErrorReasons {
+		if errors.Is(err, r.err) {
+			return r.reason
+		}
+	}
+	return "invalid_token"
+}
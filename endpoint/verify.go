@@ -2,34 +2,121 @@ package endpoint
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
-	"regexp"
 	"strings"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/sirupsen/logrus"
+	"github.com/smartatransit/api-gateway/appsig"
+	"github.com/smartatransit/api-gateway/clientcert"
 	"github.com/smartatransit/api-gateway/jwt"
+	"github.com/smartatransit/api-gateway/policy"
 )
 
 // NewVerifyEndpoint returns a new HTTP handler for requests to the
-// /v1/verify endpoint, which is used for forward-auth with traefik.
+// /v1/verify endpoint, which is used for forward-auth with traefik. Routes
+// may enable carriers for credentials besides the Authorization header. If
+// certs isn't nil, requests without credentials may authenticate with a
+// client certificate. If apps isn't nil, signed requests are verified and
+// their app identity is forwarded, and if requireSigned is set, anonymous
+// tokens are only handed to signed requests. If minter isn't nil, an
+// internal token is minted for each verified request and returned in
+// mintHeader.
 func NewVerifyEndpoint(
 	logger *logrus.Logger,
 	parser jwt.Parser,
 	anon jwt.Tokener,
 	tCache jwt.TokenCache,
 	apiKeys jwt.TokenerFactory,
+	hasher jwt.CredentialHasher,
+	authorizer policy.Authorizer,
+	carriers Carriers,
+	certs clientcert.Authenticator,
+	apps appsig.Authenticator,
+	requireSigned bool,
+	minter jwt.Minter,
+	mintHeader string,
 ) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		authHeader, ok := r.Header["Authorization"]
-		if !ok || len(authHeader) == 0 {
+		timer := prometheus.NewTimer(verifyDuration)
+		defer timer.ObserveDuration()
+
+		id := requestID(r)
+		log := logger.WithField("request_id", id)
+
+		reject := func(status int, reason string) {
+			outcome := "rejected"
+			if status >= http.StatusInternalServerError {
+				outcome = "error"
+			}
+			verifyDecisions.WithLabelValues(outcome, reason).Inc()
+			writeError(w, id, status, reason)
+		}
+
+		var (
+			auth     jwt.Authorization
+			app      appsig.Identity
+			signed   bool
+			fromCert bool
+			err      error
+		)
+
+		if apps != nil {
+			app, err = apps.Authenticate(r.Context(), r)
+			if reason, ok := appSignatureErrorReason(err); ok {
+				log.Warnf("rejected app signature: %s", err.Error())
+				reject(http.StatusUnauthorized, reason)
+				return
+			} else if err != nil && !errors.Is(err, appsig.ErrUnsigned) {
+				log.Errorf("failed verifying app signature: %s", err.Error())
+				reject(http.StatusInternalServerError, "app_signature_failed")
+				return
+			}
+			signed = err == nil
+		}
+
+		// The route decides where else credentials may be carried
+		req, reqErr := policy.FromForwarded(r)
+
+		authHeader := r.Header["Authorization"]
+		if len(authHeader) == 0 && reqErr == nil {
+			if carried, ok := carriers.authorization(r, authorizer.Carriers(req)); ok {
+				authHeader = []string{carried}
+			}
+		}
+		if len(authHeader) == 0 && certs != nil {
+			auth, err = certs.Authenticate(r)
+			if err != nil && !errors.Is(err, clientcert.ErrNoCertificate) {
+				log.Warnf("rejected client certificate: %s", err.Error())
+				reject(http.StatusUnauthorized, certErrorReason(err))
+				return
+			}
+			fromCert = err == nil
+		}
+
+		if len(authHeader) == 0 && !fromCert {
+			if requireSigned && !signed {
+				reject(http.StatusUnauthorized, "unsigned_request")
+				return
+			}
+
 			tokenString, err := anon.GetToken(r.Context())
 			if err != nil {
-				logger.Errorf("failed to generate anonymous token: %s", err.Error())
-				w.WriteHeader(http.StatusInternalServerError)
+				log.Errorf("failed to generate anonymous token: %s", err.Error())
+				if status, reason, ok := identityProviderError(err); ok {
+					reject(status, reason)
+				} else {
+					reject(http.StatusInternalServerError, "anonymous_token_failed")
+				}
 				return
 			}
 
+			verifyDecisions.WithLabelValues("anonymous", "").Inc()
+
+			w.Header().Set("WWW-Authenticate", bearerChallenge)
+			w.Header().Set(RequestIDHeader, id)
 			w.Header().Set("Content-Type", "application/json")
 			w.WriteHeader(http.StatusUnauthorized)
 			_ = json.NewEncoder(w).Encode(map[string]interface{}{
@@ -39,48 +126,138 @@ func NewVerifyEndpoint(
 			return
 		}
 
-		var key, token string
-		if strings.HasPrefix(authHeader[0], "Key ") {
-			key = strings.TrimPrefix(authHeader[0], "Key ")
+		var (
+			key       jwt.CredentialKey
+			fromKey   bool
+			fromBasic bool
+			token     string
+
+			clientID, clientSecret string
+		)
+		switch {
+		case fromCert:
+			// The certificate has already been verified and mapped
+		case strings.HasPrefix(authHeader[0], "Key "):
 			var ok bool
-			if token, ok = tCache.FetchToken(r.Context(), key); !ok {
-				results := regexp.MustCompile(`^([^|]+)\|([^|]+)$`).FindStringSubmatch(key)
-				if len(results) == 0 {
-					w.WriteHeader(http.StatusUnauthorized)
-					return
-				}
-				clientID := results[1]
-				clientSecret := results[2]
+			clientID, clientSecret, ok = parseKey(strings.TrimPrefix(authHeader[0], "Key "))
+			if !ok {
+				reject(http.StatusUnauthorized, "malformed_authorization")
+				return
+			}
+			fromKey = true
+		case strings.HasPrefix(authHeader[0], "Basic "):
+			var ok bool
+			clientID, clientSecret, ok = r.BasicAuth()
+			if !ok || clientID == "" || clientSecret == "" {
+				w.Header().Set("WWW-Authenticate", basicChallenge)
+				reject(http.StatusUnauthorized, "malformed_authorization")
+				return
+			}
+			fromKey, fromBasic = true, true
+		case strings.HasPrefix(authHeader[0], "Bearer "):
+			token = strings.TrimPrefix(authHeader[0], "Bearer ")
+		default:
+			reject(http.StatusUnauthorized, "malformed_authorization")
+			return
+		}
 
-				var err error
+		if fromKey {
+			key = hasher.Key(clientID, clientSecret)
+
+			// The cache is only an optimization, so if it's unavailable we
+			// carry on and mint a new token.
+			var ok bool
+			token, ok, err = tCache.FetchToken(r.Context(), key)
+			if err != nil {
+				log.Warnf("failed to fetch cached token for client `%s`: %s", clientID, err.Error())
+			}
+			if !ok {
 				token, err = apiKeys(clientID, clientSecret).GetToken(r.Context())
 				if err != nil {
-					logger.Errorf("failed to generate API key token for client `%s`: %s", clientID, err.Error())
-					w.WriteHeader(http.StatusUnauthorized)
+					log.Errorf("failed to generate API key token for client `%s`: %s", clientID, err.Error())
+					if status, reason, ok := identityProviderError(err); ok {
+						reject(status, reason)
+						return
+					}
+					if fromBasic {
+						w.Header().Set("WWW-Authenticate", basicChallenge)
+					}
+					reject(http.StatusUnauthorized, "key_exchange_failed")
 					return
 				}
 			}
-		} else {
-			if !strings.HasPrefix(authHeader[0], "Bearer ") {
-				w.WriteHeader(http.StatusUnauthorized)
+		}
+
+		if !fromCert {
+			auth, err = parser.ParseToken(r.Context(), token)
+			if err != nil {
+				if status, reason, ok := identityProviderError(err); ok {
+					log.Errorf("failed verifying token: %s", err.Error())
+					reject(status, reason)
+					return
+				}
+				reject(http.StatusUnauthorized, parseErrorReason(err))
 				return
 			}
-			token = strings.TrimPrefix(authHeader[0], "Bearer ")
 		}
 
-		auth, err := parser.ParseToken(r.Context(), token)
-		if err != nil {
-			w.WriteHeader(http.StatusUnauthorized)
+		if reqErr != nil {
+			reject(http.StatusBadRequest, "malformed_forwarded_request")
 			return
 		}
 
-		if len(key) > 0 {
+		if fromKey {
 			// If this token was obtained from a key, let's go ahead and save it to the cache
 			// (now that we've parsed it and know when it will expire).
-			tCache.AddToken(r.Context(), key, token, time.Unix(auth.StandardClaims.ExpiresAt, 0).UTC())
+			if err := tCache.AddToken(r.Context(), key, token, time.Unix(auth.StandardClaims.ExpiresAt, 0).UTC()); err != nil {
+				log.Warnf("failed to cache token for client `%s`: %s", key.ClientID, err.Error())
+			}
+		}
+
+		if !authorizer.Authorize(req, auth.Role) {
+			reject(http.StatusForbidden, "forbidden")
+			return
 		}
 
+		if minter != nil {
+			internalToken, err := minter.Mint(r.Context(), auth)
+			if err != nil {
+				log.Errorf("failed to mint internal token: %s", err.Error())
+				reject(http.StatusInternalServerError, "internal_token_failed")
+				return
+			}
+			w.Header().Set(mintHeader, internalToken)
+		}
+
+		switch {
+		case fromCert:
+			verifyDecisions.WithLabelValues("cert_ok", "").Inc()
+		case fromBasic:
+			verifyDecisions.WithLabelValues("basic_ok", "").Inc()
+		case fromKey:
+			verifyDecisions.WithLabelValues("key_ok", "").Inc()
+		default:
+			verifyDecisions.WithLabelValues("bearer_ok", "").Inc()
+		}
+
+		if signed {
+			app.SetHeaders(w)
+		}
 		auth.SetAuthHeaders(w)
+		w.Header().Set(RequestIDHeader, id)
 		w.WriteHeader(http.StatusOK)
 	})
 }
+
+// basicChallenge is sent with rejections of Basic credentials
+const basicChallenge = `Basic realm="api", charset="UTF-8"`
+
+// parseKey splits the credentials of the Key scheme, `id|secret`, on the
+// first `|`, since client IDs can't contain one but secrets can
+func parseKey(credentials string) (string, string, bool) {
+	i := strings.IndexByte(credentials, '|')
+	if i <= 0 || i == len(credentials)-1 {
+		return "", "", false
+	}
+	return credentials[:i], credentials[i+1:], true
+}
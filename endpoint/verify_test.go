@@ -1,22 +1,60 @@
 package endpoint_test
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/sirupsen/logrus"
+	"github.com/smartatransit/api-gateway/appsig"
+	"github.com/smartatransit/api-gateway/appsig/appsigfakes"
+	"github.com/smartatransit/api-gateway/clientcert"
+	"github.com/smartatransit/api-gateway/clientcert/clientcertfakes"
 	"github.com/smartatransit/api-gateway/endpoint"
 	"github.com/smartatransit/api-gateway/jwt"
 	"github.com/smartatransit/api-gateway/jwt/jwtfakes"
+	"github.com/smartatransit/api-gateway/policy"
+	"github.com/smartatransit/api-gateway/policy/policyfakes"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 	. "github.com/onsi/gomega/gstruct"
 )
 
+// decisionCounts reads the verify decisions counter from the default
+// registry, keyed by "outcome/reason".
+func decisionCounts() map[string]float64 {
+	families, err := prometheus.DefaultGatherer.Gather()
+	Expect(err).To(BeNil())
+
+	counts := map[string]float64{}
+	for _, family := range families {
+		if family.GetName() != "api_gateway_verify_decisions_total" {
+			continue
+		}
+		for _, m := range family.GetMetric() {
+			labels := map[string]string{}
+			for _, l := range m.GetLabel() {
+				labels[l.GetName()] = l.GetValue()
+			}
+			counts[labels["outcome"]+"/"+labels["reason"]] = m.GetCounter().GetValue()
+		}
+	}
+	return counts
+}
+
+// errorBody decodes a rejection's JSON body
+func errorBody(resp *http.Response) map[string]string {
+	body := map[string]string{}
+	Expect(json.NewDecoder(resp.Body).Decode(&body)).To(Succeed())
+	return body
+}
+
 var _ = Describe("NewVerifyEndpoint", func() {
 	var (
 		log    *logrus.Logger
@@ -24,18 +62,45 @@ var _ = Describe("NewVerifyEndpoint", func() {
 		anon   *jwtfakes.FakeTokener
 		fact   *jwtfakes.FakeTokenerFactory
 		tCache *jwtfakes.FakeTokenCache
+		authz  *policyfakes.FakeAuthorizer
+		certs  clientcert.Authenticator
+		apps   appsig.Authenticator
+		minter jwt.Minter
+		hasher jwt.CredentialHasher
+
+		carriers      endpoint.Carriers
+		requireSigned bool
 
 		r *http.Request
 		w *httptest.ResponseRecorder
 
 		resp *http.Response
+
+		countsBefore map[string]float64
 	)
 
+	// decided is how many decisions have been counted for the outcome and
+	// reason during this test
+	decided := func(outcome, reason string) float64 {
+		key := outcome + "/" + reason
+		return decisionCounts()[key] - countsBefore[key]
+	}
+
 	BeforeEach(func() {
+		countsBefore = decisionCounts()
+
 		parser = &jwtfakes.FakeParser{}
 		anon = &jwtfakes.FakeTokener{}
 		fact = &jwtfakes.FakeTokenerFactory{}
 		tCache = &jwtfakes.FakeTokenCache{}
+		authz = &policyfakes.FakeAuthorizer{}
+		certs = nil
+		apps = nil
+		carriers = endpoint.Carriers{Cookie: "smarta_token"}
+		requireSigned = false
+		minter = nil
+		authz.AuthorizeReturns(true)
+		hasher, _ = jwt.NewCredentialHasher([]byte("pepper"))
 
 		log = logrus.New()
 		log.SetOutput(ioutil.Discard)
@@ -52,7 +117,7 @@ var _ = Describe("NewVerifyEndpoint", func() {
 	})
 
 	JustBeforeEach(func() {
-		endpoint.NewVerifyEndpoint(log, parser, anon, tCache, fact.Spy).
+		endpoint.NewVerifyEndpoint(log, parser, anon, tCache, fact.Spy, hasher, authz, carriers, certs, apps, requireSigned, minter, "X-Smarta-Internal-Token").
 			ServeHTTP(w, r)
 
 		resp = w.Result()
@@ -68,6 +133,28 @@ var _ = Describe("NewVerifyEndpoint", func() {
 			})
 			It("fails", func() {
 				Expect(resp.StatusCode).To(Equal(http.StatusInternalServerError))
+				Expect(decided("error", "anonymous_token_failed")).To(Equal(1.0))
+				Expect(errorBody(resp)).To(HaveKeyWithValue("error", "anonymous_token_failed"))
+			})
+		})
+		When("the identity provider is unavailable", func() {
+			BeforeEach(func() {
+				anon.GetTokenReturns("", fmt.Errorf("%w: connection refused", jwt.ErrTokenEndpointUnavailable))
+			})
+			It("asks the client to retry", func() {
+				Expect(resp.StatusCode).To(Equal(http.StatusServiceUnavailable))
+				Expect(resp.Header.Get("Retry-After")).To(Equal("5"))
+				Expect(decided("error", "identity_provider_unavailable")).To(Equal(1.0))
+				Expect(errorBody(resp)).To(HaveKeyWithValue("error", "identity_provider_unavailable"))
+			})
+		})
+		When("the request runs out of time waiting for the identity provider", func() {
+			BeforeEach(func() {
+				anon.GetTokenReturns("", context.DeadlineExceeded)
+			})
+			It("asks the client to retry", func() {
+				Expect(resp.StatusCode).To(Equal(http.StatusServiceUnavailable))
+				Expect(resp.Header.Get("Retry-After")).To(Equal("5"))
 			})
 		})
 		When("all goes well", func() {
@@ -76,7 +163,9 @@ var _ = Describe("NewVerifyEndpoint", func() {
 				Expect(json.NewDecoder(resp.Body).Decode(&body)).To(BeNil())
 
 				Expect(resp.StatusCode).To(Equal(http.StatusUnauthorized))
+				Expect(resp.Header.Get("WWW-Authenticate")).To(Equal(`Bearer realm="api"`))
 				Expect(body["token"]).To(Equal("good-token"))
+				Expect(decided("anonymous", "")).To(Equal(1.0))
 			})
 		})
 	})
@@ -87,6 +176,7 @@ var _ = Describe("NewVerifyEndpoint", func() {
 			})
 			It("fails", func() {
 				Expect(resp.StatusCode).To(Equal(http.StatusUnauthorized))
+				Expect(decided("rejected", "malformed_authorization")).To(Equal(1.0))
 			})
 		})
 		When("there's no cached token, and the Auth0 request fails", func() {
@@ -101,11 +191,41 @@ var _ = Describe("NewVerifyEndpoint", func() {
 			})
 			It("fails", func() {
 				Expect(resp.StatusCode).To(Equal(http.StatusUnauthorized))
+				Expect(decided("rejected", "key_exchange_failed")).To(Equal(1.0))
 				id, secret := fact.ArgsForCall(0)
 				Expect(id).To(Equal("id"))
 				Expect(secret).To(Equal("secret"))
 			})
 		})
+		When("the identity provider is unavailable", func() {
+			BeforeEach(func() {
+				r.Header.Set("Authorization", "Key id|secret")
+
+				tokener := &jwtfakes.FakeTokener{}
+				tokener.GetTokenReturns("", fmt.Errorf("%w: circuit breaker is open", jwt.ErrTokenEndpointUnavailable))
+				fact.Returns(tokener)
+			})
+			It("asks the client to retry", func() {
+				Expect(resp.StatusCode).To(Equal(http.StatusServiceUnavailable))
+				Expect(resp.Header.Get("Retry-After")).To(Equal("5"))
+				Expect(resp.Header).NotTo(HaveKey("Www-Authenticate"))
+				Expect(decided("error", "identity_provider_unavailable")).To(Equal(1.0))
+			})
+		})
+		When("the identity provider fails", func() {
+			BeforeEach(func() {
+				r.Header.Set("Authorization", "Key id|secret")
+
+				tokener := &jwtfakes.FakeTokener{}
+				tokener.GetTokenReturns("", fmt.Errorf("%w: status code 500", jwt.ErrTokenEndpointFailed))
+				fact.Returns(tokener)
+			})
+			It("reports a bad gateway", func() {
+				Expect(resp.StatusCode).To(Equal(http.StatusBadGateway))
+				Expect(resp.Header.Get("Retry-After")).To(Equal("5"))
+				Expect(errorBody(resp)).To(HaveKeyWithValue("error", "identity_provider_failed"))
+			})
+		})
 		When("all goes well", func() {
 			BeforeEach(func() {
 				r.Header.Set("Authorization", "Key id|secret")
@@ -117,6 +237,7 @@ var _ = Describe("NewVerifyEndpoint", func() {
 			})
 			It("succeeds", func() {
 				Expect(resp.StatusCode).To(Equal(http.StatusOK))
+				Expect(decided("key_ok", "")).To(Equal(1.0))
 
 				id, secret := fact.ArgsForCall(0)
 				Expect(id).To(Equal("id"))
@@ -125,6 +246,195 @@ var _ = Describe("NewVerifyEndpoint", func() {
 				_, token := parser.ParseTokenArgsForCall(0)
 				Expect(token).To(Equal("my-special-token"))
 			})
+			It("caches the token under a digest of the credentials", func() {
+				_, key, token, _ := tCache.AddTokenArgsForCall(0)
+				Expect(key).To(Equal(hasher.Key("id", "secret")))
+				Expect(key.ClientID).To(Equal("id"))
+				Expect(key.Digest).NotTo(ContainSubstring("secret"))
+				Expect(token).To(Equal("my-special-token"))
+			})
+		})
+		When("there's a cached token", func() {
+			BeforeEach(func() {
+				r.Header.Set("Authorization", "Key id|secret")
+				tCache.FetchTokenReturns("cached-token", true, nil)
+			})
+			It("uses it", func() {
+				Expect(resp.StatusCode).To(Equal(http.StatusOK))
+				Expect(fact.CallCount()).To(Equal(0))
+
+				_, key := tCache.FetchTokenArgsForCall(0)
+				Expect(key).To(Equal(hasher.Key("id", "secret")))
+
+				_, token := parser.ParseTokenArgsForCall(0)
+				Expect(token).To(Equal("cached-token"))
+			})
+		})
+		When("the token cache is unavailable", func() {
+			BeforeEach(func() {
+				r.Header.Set("Authorization", "Key id|secret")
+				tCache.FetchTokenReturns("", false, errors.New("connection refused"))
+				tCache.AddTokenReturns(errors.New("connection refused"))
+
+				tokener := &jwtfakes.FakeTokener{}
+				tokener.GetTokenReturns("my-special-token", nil)
+				fact.Returns(tokener)
+			})
+			It("mints a new token anyway", func() {
+				Expect(resp.StatusCode).To(Equal(http.StatusOK))
+				_, token := parser.ParseTokenArgsForCall(0)
+				Expect(token).To(Equal("my-special-token"))
+			})
+		})
+	})
+	When("the Key secret contains a `|`", func() {
+		BeforeEach(func() {
+			r.Header.Set("Authorization", "Key id|sec|ret")
+
+			tokener := &jwtfakes.FakeTokener{}
+			tokener.GetTokenReturns("my-special-token", nil)
+			fact.Returns(tokener)
+		})
+		It("splits on the first one", func() {
+			Expect(resp.StatusCode).To(Equal(http.StatusOK))
+			id, secret := fact.ArgsForCall(0)
+			Expect(id).To(Equal("id"))
+			Expect(secret).To(Equal("sec|ret"))
+		})
+	})
+	When("there's a Basic schema", func() {
+		BeforeEach(func() {
+			r.SetBasicAuth("id", "sec:ret|")
+
+			tokener := &jwtfakes.FakeTokener{}
+			tokener.GetTokenReturns("my-special-token", nil)
+			fact.Returns(tokener)
+		})
+		It("exchanges the credentials like a key", func() {
+			Expect(resp.StatusCode).To(Equal(http.StatusOK))
+			Expect(decided("basic_ok", "")).To(Equal(1.0))
+
+			id, secret := fact.ArgsForCall(0)
+			Expect(id).To(Equal("id"))
+			Expect(secret).To(Equal("sec:ret|"))
+
+			_, token := parser.ParseTokenArgsForCall(0)
+			Expect(token).To(Equal("my-special-token"))
+
+			_, key, _, _ := tCache.AddTokenArgsForCall(0)
+			Expect(key).To(Equal(hasher.Key("id", "sec:ret|")))
+		})
+		When("there's a cached token", func() {
+			BeforeEach(func() {
+				tCache.FetchTokenReturns("cached-token", true, nil)
+			})
+			It("uses it", func() {
+				Expect(resp.StatusCode).To(Equal(http.StatusOK))
+				Expect(fact.CallCount()).To(Equal(0))
+			})
+		})
+		When("it's malformed", func() {
+			BeforeEach(func() {
+				r.Header.Set("Authorization", "Basic not-base64!")
+			})
+			It("challenges the client", func() {
+				Expect(resp.StatusCode).To(Equal(http.StatusUnauthorized))
+				Expect(resp.Header.Get("WWW-Authenticate")).To(Equal(`Basic realm="api", charset="UTF-8"`))
+				Expect(decided("rejected", "malformed_authorization")).To(Equal(1.0))
+			})
+		})
+		When("the secret is empty", func() {
+			BeforeEach(func() {
+				r.SetBasicAuth("id", "")
+			})
+			It("challenges the client", func() {
+				Expect(resp.StatusCode).To(Equal(http.StatusUnauthorized))
+				Expect(resp.Header.Get("WWW-Authenticate")).To(HavePrefix("Basic "))
+				Expect(fact.CallCount()).To(Equal(0))
+			})
+		})
+		When("the credentials are rejected", func() {
+			BeforeEach(func() {
+				tokener := &jwtfakes.FakeTokener{}
+				tokener.GetTokenReturns("", errors.New("get token failed"))
+				fact.Returns(tokener)
+			})
+			It("challenges the client", func() {
+				Expect(resp.StatusCode).To(Equal(http.StatusUnauthorized))
+				Expect(resp.Header.Get("WWW-Authenticate")).To(HavePrefix("Basic "))
+				Expect(decided("rejected", "key_exchange_failed")).To(Equal(1.0))
+			})
+		})
+	})
+	When("the route enables other credential carriers", func() {
+		BeforeEach(func() {
+			authz.CarriersReturns([]string{policy.CarrierAPIKey, policy.CarrierCookie, policy.CarrierQuery})
+			r.Header.Del("Authorization")
+			r.Header.Set("X-Forwarded-Uri", "/v1/realtime?station=5&access_token=query-token")
+
+			tokener := &jwtfakes.FakeTokener{}
+			tokener.GetTokenReturns("key-token", nil)
+			fact.Returns(tokener)
+		})
+		It("looks the carriers up by the forwarded request", func() {
+			req := authz.CarriersArgsForCall(0)
+			Expect(req.Path).To(Equal("/v1/realtime"))
+		})
+		It("accepts a token in the query", func() {
+			Expect(resp.StatusCode).To(Equal(http.StatusOK))
+			_, token := parser.ParseTokenArgsForCall(0)
+			Expect(token).To(Equal("query-token"))
+		})
+		When("there's also a cookie", func() {
+			BeforeEach(func() {
+				r.AddCookie(&http.Cookie{Name: "smarta_token", Value: "cookie-token"})
+			})
+			It("prefers the cookie", func() {
+				_, token := parser.ParseTokenArgsForCall(0)
+				Expect(token).To(Equal("cookie-token"))
+			})
+			When("there's also an X-API-Key header", func() {
+				BeforeEach(func() {
+					r.Header.Set("X-API-Key", "id|secret")
+				})
+				It("prefers the key", func() {
+					Expect(resp.StatusCode).To(Equal(http.StatusOK))
+					Expect(decided("key_ok", "")).To(Equal(1.0))
+					id, secret := fact.ArgsForCall(0)
+					Expect(id).To(Equal("id"))
+					Expect(secret).To(Equal("secret"))
+				})
+				When("there's also an Authorization header", func() {
+					BeforeEach(func() {
+						r.Header.Set("Authorization", "Bearer header-token")
+					})
+					It("prefers the header", func() {
+						Expect(authz.CarriersCallCount()).To(Equal(0))
+						_, token := parser.ParseTokenArgsForCall(0)
+						Expect(token).To(Equal("header-token"))
+					})
+				})
+			})
+		})
+		When("the cookie carrier isn't configured", func() {
+			BeforeEach(func() {
+				carriers.Cookie = ""
+				r.AddCookie(&http.Cookie{Name: "smarta_token", Value: "cookie-token"})
+			})
+			It("ignores cookies", func() {
+				_, token := parser.ParseTokenArgsForCall(0)
+				Expect(token).To(Equal("query-token"))
+			})
+		})
+		When("the route doesn't enable the carrier", func() {
+			BeforeEach(func() {
+				authz.CarriersReturns([]string{policy.CarrierCookie})
+			})
+			It("returns an anonymous token", func() {
+				Expect(resp.StatusCode).To(Equal(http.StatusUnauthorized))
+				Expect(parser.ParseTokenCallCount()).To(Equal(0))
+				Expect(anon.GetTokenCallCount()).To(Equal(1))
+			})
 		})
 	})
 	When("the Bearer schema is malformed", func() {
@@ -141,6 +451,262 @@ var _ = Describe("NewVerifyEndpoint", func() {
 		})
 		It("fails", func() {
 			Expect(resp.StatusCode).To(Equal(http.StatusUnauthorized))
+			Expect(decided("rejected", "invalid_token")).To(Equal(1.0))
+		})
+	})
+	When("the keys to verify the token can't be fetched", func() {
+		BeforeEach(func() {
+			parser.ParseTokenReturns(jwt.Authorization{}, fmt.Errorf("failed parsing JWT: %w", jwt.ErrKeysUnavailable))
+		})
+		It("asks the client to retry", func() {
+			Expect(resp.StatusCode).To(Equal(http.StatusServiceUnavailable))
+			Expect(resp.Header.Get("Retry-After")).To(Equal("5"))
+			Expect(errorBody(resp)).To(HaveKeyWithValue("error", "identity_provider_unavailable"))
+			Expect(decided("error", "identity_provider_unavailable")).To(Equal(1.0))
+		})
+	})
+	When("the token has expired", func() {
+		BeforeEach(func() {
+			parser.ParseTokenReturns(jwt.Authorization{}, fmt.Errorf("failed validating JWT: %w", jwt.ErrTokenExpired))
+		})
+		It("counts the reason", func() {
+			Expect(resp.StatusCode).To(Equal(http.StatusUnauthorized))
+			Expect(decided("rejected", "expired")).To(Equal(1.0))
+		})
+		It("says why in an RFC 6750 challenge", func() {
+			Expect(resp.Header.Get("WWW-Authenticate")).To(Equal(
+				`Bearer realm="api", error="invalid_token", error_description="The token has expired."`,
+			))
+		})
+		It("says why in the body, with a request ID", func() {
+			Expect(resp.Header.Get("Content-Type")).To(Equal("application/json"))
+			body := errorBody(resp)
+			Expect(body).To(HaveKeyWithValue("error", "expired"))
+			Expect(body).To(HaveKeyWithValue("error_description", "The token has expired."))
+			Expect(body["request_id"]).To(MatchRegexp(`^[0-9a-f]{32}$`))
+			Expect(resp.Header.Get("X-Request-Id")).To(Equal(body["request_id"]))
+		})
+		When("the request already has an ID", func() {
+			BeforeEach(func() {
+				r.Header.Set("X-Request-Id", "traefik-1234")
+			})
+			It("keeps it", func() {
+				Expect(errorBody(resp)).To(HaveKeyWithValue("request_id", "traefik-1234"))
+			})
+		})
+		When("the request's ID is malformed", func() {
+			BeforeEach(func() {
+				r.Header.Set("X-Request-Id", "bad id\r\nX-Injected: 1")
+			})
+			It("replaces it", func() {
+				Expect(errorBody(resp)["request_id"]).To(MatchRegexp(`^[0-9a-f]{32}$`))
+			})
+		})
+	})
+	When("the forwarded URI is malformed", func() {
+		BeforeEach(func() {
+			r.Header.Set("X-Forwarded-Uri", "/path/%zz")
+		})
+		It("fails", func() {
+			Expect(resp.StatusCode).To(Equal(http.StatusBadRequest))
+			Expect(authz.AuthorizeCallCount()).To(Equal(0))
+			Expect(decided("rejected", "malformed_forwarded_request")).To(Equal(1.0))
+		})
+	})
+	When("the role isn't allowed to reach the route", func() {
+		BeforeEach(func() {
+			r.Header.Set("X-Forwarded-Method", "POST")
+			r.Header.Set("X-Forwarded-Host", "api.smartatransit.com")
+			r.Header.Set("X-Forwarded-Uri", "/admin//../admin/users?page=2")
+			authz.AuthorizeReturns(false)
+		})
+		It("forbids the request", func() {
+			Expect(resp.StatusCode).To(Equal(http.StatusForbidden))
+			Expect(resp.Header).NotTo(HaveKey("X-Smarta-Auth-Role"))
+			Expect(resp.Header.Get("WWW-Authenticate")).To(ContainSubstring(`error="insufficient_scope"`))
+			Expect(errorBody(resp)).To(HaveKeyWithValue("error", "forbidden"))
+			Expect(decided("rejected", "forbidden")).To(Equal(1.0))
+
+			req, role := authz.AuthorizeArgsForCall(0)
+			Expect(req).To(Equal(policy.Request{
+				Method: "POST",
+				Host:   "api.smartatransit.com",
+				Path:   "/admin/users",
+			}))
+			Expect(role).To(Equal("Role-Value"))
+		})
+	})
+	When("app signatures are verified", func() {
+		var fake *appsigfakes.FakeAuthenticator
+		BeforeEach(func() {
+			fake = &appsigfakes.FakeAuthenticator{}
+			fake.AuthenticateReturns(appsig.Identity{App: "ios", Version: "4.2.0"}, nil)
+			apps = fake
+		})
+		It("forwards the app identity", func() {
+			Expect(resp.StatusCode).To(Equal(http.StatusOK))
+			Expect(resp.Header).To(MatchKeys(IgnoreExtras, Keys{
+				"X-Smarta-App":          ConsistOf(Equal("ios")),
+				"X-Smarta-App-Version":  ConsistOf(Equal("4.2.0")),
+				"X-Smarta-Auth-Session": ConsistOf(Equal("Session-Value")),
+			}))
+		})
+		When("the request isn't signed", func() {
+			BeforeEach(func() {
+				fake.AuthenticateReturns(appsig.Identity{}, appsig.ErrUnsigned)
+			})
+			It("doesn't forward an app identity", func() {
+				Expect(resp.StatusCode).To(Equal(http.StatusOK))
+				Expect(resp.Header).NotTo(HaveKey("X-Smarta-App"))
+			})
+			When("there's no Authorization header", func() {
+				BeforeEach(func() {
+					r.Header.Del("Authorization")
+				})
+				It("returns an anonymous token", func() {
+					Expect(resp.StatusCode).To(Equal(http.StatusUnauthorized))
+					Expect(anon.GetTokenCallCount()).To(Equal(1))
+				})
+				When("signatures are required for anonymous tokens", func() {
+					BeforeEach(func() {
+						requireSigned = true
+					})
+					It("fails", func() {
+						Expect(resp.StatusCode).To(Equal(http.StatusUnauthorized))
+						Expect(anon.GetTokenCallCount()).To(Equal(0))
+						Expect(decided("rejected", "unsigned_request")).To(Equal(1.0))
+					})
+				})
+			})
+		})
+		When("signatures are required for anonymous tokens and the request is signed", func() {
+			BeforeEach(func() {
+				requireSigned = true
+				r.Header.Del("Authorization")
+			})
+			It("returns an anonymous token", func() {
+				Expect(resp.StatusCode).To(Equal(http.StatusUnauthorized))
+				Expect(anon.GetTokenCallCount()).To(Equal(1))
+			})
+		})
+		When("the nonce has been used before", func() {
+			BeforeEach(func() {
+				fake.AuthenticateReturns(appsig.Identity{}, appsig.ErrReplayedNonce)
+			})
+			It("fails", func() {
+				Expect(resp.StatusCode).To(Equal(http.StatusUnauthorized))
+				Expect(parser.ParseTokenCallCount()).To(Equal(0))
+				Expect(decided("rejected", "replayed_nonce")).To(Equal(1.0))
+			})
+		})
+		When("the signature can't be checked", func() {
+			BeforeEach(func() {
+				fake.AuthenticateReturns(appsig.Identity{}, errors.New("failed checking nonce: connection refused"))
+			})
+			It("fails", func() {
+				Expect(resp.StatusCode).To(Equal(http.StatusInternalServerError))
+				Expect(decided("error", "app_signature_failed")).To(Equal(1.0))
+			})
+		})
+	})
+	When("client certificates are accepted", func() {
+		var fake *clientcertfakes.FakeAuthenticator
+		BeforeEach(func() {
+			fake = &clientcertfakes.FakeAuthenticator{}
+			fake.AuthenticateReturns(jwt.Authorization{
+				Session:    "fare-system",
+				Role:       "partner_fares",
+				IssuerName: clientcert.IssuerName,
+			}, nil)
+			certs = fake
+			r.Header.Del("Authorization")
+		})
+		It("authenticates the request by its certificate", func() {
+			Expect(resp.StatusCode).To(Equal(http.StatusOK))
+			Expect(resp.Header).To(MatchKeys(IgnoreExtras, Keys{
+				"X-Smarta-Auth-Session": ConsistOf(Equal("fare-system")),
+				"X-Smarta-Auth-Role":    ConsistOf(Equal("partner_fares")),
+				"X-Smarta-Auth-Issuer":  ConsistOf(Equal("client_certificate")),
+			}))
+			Expect(parser.ParseTokenCallCount()).To(Equal(0))
+			Expect(anon.GetTokenCallCount()).To(Equal(0))
+			Expect(decided("cert_ok", "")).To(Equal(1.0))
+
+			_, role := authz.AuthorizeArgsForCall(0)
+			Expect(role).To(Equal("partner_fares"))
+		})
+		When("there's no certificate", func() {
+			BeforeEach(func() {
+				fake.AuthenticateReturns(jwt.Authorization{}, clientcert.ErrNoCertificate)
+			})
+			It("returns an anonymous token", func() {
+				Expect(resp.StatusCode).To(Equal(http.StatusUnauthorized))
+				Expect(anon.GetTokenCallCount()).To(Equal(1))
+				Expect(decided("anonymous", "")).To(Equal(1.0))
+			})
+		})
+		When("the certificate isn't trusted", func() {
+			BeforeEach(func() {
+				fake.AuthenticateReturns(jwt.Authorization{}, fmt.Errorf("%w: expired", clientcert.ErrUntrustedCertificate))
+			})
+			It("fails", func() {
+				Expect(resp.StatusCode).To(Equal(http.StatusUnauthorized))
+				Expect(anon.GetTokenCallCount()).To(Equal(0))
+				Expect(decided("rejected", "untrusted_certificate")).To(Equal(1.0))
+			})
+		})
+		When("the role isn't allowed to reach the route", func() {
+			BeforeEach(func() {
+				authz.AuthorizeReturns(false)
+			})
+			It("forbids the request", func() {
+				Expect(resp.StatusCode).To(Equal(http.StatusForbidden))
+			})
+		})
+		When("there's also an Authorization header", func() {
+			BeforeEach(func() {
+				r.Header.Set("Authorization", "Bearer token")
+			})
+			It("uses the header", func() {
+				Expect(resp.StatusCode).To(Equal(http.StatusOK))
+				Expect(fake.AuthenticateCallCount()).To(Equal(0))
+				Expect(decided("bearer_ok", "")).To(Equal(1.0))
+			})
+		})
+	})
+	When("internal tokens are minted", func() {
+		var fake *jwtfakes.FakeMinter
+		BeforeEach(func() {
+			fake = &jwtfakes.FakeMinter{}
+			fake.MintReturns("internal-token", nil)
+			minter = fake
+		})
+		It("forwards one for the verified identity", func() {
+			Expect(resp.StatusCode).To(Equal(http.StatusOK))
+			Expect(resp.Header.Get("X-Smarta-Internal-Token")).To(Equal("internal-token"))
+
+			_, auth := fake.MintArgsForCall(0)
+			Expect(auth.Session).To(Equal("Session-Value"))
+			Expect(auth.Role).To(Equal("Role-Value"))
+		})
+		When("the request is forbidden", func() {
+			BeforeEach(func() {
+				authz.AuthorizeReturns(false)
+			})
+			It("doesn't mint one", func() {
+				Expect(resp.StatusCode).To(Equal(http.StatusForbidden))
+				Expect(fake.MintCallCount()).To(Equal(0))
+			})
+		})
+		When("minting fails", func() {
+			BeforeEach(func() {
+				fake.MintReturns("", errors.New("store unavailable"))
+			})
+			It("fails", func() {
+				Expect(resp.StatusCode).To(Equal(http.StatusInternalServerError))
+				Expect(resp.Header).NotTo(HaveKey("X-Smarta-Auth-Role"))
+				Expect(decided("error", "internal_token_failed")).To(Equal(1.0))
+			})
 		})
 	})
 	Context("otherwise", func() {
@@ -149,6 +715,8 @@ var _ = Describe("NewVerifyEndpoint", func() {
 				"X-Smarta-Auth-Session": ConsistOf(Equal("Session-Value")),
 				"X-Smarta-Auth-Role":    ConsistOf(Equal("Role-Value")),
 			}))
+			Expect(resp.Header).NotTo(HaveKey("X-Smarta-Internal-Token"))
+			Expect(decided("bearer_ok", "")).To(Equal(1.0))
 		})
 	})
 })
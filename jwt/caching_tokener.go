@@ -0,0 +1,161 @@
+package jwt
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/sirupsen/logrus"
+)
+
+// NewCachingTokener wraps a Tokener so that its tokens are reused until
+// `expiryMargin` before they expire. Concurrent requests for a token that
+// isn't cached share a single call to the underlying Tokener, and once a
+// cached token is within `refreshAhead` of expiring, a replacement is
+// fetched in the background while the cached one continues to be served.
+// Short-lived tokens are refreshed halfway through their lifetime instead,
+// and kept until a quarter of their lifetime before they expire, so that
+// they aren't replaced as soon as they arrive. Failed background refreshes
+// are logged, since nobody is waiting for them.
+func NewCachingTokener(logger *logrus.Logger, tokener Tokener, expiryMargin, refreshAhead time.Duration) *CachingTokener {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &CachingTokener{
+		logger:       logger,
+		tokener:      tokener,
+		expiryMargin: expiryMargin,
+		refreshAhead: refreshAhead,
+		NoExpiryTTL:  5 * time.Minute,
+		Now:          time.Now,
+		ctx:          ctx,
+		cancel:       cancel,
+	}
+}
+
+// CachingTokener implements Tokener
+type CachingTokener struct {
+	logger       *logrus.Logger
+	tokener      Tokener
+	expiryMargin time.Duration
+	refreshAhead time.Duration
+
+	// NoExpiryTTL is how long tokens whose expiry can't be read are cached
+	NoExpiryTTL time.Duration
+	Now         func() time.Time
+
+	// ctx is cancelled by Close, and bounds the calls to the underlying
+	// Tokener, which outlive the requests that start them.
+	ctx     context.Context
+	cancel  context.CancelFunc
+	running sync.WaitGroup
+
+	mutex     sync.Mutex
+	token     string
+	staleAt   time.Time
+	refreshAt time.Time
+	inflight  *tokenCall
+}
+
+type tokenCall struct {
+	done  chan struct{}
+	token string
+	err   error
+}
+
+// GetToken returns the cached token if it's still fresh, and otherwise waits
+// for a new one.
+func (c *CachingTokener) GetToken(ctx context.Context) (string, error) {
+	c.mutex.Lock()
+	now := c.Now()
+	if c.token != "" && now.Before(c.staleAt) {
+		token := c.token
+		if !now.Before(c.refreshAt) && c.inflight == nil {
+			c.refresh(true)
+		}
+		c.mutex.Unlock()
+		return token, nil
+	}
+
+	call := c.inflight
+	if call == nil {
+		call = c.refresh(false)
+	}
+	c.mutex.Unlock()
+
+	select {
+	case <-call.done:
+		return call.token, call.err
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+}
+
+// refresh starts fetching a new token, in the background if a cached one
+// is still being served. The caller must hold the mutex.
+func (c *CachingTokener) refresh(background bool) *tokenCall {
+	call := &tokenCall{done: make(chan struct{})}
+	c.inflight = call
+
+	c.running.Add(1)
+	go func() {
+		defer c.running.Done()
+
+		// The call is shared between every waiting request, so it mustn't be
+		// bound to any one of their contexts.
+		token, err := c.tokener.GetToken(c.ctx)
+
+		c.mutex.Lock()
+		if err == nil {
+			now := c.Now()
+			expy, expErr := tokenExpiry(token)
+			if expErr != nil {
+				expy = now.Add(c.NoExpiryTTL)
+			}
+
+			lifetime := expy.Sub(now)
+			margin, ahead := c.expiryMargin, c.refreshAhead
+			if lifetime/4 < margin {
+				margin = lifetime / 4
+			}
+			if lifetime/2 < ahead {
+				ahead = lifetime / 2
+			}
+			c.token, c.staleAt, c.refreshAt = token, expy.Add(-margin), expy.Add(-ahead)
+		} else if background {
+			c.logger.Warnf("failed refreshing token in the background: %s", err.Error())
+		}
+		c.inflight = nil
+		c.mutex.Unlock()
+
+		call.token, call.err = token, err
+		close(call.done)
+	}()
+
+	return call
+}
+
+// Close cancels any token request in flight and waits for it to finish
+func (c *CachingTokener) Close() {
+	c.cancel()
+	c.running.Wait()
+}
+
+var errMissingExpiry = errors.New("token has no exp claim")
+
+// tokenExpiry reads the `exp` claim of a token without verifying it. It's
+// only used for tokens we've obtained directly from the token endpoint.
+func tokenExpiry(token string) (time.Time, error) {
+	var claims jwt.MapClaims
+	if _, _, err := new(jwt.Parser).ParseUnverified(token, &claims); err != nil {
+		return time.Time{}, fmt.Errorf("failed parsing token: %w", err)
+	}
+
+	exp, ok := claims["exp"].(float64)
+	if !ok {
+		return time.Time{}, errMissingExpiry
+	}
+
+	return time.Unix(int64(exp), 0).UTC(), nil
+}
@@ -0,0 +1,243 @@
+package jwt_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	djwt "github.com/dgrijalva/jwt-go"
+	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+
+	"github.com/smartatransit/api-gateway/jwt"
+	"github.com/smartatransit/api-gateway/jwt/jwtfakes"
+)
+
+func unsignedToken(claims djwt.MapClaims) string {
+	token, _ := djwt.NewWithClaims(djwt.SigningMethodHS256, claims).SignedString([]byte("secret"))
+	return token
+}
+
+var _ = Describe("CachingTokener", func() {
+	var (
+		inner *jwtfakes.FakeTokener
+		start time.Time
+		now   time.Time
+
+		firstToken  string
+		secondToken string
+
+		hook *test.Hook
+		ct   *jwt.CachingTokener
+	)
+	BeforeEach(func() {
+		start = time.Unix(1600000000, 0)
+		now = start
+
+		firstToken = unsignedToken(djwt.MapClaims{"sub": "first", "exp": start.Add(time.Hour).Unix()})
+		secondToken = unsignedToken(djwt.MapClaims{"sub": "second", "exp": start.Add(2 * time.Hour).Unix()})
+
+		inner = &jwtfakes.FakeTokener{}
+		inner.GetTokenReturnsOnCall(0, firstToken, nil)
+		inner.GetTokenReturnsOnCall(1, secondToken, nil)
+
+		var logger *logrus.Logger
+		logger, hook = test.NewNullLogger()
+		ct = jwt.NewCachingTokener(logger, inner, time.Minute, 10*time.Minute)
+		ct.Now = func() time.Time { return now }
+	})
+	AfterEach(func() {
+		ct.Close()
+	})
+	Describe("GetToken", func() {
+		It("reuses the token until shortly before it expires", func() {
+			token, err := ct.GetToken(context.Background())
+			Expect(err).To(BeNil())
+			Expect(token).To(Equal(firstToken))
+
+			now = start.Add(30 * time.Minute)
+			token, err = ct.GetToken(context.Background())
+			Expect(err).To(BeNil())
+			Expect(token).To(Equal(firstToken))
+			Expect(inner.GetTokenCallCount()).To(Equal(1))
+
+			now = start.Add(59*time.Minute + 30*time.Second)
+			token, err = ct.GetToken(context.Background())
+			Expect(err).To(BeNil())
+			Expect(token).To(Equal(secondToken))
+			Expect(inner.GetTokenCallCount()).To(Equal(2))
+		})
+		It("refreshes in the background ahead of expiry", func() {
+			_, _ = ct.GetToken(context.Background())
+
+			now = start.Add(55 * time.Minute)
+			token, err := ct.GetToken(context.Background())
+			Expect(err).To(BeNil())
+			Expect(token).To(Equal(firstToken))
+
+			Eventually(func() string {
+				token, _ := ct.GetToken(context.Background())
+				return token
+			}).Should(Equal(secondToken))
+			Expect(inner.GetTokenCallCount()).To(Equal(2))
+		})
+		It("collapses concurrent misses into a single call", func() {
+			release := make(chan struct{})
+			inner.GetTokenStub = func(context.Context) (string, error) {
+				<-release
+				return firstToken, nil
+			}
+
+			var wg sync.WaitGroup
+			tokens := make([]string, 50)
+			for i := range tokens {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					tokens[i], _ = ct.GetToken(context.Background())
+				}(i)
+			}
+
+			Consistently(inner.GetTokenCallCount, 100*time.Millisecond).Should(BeNumerically("<=", 1))
+			close(release)
+			wg.Wait()
+
+			Expect(inner.GetTokenCallCount()).To(Equal(1))
+			for _, token := range tokens {
+				Expect(token).To(Equal(firstToken))
+			}
+		})
+		When("a background refresh fails", func() {
+			BeforeEach(func() {
+				inner.GetTokenReturnsOnCall(1, "", errors.New("auth0 is down"))
+			})
+			It("keeps serving the cached token, and logs the failure", func() {
+				_, _ = ct.GetToken(context.Background())
+
+				now = start.Add(55 * time.Minute)
+				token, err := ct.GetToken(context.Background())
+				Expect(err).To(BeNil())
+				Expect(token).To(Equal(firstToken))
+
+				Eventually(hook.LastEntry).ShouldNot(BeNil())
+				Expect(hook.LastEntry().Level).To(Equal(logrus.WarnLevel))
+				Expect(hook.LastEntry().Message).To(Equal("failed refreshing token in the background: auth0 is down"))
+
+				token, err = ct.GetToken(context.Background())
+				Expect(err).To(BeNil())
+				Expect(token).To(Equal(firstToken))
+			})
+		})
+		When("the underlying tokener fails", func() {
+			BeforeEach(func() {
+				inner.GetTokenReturnsOnCall(0, "", errors.New("auth0 is down"))
+			})
+			It("returns the error without caching it", func() {
+				_, err := ct.GetToken(context.Background())
+				Expect(err).To(MatchError("auth0 is down"))
+
+				token, err := ct.GetToken(context.Background())
+				Expect(err).To(BeNil())
+				Expect(token).To(Equal(secondToken))
+			})
+		})
+		When("the token has no expiry", func() {
+			var forever string
+			BeforeEach(func() {
+				forever = unsignedToken(djwt.MapClaims{"sub": "forever"})
+				inner.GetTokenReturnsOnCall(0, forever, nil)
+			})
+			It("is cached for a bounded time", func() {
+				_, _ = ct.GetToken(context.Background())
+				token, _ := ct.GetToken(context.Background())
+				Expect(token).To(Equal(forever))
+				Expect(inner.GetTokenCallCount()).To(Equal(1))
+
+				now = start.Add(5 * time.Minute)
+				token, _ = ct.GetToken(context.Background())
+				Expect(token).To(Equal(secondToken))
+			})
+		})
+		When("the token is short-lived", func() {
+			var short string
+			BeforeEach(func() {
+				short = unsignedToken(djwt.MapClaims{"sub": "short", "exp": start.Add(5 * time.Minute).Unix()})
+				inner.GetTokenReturnsOnCall(0, short, nil)
+			})
+			It("isn't refreshed until halfway through its lifetime", func() {
+				_, _ = ct.GetToken(context.Background())
+
+				now = start.Add(2 * time.Minute)
+				token, _ := ct.GetToken(context.Background())
+				Expect(token).To(Equal(short))
+				Consistently(inner.GetTokenCallCount, 50*time.Millisecond).Should(Equal(1))
+
+				now = start.Add(150 * time.Second)
+				token, _ = ct.GetToken(context.Background())
+				Expect(token).To(Equal(short))
+				Eventually(inner.GetTokenCallCount).Should(Equal(2))
+			})
+		})
+		When("the token lives for less than the expiry margin", func() {
+			var brief string
+			BeforeEach(func() {
+				brief = unsignedToken(djwt.MapClaims{"sub": "brief", "exp": start.Add(40 * time.Second).Unix()})
+				inner.GetTokenReturnsOnCall(0, brief, nil)
+			})
+			It("is still cached, until a quarter of its lifetime before it expires", func() {
+				_, _ = ct.GetToken(context.Background())
+
+				now = start.Add(15 * time.Second)
+				token, _ := ct.GetToken(context.Background())
+				Expect(token).To(Equal(brief))
+				Expect(inner.GetTokenCallCount()).To(Equal(1))
+
+				now = start.Add(30 * time.Second)
+				token, _ = ct.GetToken(context.Background())
+				Expect(token).To(Equal(secondToken))
+			})
+		})
+		When("the caller gives up waiting", func() {
+			It("returns the context error", func() {
+				release := make(chan struct{})
+				defer close(release)
+				inner.GetTokenStub = func(context.Context) (string, error) {
+					<-release
+					return firstToken, nil
+				}
+
+				ctx, cancel := context.WithCancel(context.Background())
+				cancel()
+				_, err := ct.GetToken(ctx)
+				Expect(err).To(MatchError(context.Canceled))
+			})
+		})
+	})
+	Describe("Close", func() {
+		It("cancels the request in flight and waits for it", func() {
+			started := make(chan struct{})
+			inner.GetTokenStub = func(ctx context.Context) (string, error) {
+				close(started)
+				<-ctx.Done()
+				return "", ctx.Err()
+			}
+
+			errs := make(chan error, 1)
+			go func() {
+				_, err := ct.GetToken(context.Background())
+				errs <- err
+			}()
+			Eventually(started).Should(BeClosed())
+
+			ct.Close()
+			Expect(<-errs).To(MatchError(context.Canceled))
+		})
+		It("returns at once when nothing is in flight", func() {
+			ct.Close()
+		})
+	})
+})
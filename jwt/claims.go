@@ -0,0 +1,238 @@
+package jwt
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/textproto"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// ClaimMapping describes how an issuer's claims are forwarded upstream.
+// Session, Role, and the claims named in Headers are looked up under each
+// of the Namespaces in turn, so that both an old and a new namespace can be
+// accepted while tokens move between them. Headers also fall back to the
+// bare claim, for standard claims such as `sub` and `scope`. Session and
+// Role only do if there are no Namespaces, or if they're full claim URIs,
+// since a bare `role` claim may be set by the provider or even the user.
+type ClaimMapping struct {
+	Namespaces []string        `json:"namespaces"`
+	Session    string          `json:"session"`
+	Role       string          `json:"role"`
+	Headers    []HeaderMapping `json:"headers"`
+}
+
+// DefaultClaimMapping is the mapping used by our own Auth0 tenants
+var DefaultClaimMapping = ClaimMapping{
+	Namespaces: []string{"https://jwt.smartatransit.com/"},
+	Session:    "session",
+	Role:       "role",
+}
+
+// HeaderMapping forwards a claim upstream in a header. Path optionally
+// selects a value nested inside the claim, as dot-separated object keys or
+// array indexes, e.g. `app_metadata.plans.0`.
+type HeaderMapping struct {
+	Claim    string   `json:"claim"`
+	Path     string   `json:"path"`
+	Header   string   `json:"header"`
+	Encoding Encoding `json:"encoding"`
+}
+
+// Encoding is how a claim is written into a header
+type Encoding string
+
+// Claims can be written as text, with arrays joined by commas (or by spaces,
+// as in the `scope` claim); as JSON; or as base64url-encoded JSON, for
+// values that might not be safe in a header otherwise. Text is the default.
+const (
+	EncodingText      Encoding = "text"
+	EncodingSpaced    Encoding = "spaced"
+	EncodingJSON      Encoding = "json"
+	EncodingBase64URL Encoding = "base64url"
+)
+
+var errUnencodable = errors.New("claim can't be encoded as text")
+
+// LoadHeaderMappings reads a JSON list of header mappings from disk
+func LoadHeaderMappings(filename string) ([]HeaderMapping, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed opening claim headers file: %w", err)
+	}
+	defer f.Close()
+
+	var headers []HeaderMapping
+	dec := json.NewDecoder(f)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&headers); err != nil {
+		return nil, fmt.Errorf("malformed claim headers file: %w", err)
+	}
+
+	if err := (ClaimMapping{Headers: headers}).Validate(); err != nil {
+		return nil, fmt.Errorf("malformed claim headers file: %w", err)
+	}
+
+	return headers, nil
+}
+
+// Validate checks that every header mapping names a claim, a valid header,
+// and a known encoding.
+func (m ClaimMapping) Validate() error {
+	for i, h := range m.Headers {
+		if h.Claim == "" || h.Header == "" {
+			return fmt.Errorf("header mapping %v must have a claim and a header", i)
+		}
+		if strings.IndexFunc(h.Header, isNotTokenChar) != -1 {
+			return fmt.Errorf("header mapping %v has an invalid header name `%s`", i, h.Header)
+		}
+
+		switch h.Encoding {
+		case "", EncodingText, EncodingSpaced, EncodingJSON, EncodingBase64URL:
+		default:
+			return fmt.Errorf("header mapping %v has an unknown encoding `%s`", i, h.Encoding)
+		}
+	}
+
+	return nil
+}
+
+func isNotTokenChar(r rune) bool {
+	switch {
+	case 'a' <= r && r <= 'z', 'A' <= r && r <= 'Z', '0' <= r && r <= '9':
+		return false
+	}
+	return !strings.ContainsRune("!#$%&'*+-.^_`|~", r)
+}
+
+func (m ClaimMapping) apply(auth *Authorization) {
+	bare := func(name string) bool {
+		return len(m.Namespaces) == 0 || strings.Contains(name, "://")
+	}
+	session, _ := m.lookup(auth.Claims, m.Session, bare(m.Session))
+	auth.Session, _ = session.(string)
+	role, _ := m.lookup(auth.Claims, m.Role, bare(m.Role))
+	auth.Role, _ = role.(string)
+
+	if len(m.Headers) == 0 {
+		return
+	}
+
+	// Every mapped header is set, even if it's empty, so that upstream
+	// services never see a value that didn't come from the token.
+	auth.Headers = http.Header{}
+	for _, h := range m.Headers {
+		var value string
+		if claim, ok := m.lookup(auth.Claims, h.Claim, true); ok {
+			if v, ok := selectPath(claim, h.Path); ok {
+				value, _ = h.Encoding.encode(v)
+			}
+		}
+		auth.Headers.Set(h.Header, value)
+	}
+}
+
+// lookup finds the claim under the first namespace that has it, and then,
+// if bare is set, without a namespace
+func (m ClaimMapping) lookup(claims map[string]interface{}, name string, bare bool) (interface{}, bool) {
+	for _, ns := range m.Namespaces {
+		if v, ok := claims[ns+name]; ok {
+			return v, true
+		}
+	}
+
+	if !bare {
+		return nil, false
+	}
+	v, ok := claims[name]
+	return v, ok
+}
+
+func selectPath(v interface{}, path string) (interface{}, bool) {
+	if path == "" {
+		return v, true
+	}
+
+	for _, step := range strings.Split(path, ".") {
+		switch node := v.(type) {
+		case map[string]interface{}:
+			next, ok := node[step]
+			if !ok {
+				return nil, false
+			}
+			v = next
+		case []interface{}:
+			i, err := strconv.Atoi(step)
+			if err != nil || i < 0 || i >= len(node) {
+				return nil, false
+			}
+			v = node[i]
+		default:
+			return nil, false
+		}
+	}
+
+	return v, true
+}
+
+func (e Encoding) encode(v interface{}) (string, error) {
+	switch e {
+	case EncodingJSON:
+		b, err := json.Marshal(v)
+		return string(b), err
+	case EncodingBase64URL:
+		b, err := json.Marshal(v)
+		return base64.RawURLEncoding.EncodeToString(b), err
+	case EncodingSpaced:
+		return encodeText(v, " ")
+	default:
+		return encodeText(v, ",")
+	}
+}
+
+// encodeText writes scalars as text and arrays of scalars as a list. Objects,
+// and strings that can't safely be put in a header, need one of the JSON
+// encodings.
+func encodeText(v interface{}, sep string) (string, error) {
+	list, ok := v.([]interface{})
+	if !ok {
+		return encodeScalar(v)
+	}
+
+	values := make([]string, len(list))
+	for i, item := range list {
+		s, err := encodeScalar(item)
+		if err != nil {
+			return "", err
+		}
+		values[i] = s
+	}
+
+	return strings.Join(values, sep), nil
+}
+
+func encodeScalar(v interface{}) (string, error) {
+	switch s := v.(type) {
+	case nil:
+		return "", nil
+	case string:
+		if strings.IndexFunc(s, isControl) != -1 {
+			return "", errUnencodable
+		}
+		return textproto.TrimString(s), nil
+	case float64:
+		return strconv.FormatFloat(s, 'f', -1, 64), nil
+	case bool:
+		return strconv.FormatBool(s), nil
+	default:
+		return "", errUnencodable
+	}
+}
+
+func isControl(r rune) bool {
+	return r < ' ' || r == 0x7f
+}
@@ -0,0 +1,191 @@
+package jwt_test
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+
+	djwt "github.com/dgrijalva/jwt-go"
+	jose "gopkg.in/square/go-jose.v2"
+
+	"github.com/smartatransit/api-gateway/jwt"
+	"github.com/smartatransit/api-gateway/jwt/jwtfakes"
+)
+
+var _ = Describe("ClaimMapping", func() {
+	var (
+		mapping jwt.ClaimMapping
+		claims  djwt.MapClaims
+	)
+
+	// parse signs claims and verifies them with a parser using mapping
+	parse := func() jwt.Authorization {
+		public, private, _ := ed25519.GenerateKey(rand.Reader)
+		keys := &jwtfakes.FakeKeys{}
+		keys.FetchReturns(jose.JSONWebKey{Key: public}, nil)
+
+		token := djwt.NewWithClaims(jwt.SigningMethodEd25519, claims)
+		token.Header["kid"] = "my-kid"
+		signed, err := token.SignedString(private)
+		Expect(err).To(BeNil())
+
+		auth, err := jwt.NewParser(keys, jwt.Validation{}, mapping).ParseToken(context.Background(), signed)
+		Expect(err).To(BeNil())
+		return auth
+	}
+
+	BeforeEach(func() {
+		mapping = jwt.ClaimMapping{}
+		claims = djwt.MapClaims{
+			"sub":                                   "auth0|123",
+			"azp":                                   "client-id",
+			"scope":                                 "read:lines read:stops",
+			"permissions":                           []string{"read:lines", "write:favorites"},
+			"https://jwt.smartatransit.com/session": "sess-val",
+			"https://jwt.smartatransit.com/role":    "rider",
+			"https://smartatransit.com/claims/role": "admin",
+			"https://jwt.smartatransit.com/app_metadata": map[string]interface{}{
+				"plan":   map[string]interface{}{"tier": "gold", "seats": 3},
+				"labels": []string{"beta", "staff"},
+			},
+		}
+	})
+
+	Describe("namespaces", func() {
+		It("defaults to our own namespace", func() {
+			auth := parse()
+			Expect(auth.Session).To(Equal("sess-val"))
+			Expect(auth.Role).To(Equal("rider"))
+		})
+		It("prefers earlier namespaces", func() {
+			mapping.Namespaces = []string{"https://smartatransit.com/claims/", "https://jwt.smartatransit.com/"}
+			auth := parse()
+			Expect(auth.Role).To(Equal("admin"))
+			Expect(auth.Session).To(Equal("sess-val"))
+		})
+		It("doesn't fall back to claims without a namespace", func() {
+			mapping.Role = "azp"
+			Expect(parse().Role).To(BeEmpty())
+		})
+		It("ignores a bare role claim", func() {
+			delete(claims, "https://jwt.smartatransit.com/role")
+			claims["role"] = "admin"
+			Expect(parse().Role).To(BeEmpty())
+		})
+		It("uses claims without a namespace when there are no namespaces", func() {
+			mapping.Namespaces = []string{}
+			mapping.Role = "azp"
+			Expect(parse().Role).To(Equal("client-id"))
+		})
+		It("accepts full claim names", func() {
+			mapping.Role = "https://smartatransit.com/claims/role"
+			Expect(parse().Role).To(Equal("admin"))
+		})
+	})
+
+	Describe("headers", func() {
+		DescribeTable("forwards claims",
+			func(h jwt.HeaderMapping, expected string) {
+				h.Header = "X-Smarta-Auth-Test"
+				mapping.Headers = []jwt.HeaderMapping{h}
+				Expect(parse().Headers.Get("X-Smarta-Auth-Test")).To(Equal(expected))
+			},
+			Entry("a string", jwt.HeaderMapping{Claim: "sub"}, "auth0|123"),
+			Entry("a string as JSON", jwt.HeaderMapping{Claim: "sub", Encoding: jwt.EncodingJSON}, `"auth0|123"`),
+			Entry("an array", jwt.HeaderMapping{Claim: "permissions"}, "read:lines,write:favorites"),
+			Entry("an array with spaces", jwt.HeaderMapping{Claim: "permissions", Encoding: jwt.EncodingSpaced}, "read:lines write:favorites"),
+			Entry("a namespaced claim", jwt.HeaderMapping{Claim: "app_metadata", Path: "plan.tier"}, "gold"),
+			Entry("a number", jwt.HeaderMapping{Claim: "app_metadata", Path: "plan.seats"}, "3"),
+			Entry("an array element", jwt.HeaderMapping{Claim: "app_metadata", Path: "labels.1"}, "staff"),
+			Entry("an object as JSON", jwt.HeaderMapping{Claim: "app_metadata", Path: "plan", Encoding: jwt.EncodingJSON}, `{"seats":3,"tier":"gold"}`),
+			Entry("an object as base64url JSON", jwt.HeaderMapping{Claim: "app_metadata", Path: "plan", Encoding: jwt.EncodingBase64URL}, "eyJzZWF0cyI6MywidGllciI6ImdvbGQifQ"),
+			Entry("an object as text", jwt.HeaderMapping{Claim: "app_metadata", Path: "plan"}, ""),
+			Entry("a missing claim", jwt.HeaderMapping{Claim: "email"}, ""),
+			Entry("a missing path", jwt.HeaderMapping{Claim: "app_metadata", Path: "plan.nope"}, ""),
+			Entry("an index out of range", jwt.HeaderMapping{Claim: "app_metadata", Path: "labels.5"}, ""),
+		)
+		It("sets mapped headers even when the claim is missing", func() {
+			mapping.Headers = []jwt.HeaderMapping{{Claim: "email", Header: "X-Smarta-Auth-Email"}}
+			Expect(parse().Headers).To(HaveKeyWithValue("X-Smarta-Auth-Email", []string{""}))
+		})
+		It("won't forward strings with control characters as text", func() {
+			claims["sub"] = "auth0|123\r\nX-Smarta-Auth-Role: admin"
+			mapping.Headers = []jwt.HeaderMapping{
+				{Claim: "sub", Header: "X-Smarta-Auth-Subject"},
+				{Claim: "sub", Header: "X-Smarta-Auth-Subject-JSON", Encoding: jwt.EncodingJSON},
+			}
+			auth := parse()
+			Expect(auth.Headers.Get("X-Smarta-Auth-Subject")).To(BeEmpty())
+			Expect(auth.Headers.Get("X-Smarta-Auth-Subject-JSON")).To(Equal(`"auth0|123\r\nX-Smarta-Auth-Role: admin"`))
+		})
+		It("doesn't set any headers when none are mapped", func() {
+			Expect(parse().Headers).To(BeNil())
+		})
+	})
+
+	Describe("Validate", func() {
+		DescribeTable("rejects bad header mappings",
+			func(h jwt.HeaderMapping, message string) {
+				err := jwt.ClaimMapping{Headers: []jwt.HeaderMapping{h}}.Validate()
+				Expect(err).To(MatchError(message))
+			},
+			Entry("no claim", jwt.HeaderMapping{Header: "X-Sub"}, "header mapping 0 must have a claim and a header"),
+			Entry("no header", jwt.HeaderMapping{Claim: "sub"}, "header mapping 0 must have a claim and a header"),
+			Entry("a bad header", jwt.HeaderMapping{Claim: "sub", Header: "X Sub"}, "header mapping 0 has an invalid header name `X Sub`"),
+			Entry("a bad encoding", jwt.HeaderMapping{Claim: "sub", Header: "X-Sub", Encoding: "hex"}, "header mapping 0 has an unknown encoding `hex`"),
+		)
+		It("accepts good header mappings", func() {
+			Expect(jwt.ClaimMapping{Headers: []jwt.HeaderMapping{
+				{Claim: "sub", Header: "X-Smarta-Auth-Subject"},
+				{Claim: "scope", Header: "X-Smarta-Auth-Scopes", Encoding: jwt.EncodingSpaced},
+			}}.Validate()).To(Succeed())
+		})
+	})
+})
+
+var _ = Describe("LoadHeaderMappings", func() {
+	var dir string
+	BeforeEach(func() {
+		dir, _ = ioutil.TempDir("", "claims")
+	})
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	write := func(content string) string {
+		filename := filepath.Join(dir, "headers.json")
+		Expect(ioutil.WriteFile(filename, []byte(content), 0600)).To(Succeed())
+		return filename
+	}
+
+	It("loads header mappings", func() {
+		headers, err := jwt.LoadHeaderMappings(write(`[
+			{"claim": "sub", "header": "X-Smarta-Auth-Subject"},
+			{"claim": "permissions", "header": "X-Smarta-Auth-Permissions", "encoding": "spaced"}
+		]`))
+		Expect(err).To(BeNil())
+		Expect(headers).To(Equal([]jwt.HeaderMapping{
+			{Claim: "sub", Header: "X-Smarta-Auth-Subject"},
+			{Claim: "permissions", Header: "X-Smarta-Auth-Permissions", Encoding: jwt.EncodingSpaced},
+		}))
+	})
+	It("rejects invalid mappings", func() {
+		_, err := jwt.LoadHeaderMappings(write(`[{"claim": "sub"}]`))
+		Expect(err).To(MatchError("malformed claim headers file: header mapping 0 must have a claim and a header"))
+	})
+	It("rejects unknown fields", func() {
+		_, err := jwt.LoadHeaderMappings(write(`[{"claim": "sub", "header": "X-Sub", "format": "json"}]`))
+		Expect(err).To(HaveOccurred())
+	})
+	It("fails if the file doesn't exist", func() {
+		_, err := jwt.LoadHeaderMappings(filepath.Join(dir, "nope.json"))
+		Expect(err).To(HaveOccurred())
+	})
+})
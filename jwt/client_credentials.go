@@ -0,0 +1,151 @@
+package jwt
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Tokener is an interface for obtaining tokens
+//go:generate counterfeiter . Tokener
+type Tokener interface {
+	GetToken(ctx context.Context) (string, error)
+}
+
+var (
+	// ErrTokenEndpointUnavailable is returned when the token endpoint can't
+	// be reached, or says it's overloaded or unavailable
+	ErrTokenEndpointUnavailable = errors.New("token endpoint unavailable")
+	// ErrTokenEndpointFailed is returned when the token endpoint fails, or
+	// responds with something other than a token or a rejection
+	ErrTokenEndpointFailed = errors.New("token endpoint failed")
+)
+
+// providerError marks a failure of the identity provider with the kind of
+// failure, without changing its message
+type providerError struct {
+	kind error
+	err  error
+}
+
+func (e providerError) Error() string        { return e.err.Error() }
+func (e providerError) Unwrap() error        { return e.err }
+func (e providerError) Is(target error) bool { return target == e.kind }
+
+// AuthMethod is the way a client authenticates to the token endpoint, as
+// named in OpenID Connect's `token_endpoint_auth_methods_supported`.
+type AuthMethod string
+
+const (
+	// ClientSecretBasic sends the client credentials in a Basic
+	// Authorization header
+	ClientSecretBasic AuthMethod = "client_secret_basic"
+	// ClientSecretPost sends the client credentials in the form body
+	ClientSecretPost AuthMethod = "client_secret_post"
+)
+
+// ClientCredentialsTokener implements Tokener by requesting new tokens from
+// an OAuth 2.0 token endpoint with the client credentials grant. Auth0
+// machine-to-machine clients are one example.
+type ClientCredentialsTokener struct {
+	url     string
+	payload string
+	doer    Doer
+
+	// basicAuth holds the form-encoded client ID and secret when they're
+	// sent with client_secret_basic.
+	basicAuth []string
+}
+
+// TokenerFactory builds a tokener for a specific client identity
+//go:generate counterfeiter . TokenerFactory
+type TokenerFactory func(clientID, clientSecret string) Tokener
+
+// NewTokenerFactory returns a new tokener factory
+func NewTokenerFactory(url, audience string, method AuthMethod, doer Doer) TokenerFactory {
+	return func(clientID, clientSecret string) Tokener {
+		return NewTokener(url, clientID, clientSecret, audience, method, doer)
+	}
+}
+
+// NewTokener builds a ClientCredentialsTokener from the specified token
+// endpoint url, client id, and secret. The audience is optional, and is
+// only sent when it's set.
+func NewTokener(tokenURL, clientID, clientSecret, audience string, method AuthMethod, doer Doer) ClientCredentialsTokener {
+	form := url.Values{"grant_type": {"client_credentials"}}
+	if audience != "" {
+		form.Set("audience", audience)
+	}
+
+	var basicAuth []string
+	if method == ClientSecretBasic {
+		// RFC 6749 section 2.3.1 requires the credentials to be
+		// form-encoded before they're used as the Basic username and
+		// password.
+		basicAuth = []string{url.QueryEscape(clientID), url.QueryEscape(clientSecret)}
+	} else {
+		form.Set("client_id", clientID)
+		form.Set("client_secret", clientSecret)
+	}
+
+	return ClientCredentialsTokener{
+		url:       tokenURL,
+		doer:      doer,
+		payload:   form.Encode(),
+		basicAuth: basicAuth,
+	}
+}
+
+type tokenResponse struct {
+	AT string `json:"access_token"`
+}
+
+// GetToken returns a token from the token endpoint
+func (a ClientCredentialsTokener) GetToken(ctx context.Context) (_ string, err error) {
+	defer observeDuration(tokenRequestDuration, time.Now(), &err)
+
+	req, err := http.NewRequestWithContext(ctx, "POST", a.url, strings.NewReader(a.payload))
+	if err != nil {
+		return "", fmt.Errorf("failed building token request: %w", err)
+	}
+	req.Header.Add("content-type", "application/x-www-form-urlencoded")
+	req.Header.Add("accept", "application/json")
+	if a.basicAuth != nil {
+		req.SetBasicAuth(a.basicAuth[0], a.basicAuth[1])
+	}
+
+	resp, err := a.doer.Do(req)
+	if err != nil {
+		return "", providerError{ErrTokenEndpointUnavailable, fmt.Errorf("failed obtaining new access token: %w", err)}
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		err := fmt.Errorf("failed obtaining new access token: status code %v", resp.StatusCode)
+		switch {
+		case resp.StatusCode == http.StatusTooManyRequests,
+			resp.StatusCode == http.StatusBadGateway,
+			resp.StatusCode == http.StatusServiceUnavailable,
+			resp.StatusCode == http.StatusGatewayTimeout:
+			return "", providerError{ErrTokenEndpointUnavailable, err}
+		case resp.StatusCode >= 400 && resp.StatusCode < 500:
+			// The credentials were rejected
+			return "", err
+		default:
+			return "", providerError{ErrTokenEndpointFailed, err}
+		}
+	}
+
+	var tr tokenResponse
+	err = json.NewDecoder(resp.Body).Decode(&tr)
+	if err != nil {
+		return "", providerError{ErrTokenEndpointFailed, fmt.Errorf("failed decoding new access token: %w", err)}
+	}
+
+	return tr.AT, nil
+}
@@ -0,0 +1,152 @@
+package jwt_test
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/smartatransit/api-gateway/jwt"
+	"github.com/smartatransit/api-gateway/jwt/jwtfakes"
+)
+
+var _ = Describe("Tokener", func() {
+	var (
+		doer *jwtfakes.FakeDoer
+		t    jwt.Tokener
+	)
+	BeforeEach(func() {
+		doer = &jwtfakes.FakeDoer{}
+		t = jwt.NewTokener("url", "client", "secret", "audience", jwt.ClientSecretPost, doer)
+
+		doer.DoReturns(&http.Response{
+			StatusCode: http.StatusOK,
+			Body:       ioutil.NopCloser(strings.NewReader(`{"access_token": "my-fancy-access-token"}`)),
+		}, nil)
+	})
+	Describe("GetToken", func() {
+		var (
+			token string
+			err   error
+		)
+		JustBeforeEach(func() {
+			token, err = t.GetToken(context.Background())
+		})
+		When("the request fails", func() {
+			var failuresBefore float64
+			BeforeEach(func() {
+				doer.DoReturns(nil, errors.New("request failed"))
+				failuresBefore = metricValue("api_gateway_token_endpoint_request_duration_seconds", map[string]string{"result": "error"})
+			})
+			It("fails", func() {
+				Expect(err).To(MatchError("failed obtaining new access token: request failed"))
+				Expect(err).To(MatchError(jwt.ErrTokenEndpointUnavailable))
+			})
+			It("records the failed request's duration", func() {
+				failures := metricValue("api_gateway_token_endpoint_request_duration_seconds", map[string]string{"result": "error"})
+				Expect(failures - failuresBefore).To(Equal(1.0))
+			})
+		})
+		When("the status code is non-normal", func() {
+			BeforeEach(func() {
+				doer.DoReturns(&http.Response{
+					StatusCode: http.StatusFound,
+					Body:       ioutil.NopCloser(strings.NewReader(`{"AT": "my-fancy-access-token"}`)),
+				}, nil)
+			})
+			It("fails", func() {
+				Expect(err).To(MatchError("failed obtaining new access token: status code 302"))
+				Expect(err).To(MatchError(jwt.ErrTokenEndpointFailed))
+			})
+		})
+		When("the credentials are rejected", func() {
+			BeforeEach(func() {
+				doer.DoReturns(&http.Response{
+					StatusCode: http.StatusUnauthorized,
+					Body:       ioutil.NopCloser(strings.NewReader(`{"error": "access_denied"}`)),
+				}, nil)
+			})
+			It("fails", func() {
+				Expect(err).To(MatchError("failed obtaining new access token: status code 401"))
+				Expect(errors.Is(err, jwt.ErrTokenEndpointUnavailable)).To(BeFalse())
+				Expect(errors.Is(err, jwt.ErrTokenEndpointFailed)).To(BeFalse())
+			})
+		})
+		When("the token endpoint is overloaded", func() {
+			BeforeEach(func() {
+				doer.DoReturns(&http.Response{
+					StatusCode: http.StatusServiceUnavailable,
+					Body:       ioutil.NopCloser(strings.NewReader(``)),
+				}, nil)
+			})
+			It("fails", func() {
+				Expect(err).To(MatchError(jwt.ErrTokenEndpointUnavailable))
+			})
+		})
+		When("the response can't be decoded", func() {
+			BeforeEach(func() {
+				doer.DoReturns(&http.Response{
+					StatusCode: http.StatusOK,
+					Body:       ioutil.NopCloser(strings.NewReader(`{`)),
+				}, nil)
+			})
+			It("fails", func() {
+				Expect(err).To(MatchError("failed decoding new access token: unexpected EOF"))
+				Expect(err).To(MatchError(jwt.ErrTokenEndpointFailed))
+			})
+		})
+		Context("otherwise", func() {
+			It("succeeds", func() {
+				Expect(err).To(BeNil())
+				Expect(token).To(Equal("my-fancy-access-token"))
+			})
+			It("sends the request with the caller's context", func() {
+				type ctxKey struct{}
+				ctx := context.WithValue(context.Background(), ctxKey{}, "value")
+				_, _ = t.GetToken(ctx)
+				Expect(doer.DoArgsForCall(1).Context().Value(ctxKey{})).To(Equal("value"))
+			})
+			It("sends the credentials in a form body", func() {
+				req := doer.DoArgsForCall(0)
+				Expect(req.Method).To(Equal("POST"))
+				Expect(req.URL.String()).To(Equal("url"))
+				Expect(req.Header.Get("Content-Type")).To(Equal("application/x-www-form-urlencoded"))
+				Expect(req.Header.Get("Authorization")).To(BeEmpty())
+
+				body, _ := ioutil.ReadAll(req.Body)
+				form, _ := url.ParseQuery(string(body))
+				Expect(form).To(Equal(url.Values{
+					"grant_type":    {"client_credentials"},
+					"client_id":     {"client"},
+					"client_secret": {"secret"},
+					"audience":      {"audience"},
+				}))
+			})
+		})
+		When("using client_secret_basic", func() {
+			BeforeEach(func() {
+				t = jwt.NewTokener("url", "client:1", "s3cret&more", "", jwt.ClientSecretBasic, doer)
+			})
+			It("sends form-encoded credentials in a Basic header", func() {
+				Expect(err).To(BeNil())
+
+				req := doer.DoArgsForCall(0)
+				id, secret, ok := req.BasicAuth()
+				Expect(ok).To(BeTrue())
+				Expect(id).To(Equal("client%3A1"))
+				Expect(secret).To(Equal("s3cret%26more"))
+
+				body, _ := ioutil.ReadAll(req.Body)
+				form, _ := url.ParseQuery(string(body))
+				Expect(form).To(Equal(url.Values{
+					"grant_type": {"client_credentials"},
+				}))
+			})
+		})
+	})
+})
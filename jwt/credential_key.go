@@ -0,0 +1,56 @@
+package jwt
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+)
+
+// CredentialKey identifies the cached token for an API key without holding
+// onto its secret. Digest is a keyed hash of the client ID and secret, and
+// ClientID is kept alongside it for logging.
+type CredentialKey struct {
+	ClientID string
+	Digest   string
+}
+
+// NewCredentialHasher creates a CredentialHasher with the given pepper. If
+// the pepper is empty, a random one is generated, which means keys are only
+// stable for the life of the process.
+func NewCredentialHasher(pepper []byte) (CredentialHasher, error) {
+	if len(pepper) == 0 {
+		pepper = make([]byte, 32)
+		if _, err := rand.Read(pepper); err != nil {
+			return CredentialHasher{}, fmt.Errorf("failed generating cache key pepper: %w", err)
+		}
+	}
+
+	return CredentialHasher{pepper: pepper}, nil
+}
+
+// CredentialHasher derives CredentialKeys from API key credentials using
+// HMAC-SHA256.
+type CredentialHasher struct {
+	pepper []byte
+}
+
+// Key derives the CredentialKey for a client ID and secret
+func (h CredentialHasher) Key(clientID, clientSecret string) CredentialKey {
+	mac := hmac.New(sha256.New, h.pepper)
+
+	// Length-prefix the client ID so that no two distinct credentials
+	// produce the same MAC input.
+	var length [8]byte
+	binary.BigEndian.PutUint64(length[:], uint64(len(clientID)))
+	mac.Write(length[:])
+	mac.Write([]byte(clientID))
+	mac.Write([]byte(clientSecret))
+
+	return CredentialKey{
+		ClientID: clientID,
+		Digest:   hex.EncodeToString(mac.Sum(nil)),
+	}
+}
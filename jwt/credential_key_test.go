@@ -0,0 +1,50 @@
+package jwt_test
+
+import (
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/smartatransit/api-gateway/jwt"
+)
+
+var _ = Describe("CredentialHasher", func() {
+	var hasher jwt.CredentialHasher
+	BeforeEach(func() {
+		hasher, _ = jwt.NewCredentialHasher([]byte("pepper"))
+	})
+	Describe("Key", func() {
+		It("keeps the client ID but not the secret", func() {
+			key := hasher.Key("client", "super-secret")
+			Expect(key.ClientID).To(Equal("client"))
+			Expect(key.Digest).To(HaveLen(64))
+			Expect(key.Digest).NotTo(ContainSubstring("super-secret"))
+		})
+		It("is stable for the same pepper", func() {
+			other, _ := jwt.NewCredentialHasher([]byte("pepper"))
+			Expect(hasher.Key("client", "secret")).To(Equal(other.Key("client", "secret")))
+		})
+		It("depends on the pepper", func() {
+			other, _ := jwt.NewCredentialHasher([]byte("different"))
+			Expect(hasher.Key("client", "secret").Digest).NotTo(Equal(other.Key("client", "secret").Digest))
+		})
+		It("distinguishes where the client ID ends", func() {
+			Expect(hasher.Key("ab", "c").Digest).NotTo(Equal(hasher.Key("a", "bc").Digest))
+		})
+		It("distinguishes secrets", func() {
+			Expect(hasher.Key("client", "one").Digest).NotTo(Equal(hasher.Key("client", "two").Digest))
+		})
+	})
+	When("no pepper is configured", func() {
+		It("generates a random one", func() {
+			one, err := jwt.NewCredentialHasher(nil)
+			Expect(err).To(BeNil())
+			two, err := jwt.NewCredentialHasher(nil)
+			Expect(err).To(BeNil())
+
+			Expect(one.Key("client", "secret").Digest).NotTo(Equal(two.Key("client", "secret").Digest))
+			Expect(strings.Trim(one.Key("client", "secret").Digest, "0")).NotTo(BeEmpty())
+		})
+	})
+})
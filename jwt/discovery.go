@@ -0,0 +1,78 @@
+package jwt
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// ProviderMetadata is the subset of an OpenID Connect discovery document
+// that the gateway uses.
+type ProviderMetadata struct {
+	Issuer                   string       `json:"issuer"`
+	JWKSURI                  string       `json:"jwks_uri"`
+	TokenEndpoint            string       `json:"token_endpoint"`
+	TokenEndpointAuthMethods []AuthMethod `json:"token_endpoint_auth_methods_supported"`
+}
+
+// ErrIssuerMismatch is returned when a discovery document describes a
+// different issuer than the one it was fetched for.
+var ErrIssuerMismatch = errors.New("discovered issuer doesn't match")
+
+// Discover fetches the OpenID Connect discovery document for an issuer from
+// its /.well-known/openid-configuration endpoint.
+func Discover(ctx context.Context, issuerURL string, doer Doer) (ProviderMetadata, error) {
+	issuerURL = normalizeIssuer(issuerURL)
+
+	req, err := http.NewRequestWithContext(ctx, "GET", issuerURL+"/.well-known/openid-configuration", nil)
+	if err != nil {
+		return ProviderMetadata{}, fmt.Errorf("failed building discovery request: %w", err)
+	}
+	req.Header.Set("accept", "application/json")
+
+	resp, err := doer.Do(req)
+	if err != nil {
+		return ProviderMetadata{}, fmt.Errorf("failed fetching discovery document: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return ProviderMetadata{}, fmt.Errorf("failed fetching discovery document: status code %v", resp.StatusCode)
+	}
+
+	var md ProviderMetadata
+	if err := json.NewDecoder(resp.Body).Decode(&md); err != nil {
+		return ProviderMetadata{}, fmt.Errorf("malformed discovery document: %w", err)
+	}
+
+	if normalizeIssuer(md.Issuer) != issuerURL {
+		return ProviderMetadata{}, fmt.Errorf("%w: expected `%s` but got `%s`", ErrIssuerMismatch, issuerURL, md.Issuer)
+	}
+
+	if md.JWKSURI == "" {
+		return ProviderMetadata{}, errors.New("malformed discovery document: missing jwks_uri")
+	}
+
+	return md, nil
+}
+
+// PreferredAuthMethod picks the token endpoint auth method to use. Per the
+// OpenID Connect spec, client_secret_basic is the default, so it's used
+// unless the provider only supports client_secret_post.
+func (md ProviderMetadata) PreferredAuthMethod() AuthMethod {
+	for _, m := range md.TokenEndpointAuthMethods {
+		if m == ClientSecretBasic {
+			return ClientSecretBasic
+		}
+	}
+
+	for _, m := range md.TokenEndpointAuthMethods {
+		if m == ClientSecretPost {
+			return ClientSecretPost
+		}
+	}
+
+	return ClientSecretBasic
+}
@@ -0,0 +1,118 @@
+package jwt_test
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/smartatransit/api-gateway/jwt"
+	"github.com/smartatransit/api-gateway/jwt/jwtfakes"
+)
+
+var _ = Describe("Discover", func() {
+	var (
+		doer     *jwtfakes.FakeDoer
+		document string
+
+		md  jwt.ProviderMetadata
+		err error
+	)
+	BeforeEach(func() {
+		doer = &jwtfakes.FakeDoer{}
+		document = `{
+			"issuer": "https://tenant.auth0.com/",
+			"jwks_uri": "https://tenant.auth0.com/.well-known/jwks.json",
+			"token_endpoint": "https://tenant.auth0.com/oauth/token",
+			"token_endpoint_auth_methods_supported": ["client_secret_post", "client_secret_basic"]
+		}`
+	})
+	JustBeforeEach(func() {
+		if doer.DoStub == nil {
+			doer.DoReturns(&http.Response{
+				StatusCode: http.StatusOK,
+				Body:       ioutil.NopCloser(strings.NewReader(document)),
+			}, nil)
+		}
+		md, err = jwt.Discover(context.Background(), "https://tenant.auth0.com/", doer)
+	})
+	When("the request fails", func() {
+		BeforeEach(func() {
+			doer.DoStub = func(*http.Request) (*http.Response, error) {
+				return nil, errors.New("request failed")
+			}
+		})
+		It("fails", func() {
+			Expect(err).To(MatchError("failed fetching discovery document: request failed"))
+		})
+	})
+	When("the status code is non-normal", func() {
+		BeforeEach(func() {
+			doer.DoStub = func(*http.Request) (*http.Response, error) {
+				return &http.Response{
+					StatusCode: http.StatusNotFound,
+					Body:       ioutil.NopCloser(strings.NewReader("")),
+				}, nil
+			}
+		})
+		It("fails", func() {
+			Expect(err).To(MatchError("failed fetching discovery document: status code 404"))
+		})
+	})
+	When("the document can't be decoded", func() {
+		BeforeEach(func() {
+			document = `{`
+		})
+		It("fails", func() {
+			Expect(err).To(MatchError("malformed discovery document: unexpected EOF"))
+		})
+	})
+	When("the document is for a different issuer", func() {
+		BeforeEach(func() {
+			document = `{"issuer": "https://evil.example.com/", "jwks_uri": "https://evil.example.com/jwks"}`
+		})
+		It("fails", func() {
+			Expect(err).To(MatchError(jwt.ErrIssuerMismatch))
+		})
+	})
+	When("the document has no jwks_uri", func() {
+		BeforeEach(func() {
+			document = `{"issuer": "https://tenant.auth0.com"}`
+		})
+		It("fails", func() {
+			Expect(err).To(MatchError("malformed discovery document: missing jwks_uri"))
+		})
+	})
+	Context("otherwise", func() {
+		It("succeeds", func() {
+			Expect(err).To(BeNil())
+			Expect(doer.DoArgsForCall(0).URL.String()).To(Equal("https://tenant.auth0.com/.well-known/openid-configuration"))
+			Expect(md).To(Equal(jwt.ProviderMetadata{
+				Issuer:                   "https://tenant.auth0.com/",
+				JWKSURI:                  "https://tenant.auth0.com/.well-known/jwks.json",
+				TokenEndpoint:            "https://tenant.auth0.com/oauth/token",
+				TokenEndpointAuthMethods: []jwt.AuthMethod{jwt.ClientSecretPost, jwt.ClientSecretBasic},
+			}))
+		})
+	})
+})
+
+var _ = Describe("ProviderMetadata", func() {
+	Describe("PreferredAuthMethod", func() {
+		It("prefers client_secret_basic", func() {
+			md := jwt.ProviderMetadata{TokenEndpointAuthMethods: []jwt.AuthMethod{jwt.ClientSecretPost, jwt.ClientSecretBasic}}
+			Expect(md.PreferredAuthMethod()).To(Equal(jwt.ClientSecretBasic))
+		})
+		It("uses client_secret_post when it's the only one supported", func() {
+			md := jwt.ProviderMetadata{TokenEndpointAuthMethods: []jwt.AuthMethod{"private_key_jwt", jwt.ClientSecretPost}}
+			Expect(md.PreferredAuthMethod()).To(Equal(jwt.ClientSecretPost))
+		})
+		It("defaults to client_secret_basic", func() {
+			Expect(jwt.ProviderMetadata{}.PreferredAuthMethod()).To(Equal(jwt.ClientSecretBasic))
+		})
+	})
+})
@@ -0,0 +1,43 @@
+package jwt_test
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	djwt "github.com/dgrijalva/jwt-go"
+
+	"github.com/smartatransit/api-gateway/jwt"
+)
+
+var _ = Describe("SigningMethodEdDSA", func() {
+	var (
+		public  ed25519.PublicKey
+		private ed25519.PrivateKey
+	)
+	BeforeEach(func() {
+		public, private, _ = ed25519.GenerateKey(rand.Reader)
+	})
+	It("is registered with the jwt package", func() {
+		Expect(djwt.GetSigningMethod("EdDSA")).To(Equal(jwt.SigningMethodEd25519))
+	})
+	It("verifies its own signatures", func() {
+		sig, err := jwt.SigningMethodEd25519.Sign("header.payload", private)
+		Expect(err).To(BeNil())
+		Expect(jwt.SigningMethodEd25519.Verify("header.payload", sig, public)).To(Succeed())
+	})
+	It("rejects a signature over different content", func() {
+		sig, _ := jwt.SigningMethodEd25519.Sign("header.payload", private)
+		Expect(jwt.SigningMethodEd25519.Verify("header.other", sig, public)).To(Equal(djwt.ErrSignatureInvalid))
+	})
+	It("rejects a malformed signature", func() {
+		Expect(jwt.SigningMethodEd25519.Verify("header.payload", "!!!", public)).NotTo(Succeed())
+	})
+	It("rejects other key types", func() {
+		_, err := jwt.SigningMethodEd25519.Sign("header.payload", "not-a-key")
+		Expect(err).To(Equal(djwt.ErrInvalidKeyType))
+		Expect(jwt.SigningMethodEd25519.Verify("header.payload", "c2ln", []byte("not-a-key"))).To(Equal(djwt.ErrInvalidKeyType))
+	})
+})
@@ -0,0 +1,143 @@
+package jwt
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// IssuerConfig describes a trusted token issuer. If JWKSURI is empty, it's
+// found through the issuer's discovery document.
+type IssuerConfig struct {
+	Name       string       `json:"name"`
+	Issuer     string       `json:"issuer"`
+	JWKSURI    string       `json:"jwks_uri"`
+	Audience   string       `json:"audience"`
+	Algorithms []string     `json:"algorithms"`
+	Claims     ClaimMapping `json:"claims"`
+}
+
+// ErrMissingAudience is returned for an issuer without an audience, since
+// its tokens for any of its APIs would be accepted
+var ErrMissingAudience = errors.New("issuer has no audience")
+
+// Validation is what the issuer's tokens are required to have. The given
+// algorithms are allowed if the issuer doesn't list any.
+func (cfg IssuerConfig) Validation(algorithms []string, leeway time.Duration) Validation {
+	if len(cfg.Algorithms) > 0 {
+		algorithms = cfg.Algorithms
+	}
+
+	return Validation{
+		Issuer:     cfg.Issuer,
+		Audience:   cfg.Audience,
+		Algorithms: algorithms,
+		Leeway:     leeway,
+	}
+}
+
+// RequireAudiences fails if any of the issuers has no audience
+func RequireAudiences(issuers []IssuerConfig) error {
+	for _, iss := range issuers {
+		if iss.Audience == "" {
+			return fmt.Errorf("%w: `%s`", ErrMissingAudience, iss.Name)
+		}
+	}
+	return nil
+}
+
+// LoadIssuers reads a JSON list of trusted issuers from disk
+func LoadIssuers(filename string) ([]IssuerConfig, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed opening issuers file: %w", err)
+	}
+	defer f.Close()
+
+	var issuers []IssuerConfig
+	dec := json.NewDecoder(f)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&issuers); err != nil {
+		return nil, fmt.Errorf("malformed issuers file: %w", err)
+	}
+
+	for i, iss := range issuers {
+		if iss.Name == "" || iss.Issuer == "" {
+			return nil, fmt.Errorf("malformed issuers file: issuer %v must have a name and an issuer", i)
+		}
+		if err := iss.Claims.Validate(); err != nil {
+			return nil, fmt.Errorf("malformed issuers file: issuer %v: %w", i, err)
+		}
+	}
+
+	return issuers, nil
+}
+
+// TrustedIssuer pairs an issuer with the parser that verifies its tokens
+type TrustedIssuer struct {
+	Name   string
+	Issuer string
+	Parser Parser
+}
+
+// NewMultiParser creates a Parser that trusts tokens from several issuers,
+// handing each token to the parser for its `iss` claim.
+func NewMultiParser(issuers ...TrustedIssuer) (MultiParser, error) {
+	mp := MultiParser{issuers: map[string]TrustedIssuer{}}
+	names := map[string]bool{}
+	for _, iss := range issuers {
+		if _, ok := mp.issuers[normalizeIssuer(iss.Issuer)]; ok {
+			return MultiParser{}, fmt.Errorf("issuer `%s` is configured more than once", iss.Issuer)
+		}
+		if names[iss.Name] {
+			return MultiParser{}, fmt.Errorf("issuer name `%s` is used more than once", iss.Name)
+		}
+
+		mp.issuers[normalizeIssuer(iss.Issuer)] = iss
+		names[iss.Name] = true
+	}
+
+	return mp, nil
+}
+
+// MultiParser implements Parser
+type MultiParser struct {
+	issuers map[string]TrustedIssuer
+}
+
+// ParseToken reads the token's `iss` claim without verifying it, and then
+// has that issuer's parser verify the token. The issuer's parser is
+// responsible for checking that `iss` matches.
+func (mp MultiParser) ParseToken(ctx context.Context, tokenStr string) (Authorization, error) {
+	var claims jwt.MapClaims
+	if _, _, err := new(jwt.Parser).ParseUnverified(tokenStr, &claims); err != nil {
+		return Authorization{}, fmt.Errorf("failed parsing JWT: %w: %s", ErrMalformedToken, err.Error())
+	}
+
+	iss, _ := claims["iss"].(string)
+	trusted, ok := mp.issuers[normalizeIssuer(iss)]
+	if !ok {
+		return Authorization{}, fmt.Errorf("failed parsing JWT: %w: `%s`", ErrInvalidIssuer, iss)
+	}
+
+	auth, err := trusted.Parser.ParseToken(ctx, tokenStr)
+	if err != nil {
+		return Authorization{}, err
+	}
+
+	auth.IssuerName = trusted.Name
+	return auth, nil
+}
+
+// normalizeIssuer strips the trailing slash from an issuer identifier. Some
+// providers (Auth0, for one) use one and some don't, and issuer URLs are
+// configured either way, so they're compared without it.
+func normalizeIssuer(iss string) string {
+	return strings.TrimSuffix(iss, "/")
+}
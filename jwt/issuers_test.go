@@ -0,0 +1,229 @@
+package jwt_test
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	djwt "github.com/dgrijalva/jwt-go"
+	jose "gopkg.in/square/go-jose.v2"
+
+	"github.com/smartatransit/api-gateway/jwt"
+	"github.com/smartatransit/api-gateway/jwt/jwtfakes"
+)
+
+var _ = Describe("MultiParser", func() {
+	var (
+		users    *jwtfakes.FakeParser
+		partners *jwtfakes.FakeParser
+		mp       jwt.MultiParser
+	)
+	BeforeEach(func() {
+		users = &jwtfakes.FakeParser{}
+		users.ParseTokenReturns(jwt.Authorization{Role: "rider"}, nil)
+		partners = &jwtfakes.FakeParser{}
+		partners.ParseTokenReturns(jwt.Authorization{Role: "partner"}, nil)
+
+		var err error
+		mp, err = jwt.NewMultiParser(
+			jwt.TrustedIssuer{Name: "users", Issuer: "https://users.auth0.com/", Parser: users},
+			jwt.TrustedIssuer{Name: "partners", Issuer: "https://partners.example.com", Parser: partners},
+		)
+		Expect(err).To(BeNil())
+	})
+	Describe("ParseToken", func() {
+		It("hands the token to the parser for its issuer", func() {
+			token := unsignedToken(djwt.MapClaims{"iss": "https://partners.example.com"})
+			auth, err := mp.ParseToken(context.Background(), token)
+			Expect(err).To(BeNil())
+			Expect(auth.Role).To(Equal("partner"))
+			Expect(auth.IssuerName).To(Equal("partners"))
+
+			Expect(users.ParseTokenCallCount()).To(Equal(0))
+			_, parsed := partners.ParseTokenArgsForCall(0)
+			Expect(parsed).To(Equal(token))
+		})
+		It("ignores a trailing slash on the issuer", func() {
+			auth, err := mp.ParseToken(context.Background(), unsignedToken(djwt.MapClaims{"iss": "https://users.auth0.com"}))
+			Expect(err).To(BeNil())
+			Expect(auth.IssuerName).To(Equal("users"))
+
+			auth, err = mp.ParseToken(context.Background(), unsignedToken(djwt.MapClaims{"iss": "https://partners.example.com/"}))
+			Expect(err).To(BeNil())
+			Expect(auth.IssuerName).To(Equal("partners"))
+		})
+		When("the issuer isn't trusted", func() {
+			It("fails without verifying the token", func() {
+				_, err := mp.ParseToken(context.Background(), unsignedToken(djwt.MapClaims{"iss": "https://evil.example.com"}))
+				Expect(errors.Is(err, jwt.ErrInvalidIssuer)).To(BeTrue())
+				Expect(users.ParseTokenCallCount() + partners.ParseTokenCallCount()).To(Equal(0))
+			})
+		})
+		When("the token can't be read", func() {
+			It("fails", func() {
+				_, err := mp.ParseToken(context.Background(), "garbage")
+				Expect(errors.Is(err, jwt.ErrMalformedToken)).To(BeTrue())
+			})
+		})
+		When("the issuer's parser rejects the token", func() {
+			BeforeEach(func() {
+				users.ParseTokenReturns(jwt.Authorization{}, jwt.ErrTokenExpired)
+			})
+			It("fails", func() {
+				_, err := mp.ParseToken(context.Background(), unsignedToken(djwt.MapClaims{"iss": "https://users.auth0.com/"}))
+				Expect(err).To(MatchError(jwt.ErrTokenExpired))
+			})
+		})
+	})
+	When("an issuer is configured twice", func() {
+		It("fails", func() {
+			_, err := jwt.NewMultiParser(
+				jwt.TrustedIssuer{Name: "one", Issuer: "https://users.auth0.com/", Parser: users},
+				jwt.TrustedIssuer{Name: "two", Issuer: "https://users.auth0.com/", Parser: partners},
+			)
+			Expect(err).To(MatchError("issuer `https://users.auth0.com/` is configured more than once"))
+		})
+	})
+	When("a name is used twice", func() {
+		It("fails", func() {
+			_, err := jwt.NewMultiParser(
+				jwt.TrustedIssuer{Name: "one", Issuer: "https://users.auth0.com/", Parser: users},
+				jwt.TrustedIssuer{Name: "one", Issuer: "https://partners.example.com", Parser: partners},
+			)
+			Expect(err).To(MatchError("issuer name `one` is used more than once"))
+		})
+	})
+})
+
+var _ = Describe("LoadIssuers", func() {
+	var (
+		dir      string
+		contents string
+
+		issuers []jwt.IssuerConfig
+		err     error
+	)
+	BeforeEach(func() {
+		dir, _ = ioutil.TempDir("", "issuers")
+		contents = `[{
+			"name": "partners",
+			"issuer": "https://partners.example.com",
+			"audience": "https://api.smartatransit.com",
+			"algorithms": ["ES256"],
+			"claims": {"role": "https://partners.example.com/role"}
+		}]`
+	})
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+	JustBeforeEach(func() {
+		filename := filepath.Join(dir, "issuers.json")
+		_ = ioutil.WriteFile(filename, []byte(contents), 0600)
+		issuers, err = jwt.LoadIssuers(filename)
+	})
+	When("the file is malformed", func() {
+		BeforeEach(func() {
+			contents = `[{"name": "partners", "iss": "https://partners.example.com"}]`
+		})
+		It("fails", func() {
+			Expect(err).To(MatchError(`malformed issuers file: json: unknown field "iss"`))
+		})
+	})
+	When("an issuer is missing", func() {
+		BeforeEach(func() {
+			contents = `[{"name": "partners"}]`
+		})
+		It("fails", func() {
+			Expect(err).To(MatchError("malformed issuers file: issuer 0 must have a name and an issuer"))
+		})
+	})
+	When("an issuer's claim headers are invalid", func() {
+		BeforeEach(func() {
+			contents = `[{"name": "partners", "issuer": "https://partners.example.com", "claims": {"headers": [{"claim": "sub"}]}}]`
+		})
+		It("fails", func() {
+			Expect(err).To(MatchError("malformed issuers file: issuer 0: header mapping 0 must have a claim and a header"))
+		})
+	})
+	Context("otherwise", func() {
+		It("succeeds", func() {
+			Expect(err).To(BeNil())
+			Expect(issuers).To(Equal([]jwt.IssuerConfig{{
+				Name:       "partners",
+				Issuer:     "https://partners.example.com",
+				Audience:   "https://api.smartatransit.com",
+				Algorithms: []string{"ES256"},
+				Claims:     jwt.ClaimMapping{Role: "https://partners.example.com/role"},
+			}}))
+		})
+	})
+})
+
+var _ = Describe("IssuerConfig", func() {
+	var (
+		cfg    jwt.IssuerConfig
+		keys   *jwtfakes.FakeKeys
+		parser jwt.ParserAgent
+	)
+	BeforeEach(func() {
+		// The issuer from --issuer-url and --audience
+		cfg = jwt.IssuerConfig{
+			Name:     "default",
+			Issuer:   "https://tenant.auth0.com/",
+			Audience: "https://api.smartatransit.com",
+		}
+
+		keys = &jwtfakes.FakeKeys{}
+		keys.FetchReturns(jose.JSONWebKey{KeyID: "my-kid", Algorithm: "RS256", Key: &fixtureKey.PublicKey}, nil)
+	})
+	JustBeforeEach(func() {
+		parser = jwt.NewParser(keys, cfg.Validation([]string{"RS256"}, 0), cfg.Claims)
+	})
+
+	sign := func(aud string) string {
+		token := djwt.NewWithClaims(djwt.SigningMethodRS256, djwt.MapClaims{
+			"iss": "https://tenant.auth0.com/",
+			"aud": aud,
+			"exp": time.Now().Add(time.Hour).Unix(),
+		})
+		token.Header["kid"] = "my-kid"
+		signed, _ := token.SignedString(fixtureKey)
+		return signed
+	}
+
+	Describe("Validation", func() {
+		It("accepts tokens for the audience", func() {
+			_, err := parser.ParseToken(context.Background(), sign("https://api.smartatransit.com"))
+			Expect(err).To(BeNil())
+		})
+		It("rejects tokens the issuer made for another API", func() {
+			_, err := parser.ParseToken(context.Background(), sign("https://billing.smartatransit.com"))
+			Expect(errors.Is(err, jwt.ErrInvalidAudience)).To(BeTrue())
+		})
+		It("uses the given algorithms if the issuer lists none", func() {
+			Expect(cfg.Validation([]string{"RS256"}, 0).Algorithms).To(Equal([]string{"RS256"}))
+
+			cfg.Algorithms = []string{"ES256"}
+			Expect(cfg.Validation([]string{"RS256"}, 0).Algorithms).To(Equal([]string{"ES256"}))
+		})
+	})
+	Describe("RequireAudiences", func() {
+		It("accepts issuers with audiences", func() {
+			Expect(jwt.RequireAudiences([]jwt.IssuerConfig{cfg})).To(Succeed())
+		})
+		When("an issuer has no audience", func() {
+			It("fails", func() {
+				partners := jwt.IssuerConfig{Name: "partners", Issuer: "https://partners.example.com"}
+				err := jwt.RequireAudiences([]jwt.IssuerConfig{cfg, partners})
+				Expect(err).To(MatchError("issuer has no audience: `partners`"))
+				Expect(errors.Is(err, jwt.ErrMissingAudience)).To(BeTrue())
+			})
+		})
+	})
+})
@@ -2,18 +2,54 @@ package jwt
 
 import (
 	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"net/http"
+	"time"
 
 	"github.com/dgrijalva/jwt-go"
+	jose "gopkg.in/square/go-jose.v2"
 )
 
 // Authorization is used to interact with the `jwt` package
 type Authorization struct {
 	jwt.StandardClaims
-	Session string `json:"https://jwt.smartatransit.com/session"`
-	Role    string `json:"https://jwt.smartatransit.com/role"`
+	// Audience shadows StandardClaims.Audience, which can't decode the
+	// array form of the `aud` claim.
+	Audience Audience `json:"aud,omitempty"`
+
+	// Session and Role are read from Claims according to the issuer's
+	// ClaimMapping.
+	Session string `json:"-"`
+	Role    string `json:"-"`
+
+	// IssuerName is the configured name of the issuer that authenticated
+	// the token, when there are several trusted issuers.
+	IssuerName string `json:"-"`
+
+	// Headers are the extra headers forwarded upstream, built from Claims
+	// according to the issuer's ClaimMapping.
+	Headers http.Header `json:"-"`
+
+	// Claims holds every claim in the token
+	Claims map[string]interface{} `json:"-"`
+}
+
+// UnmarshalJSON implements json.Unmarshaler
+func (a *Authorization) UnmarshalJSON(b []byte) error {
+	// claims has the same fields as Authorization but none of its methods,
+	// so decoding into it doesn't recurse.
+	type claims Authorization
+	if err := json.Unmarshal(b, (*claims)(a)); err != nil {
+		return err
+	}
+
+	return json.Unmarshal(b, &a.Claims)
 }
 
 // SetAuthHeaders converts the authorization claims into
@@ -21,6 +57,23 @@ type Authorization struct {
 func (a Authorization) SetAuthHeaders(w http.ResponseWriter) {
 	w.Header().Set("X-Smarta-Auth-Session", a.Session)
 	w.Header().Set("X-Smarta-Auth-Role", a.Role)
+	if a.IssuerName != "" {
+		w.Header().Set("X-Smarta-Auth-Issuer", a.IssuerName)
+	}
+	for name, values := range a.Headers {
+		w.Header()[name] = values
+	}
+}
+
+// ClientID returns the OAuth client the token was issued to, from the
+// `azp` claim or, failing that, `client_id`
+func (a Authorization) ClientID() string {
+	for _, name := range []string{"azp", "client_id"} {
+		if id, ok := a.Claims[name].(string); ok {
+			return id
+		}
+	}
+	return ""
 }
 
 // Valid implements jwt.Authorization
@@ -28,24 +81,106 @@ func (a Authorization) Valid() error {
 	return a.StandardClaims.Valid()
 }
 
+// Audience is the `aud` claim, which may be either a single string or an
+// array of strings.
+type Audience []string
+
+// UnmarshalJSON implements json.Unmarshaler
+func (a *Audience) UnmarshalJSON(b []byte) error {
+	var single string
+	if err := json.Unmarshal(b, &single); err == nil {
+		*a = Audience{single}
+		return nil
+	}
+
+	var multiple []string
+	if err := json.Unmarshal(b, &multiple); err != nil {
+		return fmt.Errorf("aud must be a string or an array of strings: %w", err)
+	}
+	*a = multiple
+	return nil
+}
+
+// Contains reports whether the audience includes aud
+func (a Audience) Contains(aud string) bool {
+	for _, v := range a {
+		if v == aud {
+			return true
+		}
+	}
+	return false
+}
+
+// Rejection reasons returned (wrapped) by ParserAgent.ParseToken
+var (
+	ErrMalformedToken      = errors.New("malformed token")
+	ErrDisallowedAlgorithm = errors.New("signing algorithm not allowed")
+	ErrInvalidSignature    = errors.New("invalid signature")
+	ErrTokenExpired        = errors.New("token is expired")
+	ErrTokenNotYetValid    = errors.New("token is not valid yet")
+	ErrTokenIssuedInFuture = errors.New("token was issued in the future")
+	ErrInvalidIssuer       = errors.New("token issuer is not trusted")
+	ErrInvalidAudience     = errors.New("token audience doesn't include this API")
+
+	errAlgorithmMismatch = errors.New("jwk algorithm didn't match")
+)
+
+// DefaultAlgorithms are the signing algorithms accepted when a Validation
+// doesn't list any.
+var DefaultAlgorithms = []string{"RS256", "ES256", "ES384", "EdDSA"}
+
+// Validation lists what a ParserAgent requires of every token on top of a
+// valid signature. An empty Issuer or Audience isn't checked.
+type Validation struct {
+	Issuer     string
+	Audience   string
+	Algorithms []string
+	// Leeway allows for clock skew when checking exp, nbf, and iat
+	Leeway time.Duration
+}
+
 // Parser parses a JWT into an Authorization struct
 //go:generate counterfeiter . Parser
 type Parser interface {
 	ParseToken(ctx context.Context, tokenStr string) (Authorization, error)
 }
 
-// NewParser creates a new JWT parser
-func NewParser(keys Keys) ParserAgent {
+// NewParser creates a new JWT parser. The namespaces, session, and role of
+// the claim mapping may be left empty to use the defaults.
+func NewParser(keys Keys, validation Validation, claims ClaimMapping) ParserAgent {
+	if len(validation.Algorithms) == 0 {
+		validation.Algorithms = DefaultAlgorithms
+	}
+	if claims.Namespaces == nil {
+		claims.Namespaces = DefaultClaimMapping.Namespaces
+	}
+	if claims.Session == "" {
+		claims.Session = DefaultClaimMapping.Session
+	}
+	if claims.Role == "" {
+		claims.Role = DefaultClaimMapping.Role
+	}
+
+	// Claims are validated by ParseToken instead of the jwt package, so that
+	// the leeway can be applied.
+	parser := &jwt.Parser{SkipClaimsValidation: true}
+
 	return ParserAgent{
 		keys:            keys,
-		ParseWithClaims: jwt.ParseWithClaims,
+		validation:      validation,
+		claims:          claims,
+		ParseWithClaims: parser.ParseWithClaims,
+		Now:             time.Now,
 	}
 }
 
 // ParserAgent implements Parser
 type ParserAgent struct {
 	keys            Keys
+	validation      Validation
+	claims          ClaimMapping
 	ParseWithClaims ParseFunc
+	Now             func() time.Time
 }
 
 //go:generate counterfeiter . ParseFunc
@@ -57,23 +192,123 @@ type ParseFunc func(tokenString string, claims jwt.Claims, keyFunc jwt.Keyfunc)
 // the `kid` in the key for verification.
 func (a ParserAgent) ParseToken(ctx context.Context, tokenStr string) (Authorization, error) {
 	var auth Authorization
-	_, err := a.ParseWithClaims(tokenStr, &auth, a.keyFunc)
+	_, err := a.ParseWithClaims(tokenStr, &auth, func(t *jwt.Token) (interface{}, error) {
+		return a.keyFunc(ctx, t)
+	})
 	if err != nil {
-		return Authorization{}, fmt.Errorf("failed parsing JWT: %w", err)
+		return Authorization{}, fmt.Errorf("failed parsing JWT: %w", classifyParseError(err))
+	}
+
+	if err := a.validate(auth); err != nil {
+		return Authorization{}, fmt.Errorf("failed validating JWT: %w", err)
 	}
 
+	a.claims.apply(&auth)
 	return auth, nil
 }
 
-func (a ParserAgent) keyFunc(t *jwt.Token) (interface{}, error) {
-	key, err := a.keys.Fetch(t.Header["kid"].(string))
+func (a ParserAgent) keyFunc(ctx context.Context, t *jwt.Token) (interface{}, error) {
+	alg, _ := t.Header["alg"].(string)
+	if !a.allowsAlgorithm(alg) {
+		return nil, fmt.Errorf("%w: `%s`", ErrDisallowedAlgorithm, alg)
+	}
+
+	kid, ok := t.Header["kid"].(string)
+	if !ok {
+		return nil, fmt.Errorf("%w: missing kid header", ErrMalformedToken)
+	}
+
+	key, err := a.keys.Fetch(ctx, kid)
 	if err != nil {
 		return nil, fmt.Errorf("failed fectching keys: %w", err)
 	}
 
-	if key.Algorithm != t.Header["alg"].(string) {
-		return nil, errors.New("jwk algorithm didn't match")
+	if !keyMatchesAlgorithm(key, alg) {
+		return nil, errAlgorithmMismatch
 	}
 
 	return key.Key, nil
 }
+
+// keyMatchesAlgorithm checks that a JWK can verify tokens signed with alg.
+// If the JWK names an algorithm it must be alg, and either way the type
+// (and curve) of the key must suit alg.
+func keyMatchesAlgorithm(key jose.JSONWebKey, alg string) bool {
+	if key.Algorithm != "" && key.Algorithm != alg {
+		return false
+	}
+
+	switch k := key.Key.(type) {
+	case *rsa.PublicKey:
+		switch alg {
+		case "RS256", "RS384", "RS512", "PS256", "PS384", "PS512":
+			return true
+		}
+	case *ecdsa.PublicKey:
+		switch k.Curve {
+		case elliptic.P256():
+			return alg == "ES256"
+		case elliptic.P384():
+			return alg == "ES384"
+		case elliptic.P521():
+			return alg == "ES512"
+		}
+	case ed25519.PublicKey:
+		return alg == SigningMethodEd25519.Alg()
+	}
+
+	return false
+}
+
+func (a ParserAgent) allowsAlgorithm(alg string) bool {
+	for _, allowed := range a.validation.Algorithms {
+		if alg == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+// classifyParseError maps the jwt package's validation errors onto our own.
+// Errors returned from keyFunc are passed through as they are.
+func classifyParseError(err error) error {
+	var ve *jwt.ValidationError
+	if !errors.As(err, &ve) {
+		return err
+	}
+
+	switch {
+	case ve.Inner != nil && ve.Errors&jwt.ValidationErrorUnverifiable != 0:
+		return ve.Inner
+	case ve.Errors&jwt.ValidationErrorMalformed != 0:
+		return fmt.Errorf("%w: %s", ErrMalformedToken, ve.Error())
+	case ve.Errors&jwt.ValidationErrorSignatureInvalid != 0:
+		return ErrInvalidSignature
+	default:
+		return err
+	}
+}
+
+func (a ParserAgent) validate(auth Authorization) error {
+	now := a.Now().Unix()
+	leeway := int64(a.validation.Leeway / time.Second)
+
+	if auth.ExpiresAt != 0 && now > auth.ExpiresAt+leeway {
+		return ErrTokenExpired
+	}
+	if auth.NotBefore != 0 && now+leeway < auth.NotBefore {
+		return ErrTokenNotYetValid
+	}
+	if auth.IssuedAt != 0 && now+leeway < auth.IssuedAt {
+		return ErrTokenIssuedInFuture
+	}
+
+	if a.validation.Issuer != "" && normalizeIssuer(auth.Issuer) != normalizeIssuer(a.validation.Issuer) {
+		return fmt.Errorf("%w: `%s`", ErrInvalidIssuer, auth.Issuer)
+	}
+	if a.validation.Audience != "" && !auth.Audience.Contains(a.validation.Audience) {
+		return ErrInvalidAudience
+	}
+
+	return nil
+}
@@ -2,10 +2,20 @@ package jwt_test
 
 import (
 	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
 	"errors"
+	"net/http"
 	"net/http/httptest"
+	"time"
 
 	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
 	. "github.com/onsi/gomega"
 	. "github.com/onsi/gomega/gstruct"
 
@@ -16,45 +26,78 @@ import (
 	"github.com/smartatransit/api-gateway/jwt/jwtfakes"
 )
 
+// fixtureKey is shared by the tests that don't sign anything, since
+// generating RSA keys is slow.
+var fixtureKey, _ = rsa.GenerateKey(rand.Reader, 2048)
+
 var _ = Describe("Parser", func() {
 	var (
 		keys      *jwtfakes.FakeKeys
 		parseFunc *jwtfakes.FakeParseFunc
+		header    map[string]interface{}
+		claims    jwt.Authorization
+		now       time.Time
 
-		parser jwt.ParserAgent
+		validation jwt.Validation
+		mapping    jwt.ClaimMapping
+		parser     jwt.ParserAgent
 	)
 	BeforeEach(func() {
+		now = time.Unix(1600000000, 0)
+
 		keys = &jwtfakes.FakeKeys{}
 		keys.FetchReturns(jose.JSONWebKey{
 			KeyID:     "my-kid",
-			Algorithm: "rsa",
-			Key:       "my-public-key",
+			Algorithm: "RS256",
+			Key:       &fixtureKey.PublicKey,
 		}, nil)
 
+		header = map[string]interface{}{
+			"kid": "token-kid",
+			"alg": "RS256",
+		}
+		claims = jwt.Authorization{
+			StandardClaims: djwt.StandardClaims{
+				Issuer:    "https://tenant.auth0.com/",
+				ExpiresAt: now.Add(time.Hour).Unix(),
+				IssuedAt:  now.Add(-time.Minute).Unix(),
+			},
+			Audience: jwt.Audience{"https://api.smartatransit.com", "https://tenant.auth0.com/userinfo"},
+			Claims: map[string]interface{}{
+				"https://jwt.smartatransit.com/session": "sess-val",
+				"https://jwt.smartatransit.com/role":    "role-val",
+				"https://other.example.com/role":        "other-role-val",
+			},
+		}
+
 		parseFunc = &jwtfakes.FakeParseFunc{
-			Stub: func(tokenString string, claims djwt.Claims, keyFunc djwt.Keyfunc) (*djwt.Token, error) {
+			Stub: func(tokenString string, c djwt.Claims, keyFunc djwt.Keyfunc) (*djwt.Token, error) {
 				key, err := keyFunc(&djwt.Token{
-					Header: map[string]interface{}{
-						"kid": "token-kid",
-						"alg": "rsa",
-					},
+					Header: header,
 				})
 				if err != nil {
 					return nil, err
 				}
 
-				Expect(key).To(Equal("my-public-key"))
+				Expect(key).To(Equal(&fixtureKey.PublicKey))
 
-				authPtr := claims.(*jwt.Authorization)
-				authPtr.Role = "role-val"
-				authPtr.Session = "sess-val"
+				*c.(*jwt.Authorization) = claims
 
 				return nil, nil
 			},
 		}
 
-		parser = jwt.NewParser(keys)
+		validation = jwt.Validation{
+			Issuer:   "https://tenant.auth0.com/",
+			Audience: "https://api.smartatransit.com",
+			Leeway:   30 * time.Second,
+		}
+		mapping = jwt.ClaimMapping{}
+	})
+	JustBeforeEach(func() {
+		parser = jwt.NewParser(keys, validation, mapping)
 		parser.ParseWithClaims = parseFunc.Spy
+		parser.Now = func() time.Time { return now }
 	})
 	Describe("ParseToken", func() {
 		var (
@@ -75,13 +118,146 @@ var _ = Describe("Parser", func() {
 		When("the JWK algorithm doesn't match", func() {
 			BeforeEach(func() {
 				keys.FetchReturns(jose.JSONWebKey{
-					Algorithm: "hmac",
+					Algorithm: "RS384",
+					Key:       &fixtureKey.PublicKey,
 				}, nil)
 			})
 			It("fails", func() {
 				Expect(err).To(MatchError("failed parsing JWT: jwk algorithm didn't match"))
 			})
 		})
+		When("the JWK has no algorithm", func() {
+			BeforeEach(func() {
+				keys.FetchReturns(jose.JSONWebKey{
+					Key: &fixtureKey.PublicKey,
+				}, nil)
+			})
+			It("infers it from the key type", func() {
+				Expect(err).To(BeNil())
+			})
+		})
+		When("the JWK's key type doesn't suit the token's algorithm", func() {
+			BeforeEach(func() {
+				header["alg"] = "ES256"
+				keys.FetchReturns(jose.JSONWebKey{
+					Key: &fixtureKey.PublicKey,
+				}, nil)
+			})
+			It("fails", func() {
+				Expect(err).To(MatchError("failed parsing JWT: jwk algorithm didn't match"))
+			})
+		})
+		When("the token's algorithm isn't allowed", func() {
+			BeforeEach(func() {
+				header["alg"] = "HS256"
+			})
+			It("fails without fetching keys", func() {
+				Expect(errors.Is(err, jwt.ErrDisallowedAlgorithm)).To(BeTrue())
+				Expect(keys.FetchCallCount()).To(Equal(0))
+			})
+		})
+		When("the token is unsigned", func() {
+			BeforeEach(func() {
+				header["alg"] = "none"
+			})
+			It("fails", func() {
+				Expect(errors.Is(err, jwt.ErrDisallowedAlgorithm)).To(BeTrue())
+			})
+		})
+		When("the token has no kid", func() {
+			BeforeEach(func() {
+				delete(header, "kid")
+			})
+			It("fails", func() {
+				Expect(errors.Is(err, jwt.ErrMalformedToken)).To(BeTrue())
+			})
+		})
+		When("the token has expired", func() {
+			BeforeEach(func() {
+				claims.ExpiresAt = now.Add(-time.Minute).Unix()
+			})
+			It("fails", func() {
+				Expect(errors.Is(err, jwt.ErrTokenExpired)).To(BeTrue())
+			})
+		})
+		When("the token expired within the leeway", func() {
+			BeforeEach(func() {
+				claims.ExpiresAt = now.Add(-10 * time.Second).Unix()
+			})
+			It("succeeds", func() {
+				Expect(err).To(BeNil())
+			})
+		})
+		When("the token isn't valid yet", func() {
+			BeforeEach(func() {
+				claims.NotBefore = now.Add(time.Minute).Unix()
+			})
+			It("fails", func() {
+				Expect(errors.Is(err, jwt.ErrTokenNotYetValid)).To(BeTrue())
+			})
+		})
+		When("the token was issued in the future", func() {
+			BeforeEach(func() {
+				claims.IssuedAt = now.Add(time.Minute).Unix()
+			})
+			It("fails", func() {
+				Expect(errors.Is(err, jwt.ErrTokenIssuedInFuture)).To(BeTrue())
+			})
+		})
+		When("the token was issued in the future, within the leeway", func() {
+			BeforeEach(func() {
+				claims.IssuedAt = now.Add(10 * time.Second).Unix()
+				claims.NotBefore = now.Add(10 * time.Second).Unix()
+			})
+			It("succeeds", func() {
+				Expect(err).To(BeNil())
+			})
+		})
+		When("the issuer is wrong", func() {
+			BeforeEach(func() {
+				claims.Issuer = "https://other-tenant.auth0.com/"
+			})
+			It("fails", func() {
+				Expect(errors.Is(err, jwt.ErrInvalidIssuer)).To(BeTrue())
+				Expect(err).To(MatchError("failed validating JWT: token issuer is not trusted: `https://other-tenant.auth0.com/`"))
+			})
+		})
+		When("the issuer is configured without its trailing slash", func() {
+			BeforeEach(func() {
+				validation.Issuer = "https://tenant.auth0.com"
+			})
+			It("succeeds", func() {
+				Expect(err).To(BeNil())
+			})
+		})
+		When("the audience doesn't include this API", func() {
+			BeforeEach(func() {
+				claims.Audience = jwt.Audience{"https://some-other-api.example.com"}
+			})
+			It("fails", func() {
+				Expect(errors.Is(err, jwt.ErrInvalidAudience)).To(BeTrue())
+			})
+		})
+		When("the issuer and audience aren't configured", func() {
+			BeforeEach(func() {
+				validation = jwt.Validation{}
+				claims.Issuer = "https://other-tenant.auth0.com/"
+				claims.Audience = nil
+			})
+			It("doesn't check them", func() {
+				Expect(err).To(BeNil())
+			})
+		})
+		When("the issuer uses different claim names", func() {
+			BeforeEach(func() {
+				mapping = jwt.ClaimMapping{Role: "https://other.example.com/role"}
+			})
+			It("reads the session and role from them", func() {
+				Expect(err).To(BeNil())
+				Expect(auth.Session).To(Equal("sess-val"))
+				Expect(auth.Role).To(Equal("other-role-val"))
+			})
+		})
 		Context("otherwise", func() {
 			It("succeeds", func() {
 				Expect(err).To(BeNil())
@@ -90,6 +266,140 @@ var _ = Describe("Parser", func() {
 			})
 		})
 	})
+	Describe("ParseToken with real tokens", func() {
+		var (
+			privateKey *rsa.PrivateKey
+			token      *djwt.Token
+		)
+		BeforeEach(func() {
+			privateKey, _ = rsa.GenerateKey(rand.Reader, 2048)
+			keys.FetchReturns(jose.JSONWebKey{
+				KeyID:     "my-kid",
+				Algorithm: "RS256",
+				Key:       &privateKey.PublicKey,
+			}, nil)
+
+			token = djwt.NewWithClaims(djwt.SigningMethodRS256, djwt.MapClaims{
+				"iss":                                "https://tenant.auth0.com/",
+				"aud":                                []string{"https://api.smartatransit.com"},
+				"exp":                                now.Add(time.Hour).Unix(),
+				"https://jwt.smartatransit.com/role": "rider",
+			})
+			token.Header["kid"] = "my-kid"
+		})
+		JustBeforeEach(func() {
+			parser = jwt.NewParser(keys, validation, mapping)
+			parser.Now = func() time.Time { return now }
+		})
+		It("accepts a valid token", func() {
+			signed, _ := token.SignedString(privateKey)
+			auth, err := parser.ParseToken(context.Background(), signed)
+			Expect(err).To(BeNil())
+			Expect(auth.Role).To(Equal("rider"))
+			Expect(auth.Audience).To(ConsistOf("https://api.smartatransit.com"))
+		})
+		It("rejects a bad signature", func() {
+			otherKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+			signed, _ := token.SignedString(otherKey)
+			_, err := parser.ParseToken(context.Background(), signed)
+			Expect(errors.Is(err, jwt.ErrInvalidSignature)).To(BeTrue())
+		})
+		It("rejects an HMAC token signed with the public key", func() {
+			hmacToken := djwt.NewWithClaims(djwt.SigningMethodHS256, token.Claims)
+			hmacToken.Header["kid"] = "my-kid"
+			signed, _ := hmacToken.SignedString(x509.MarshalPKCS1PublicKey(&privateKey.PublicKey))
+			_, err := parser.ParseToken(context.Background(), signed)
+			Expect(errors.Is(err, jwt.ErrDisallowedAlgorithm)).To(BeTrue())
+		})
+		It("rejects garbage", func() {
+			_, err := parser.ParseToken(context.Background(), "not.a.jwt")
+			Expect(errors.Is(err, jwt.ErrMalformedToken)).To(BeTrue())
+		})
+	})
+	Describe("ParseToken with other key types", func() {
+		// publishedKey round-trips a public key through its JWK encoding,
+		// without an `alg`, the way a KeyServer would receive it.
+		publishedKey := func(public interface{}) jose.JSONWebKey {
+			b, err := json.Marshal(jose.JSONWebKey{KeyID: "my-kid", Key: public})
+			Expect(err).To(BeNil())
+
+			var key jose.JSONWebKey
+			Expect(json.Unmarshal(b, &key)).To(Succeed())
+			Expect(key.Algorithm).To(BeEmpty())
+			return key
+		}
+
+		sign := func(method djwt.SigningMethod, private interface{}) string {
+			token := djwt.NewWithClaims(method, djwt.MapClaims{
+				"iss":                                "https://tenant.auth0.com/",
+				"aud":                                "https://api.smartatransit.com",
+				"exp":                                now.Add(time.Hour).Unix(),
+				"https://jwt.smartatransit.com/role": "rider",
+			})
+			token.Header["kid"] = "my-kid"
+
+			signed, err := token.SignedString(private)
+			Expect(err).To(BeNil())
+			return signed
+		}
+
+		JustBeforeEach(func() {
+			parser = jwt.NewParser(keys, validation, mapping)
+			parser.Now = func() time.Time { return now }
+		})
+
+		DescribeTable("accepts valid tokens",
+			func(method djwt.SigningMethod, newKey func() (public, private interface{})) {
+				public, private := newKey()
+				keys.FetchReturns(publishedKey(public), nil)
+
+				auth, err := parser.ParseToken(context.Background(), sign(method, private))
+				Expect(err).To(BeNil())
+				Expect(auth.Role).To(Equal("rider"))
+			},
+			Entry("RS256", djwt.SigningMethodRS256, func() (interface{}, interface{}) {
+				return &fixtureKey.PublicKey, fixtureKey
+			}),
+			Entry("ES256", djwt.SigningMethodES256, func() (interface{}, interface{}) {
+				k, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+				return &k.PublicKey, k
+			}),
+			Entry("ES384", djwt.SigningMethodES384, func() (interface{}, interface{}) {
+				k, _ := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+				return &k.PublicKey, k
+			}),
+			Entry("EdDSA", jwt.SigningMethodEd25519, func() (interface{}, interface{}) {
+				public, private, _ := ed25519.GenerateKey(rand.Reader)
+				return public, private
+			}),
+		)
+
+		It("rejects an ECDSA token verified with a key on the wrong curve", func() {
+			k256, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+			k384, _ := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+			keys.FetchReturns(publishedKey(&k256.PublicKey), nil)
+
+			_, err := parser.ParseToken(context.Background(), sign(djwt.SigningMethodES384, k384))
+			Expect(err).To(MatchError("failed parsing JWT: jwk algorithm didn't match"))
+		})
+		It("rejects an EdDSA token with a bad signature", func() {
+			public, _, _ := ed25519.GenerateKey(rand.Reader)
+			_, other, _ := ed25519.GenerateKey(rand.Reader)
+			keys.FetchReturns(publishedKey(public), nil)
+
+			_, err := parser.ParseToken(context.Background(), sign(jwt.SigningMethodEd25519, other))
+			Expect(errors.Is(err, jwt.ErrInvalidSignature)).To(BeTrue())
+		})
+		It("rejects algorithms that aren't allowed", func() {
+			validation.Algorithms = []string{"RS256"}
+			parser = jwt.NewParser(keys, validation, mapping)
+			public, private, _ := ed25519.GenerateKey(rand.Reader)
+			keys.FetchReturns(publishedKey(public), nil)
+
+			_, err := parser.ParseToken(context.Background(), sign(jwt.SigningMethodEd25519, private))
+			Expect(errors.Is(err, jwt.ErrDisallowedAlgorithm)).To(BeTrue())
+		})
+	})
 })
 
 var _ = Describe("Authorization", func() {
@@ -106,6 +416,44 @@ var _ = Describe("Authorization", func() {
 				"X-Smarta-Auth-Role":    ConsistOf("role"),
 			}))
 		})
+		It("includes the issuer name when there is one", func() {
+			rw := httptest.NewRecorder()
+			jwt.Authorization{
+				Session:    "sess",
+				Role:       "role",
+				IssuerName: "partners",
+			}.SetAuthHeaders(rw)
+
+			Expect(rw.Result().Header).To(MatchAllKeys(Keys{
+				"X-Smarta-Auth-Session": ConsistOf("sess"),
+				"X-Smarta-Auth-Role":    ConsistOf("role"),
+				"X-Smarta-Auth-Issuer":  ConsistOf("partners"),
+			}))
+		})
+		It("includes the mapped claim headers", func() {
+			rw := httptest.NewRecorder()
+			jwt.Authorization{
+				Session: "sess",
+				Role:    "role",
+				Headers: http.Header{"X-Smarta-Auth-Subject": {"auth0|123"}},
+			}.SetAuthHeaders(rw)
+
+			Expect(rw.Result().Header).To(MatchAllKeys(Keys{
+				"X-Smarta-Auth-Session": ConsistOf("sess"),
+				"X-Smarta-Auth-Role":    ConsistOf("role"),
+				"X-Smarta-Auth-Subject": ConsistOf("auth0|123"),
+			}))
+		})
+	})
+	Describe("UnmarshalJSON", func() {
+		It("decodes the standard claims and keeps every claim", func() {
+			var auth jwt.Authorization
+			Expect(json.Unmarshal([]byte(`{"sub": "user", "exp": 1600000000, "aud": "api", "custom": {"a": 1}}`), &auth)).To(Succeed())
+			Expect(auth.Subject).To(Equal("user"))
+			Expect(auth.ExpiresAt).To(Equal(int64(1600000000)))
+			Expect(auth.Audience).To(Equal(jwt.Audience{"api"}))
+			Expect(auth.Claims).To(HaveKeyWithValue("custom", map[string]interface{}{"a": 1.0}))
+		})
 	})
 	Describe("Valid", func() {
 		It("returns nil", func() {
@@ -113,3 +461,23 @@ var _ = Describe("Authorization", func() {
 		})
 	})
 })
+
+var _ = Describe("Audience", func() {
+	It("decodes a single string", func() {
+		var aud jwt.Audience
+		Expect(json.Unmarshal([]byte(`"api"`), &aud)).To(Succeed())
+		Expect(aud).To(Equal(jwt.Audience{"api"}))
+		Expect(aud.Contains("api")).To(BeTrue())
+	})
+	It("decodes an array", func() {
+		var aud jwt.Audience
+		Expect(json.Unmarshal([]byte(`["api", "userinfo"]`), &aud)).To(Succeed())
+		Expect(aud).To(Equal(jwt.Audience{"api", "userinfo"}))
+		Expect(aud.Contains("userinfo")).To(BeTrue())
+		Expect(aud.Contains("other")).To(BeFalse())
+	})
+	It("rejects anything else", func() {
+		var aud jwt.Audience
+		Expect(json.Unmarshal([]byte(`{"api": true}`), &aud)).NotTo(Succeed())
+	})
+})
@@ -2,6 +2,7 @@
 package jwtfakes
 
 import (
+	"context"
 	"sync"
 
 	"github.com/smartatransit/api-gateway/jwt"
@@ -9,10 +10,11 @@ import (
 )
 
 type FakeKeys struct {
-	FetchStub        func(string) (jose.JSONWebKey, error)
+	FetchStub        func(context.Context, string) (jose.JSONWebKey, error)
 	fetchMutex       sync.RWMutex
 	fetchArgsForCall []struct {
-		arg1 string
+		arg1 context.Context
+		arg2 string
 	}
 	fetchReturns struct {
 		result1 jose.JSONWebKey
@@ -26,21 +28,23 @@ type FakeKeys struct {
 	invocationsMutex sync.RWMutex
 }
 
-func (fake *FakeKeys) Fetch(arg1 string) (jose.JSONWebKey, error) {
+func (fake *FakeKeys) Fetch(arg1 context.Context, arg2 string) (jose.JSONWebKey, error) {
 	fake.fetchMutex.Lock()
 	ret, specificReturn := fake.fetchReturnsOnCall[len(fake.fetchArgsForCall)]
 	fake.fetchArgsForCall = append(fake.fetchArgsForCall, struct {
-		arg1 string
-	}{arg1})
-	fake.recordInvocation("Fetch", []interface{}{arg1})
+		arg1 context.Context
+		arg2 string
+	}{arg1, arg2})
+	stub := fake.FetchStub
+	fakeReturns := fake.fetchReturns
+	fake.recordInvocation("Fetch", []interface{}{arg1, arg2})
 	fake.fetchMutex.Unlock()
-	if fake.FetchStub != nil {
-		return fake.FetchStub(arg1)
+	if stub != nil {
+		return stub(arg1, arg2)
 	}
 	if specificReturn {
 		return ret.result1, ret.result2
 	}
-	fakeReturns := fake.fetchReturns
 	return fakeReturns.result1, fakeReturns.result2
 }
 
@@ -50,17 +54,17 @@ func (fake *FakeKeys) FetchCallCount() int {
 	return len(fake.fetchArgsForCall)
 }
 
-func (fake *FakeKeys) FetchCalls(stub func(string) (jose.JSONWebKey, error)) {
+func (fake *FakeKeys) FetchCalls(stub func(context.Context, string) (jose.JSONWebKey, error)) {
 	fake.fetchMutex.Lock()
 	defer fake.fetchMutex.Unlock()
 	fake.FetchStub = stub
 }
 
-func (fake *FakeKeys) FetchArgsForCall(i int) string {
+func (fake *FakeKeys) FetchArgsForCall(i int) (context.Context, string) {
 	fake.fetchMutex.RLock()
 	defer fake.fetchMutex.RUnlock()
 	argsForCall := fake.fetchArgsForCall[i]
-	return argsForCall.arg1
+	return argsForCall.arg1, argsForCall.arg2
 }
 
 func (fake *FakeKeys) FetchReturns(result1 jose.JSONWebKey, result2 error) {
@@ -0,0 +1,119 @@
+// Code generated by counterfeiter. DO NOT EDIT.
+package jwtfakes
+
+import (
+	"context"
+	"sync"
+
+	"github.com/smartatransit/api-gateway/jwt"
+)
+
+type FakeMinter struct {
+	MintStub        func(context.Context, jwt.Authorization) (string, error)
+	mintMutex       sync.RWMutex
+	mintArgsForCall []struct {
+		arg1 context.Context
+		arg2 jwt.Authorization
+	}
+	mintReturns struct {
+		result1 string
+		result2 error
+	}
+	mintReturnsOnCall map[int]struct {
+		result1 string
+		result2 error
+	}
+	invocations      map[string][][]interface{}
+	invocationsMutex sync.RWMutex
+}
+
+func (fake *FakeMinter) Mint(arg1 context.Context, arg2 jwt.Authorization) (string, error) {
+	fake.mintMutex.Lock()
+	ret, specificReturn := fake.mintReturnsOnCall[len(fake.mintArgsForCall)]
+	fake.mintArgsForCall = append(fake.mintArgsForCall, struct {
+		arg1 context.Context
+		arg2 jwt.Authorization
+	}{arg1, arg2})
+	stub := fake.MintStub
+	fakeReturns := fake.mintReturns
+	fake.recordInvocation("Mint", []interface{}{arg1, arg2})
+	fake.mintMutex.Unlock()
+	if stub != nil {
+		return stub(arg1, arg2)
+	}
+	if specificReturn {
+		return ret.result1, ret.result2
+	}
+	return fakeReturns.result1, fakeReturns.result2
+}
+
+func (fake *FakeMinter) MintCallCount() int {
+	fake.mintMutex.RLock()
+	defer fake.mintMutex.RUnlock()
+	return len(fake.mintArgsForCall)
+}
+
+func (fake *FakeMinter) MintCalls(stub func(context.Context, jwt.Authorization) (string, error)) {
+	fake.mintMutex.Lock()
+	defer fake.mintMutex.Unlock()
+	fake.MintStub = stub
+}
+
+func (fake *FakeMinter) MintArgsForCall(i int) (context.Context, jwt.Authorization) {
+	fake.mintMutex.RLock()
+	defer fake.mintMutex.RUnlock()
+	argsForCall := fake.mintArgsForCall[i]
+	return argsForCall.arg1, argsForCall.arg2
+}
+
+func (fake *FakeMinter) MintReturns(result1 string, result2 error) {
+	fake.mintMutex.Lock()
+	defer fake.mintMutex.Unlock()
+	fake.MintStub = nil
+	fake.mintReturns = struct {
+		result1 string
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeMinter) MintReturnsOnCall(i int, result1 string, result2 error) {
+	fake.mintMutex.Lock()
+	defer fake.mintMutex.Unlock()
+	fake.MintStub = nil
+	if fake.mintReturnsOnCall == nil {
+		fake.mintReturnsOnCall = make(map[int]struct {
+			result1 string
+			result2 error
+		})
+	}
+	fake.mintReturnsOnCall[i] = struct {
+		result1 string
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeMinter) Invocations() map[string][][]interface{} {
+	fake.invocationsMutex.RLock()
+	defer fake.invocationsMutex.RUnlock()
+	fake.mintMutex.RLock()
+	defer fake.mintMutex.RUnlock()
+	copiedInvocations := map[string][][]interface{}{}
+	for key, value := range fake.invocations {
+		copiedInvocations[key] = value
+	}
+	return copiedInvocations
+}
+
+func (fake *FakeMinter) recordInvocation(key string, args []interface{}) {
+	fake.invocationsMutex.Lock()
+	defer fake.invocationsMutex.Unlock()
+	if fake.invocations == nil {
+		fake.invocations = map[string][][]interface{}{}
+	}
+	if fake.invocations[key] == nil {
+		fake.invocations[key] = [][]interface{}{}
+	}
+	fake.invocations[key] = append(fake.invocations[key], args)
+}
+
+var _ jwt.Minter = new(FakeMinter)
@@ -10,50 +10,60 @@ import (
 )
 
 type FakeTokenCache struct {
-	AddTokenStub        func(context.Context, string, string, time.Time)
+	AddTokenStub        func(context.Context, jwt.CredentialKey, string, time.Time) error
 	addTokenMutex       sync.RWMutex
 	addTokenArgsForCall []struct {
 		arg1 context.Context
-		arg2 string
+		arg2 jwt.CredentialKey
 		arg3 string
 		arg4 time.Time
 	}
-	CleanStub        func(context.Context)
-	cleanMutex       sync.RWMutex
-	cleanArgsForCall []struct {
-		arg1 context.Context
+	addTokenReturns struct {
+		result1 error
+	}
+	addTokenReturnsOnCall map[int]struct {
+		result1 error
 	}
-	FetchTokenStub        func(context.Context, string) (string, bool)
+	FetchTokenStub        func(context.Context, jwt.CredentialKey) (string, bool, error)
 	fetchTokenMutex       sync.RWMutex
 	fetchTokenArgsForCall []struct {
 		arg1 context.Context
-		arg2 string
+		arg2 jwt.CredentialKey
 	}
 	fetchTokenReturns struct {
 		result1 string
 		result2 bool
+		result3 error
 	}
 	fetchTokenReturnsOnCall map[int]struct {
 		result1 string
 		result2 bool
+		result3 error
 	}
 	invocations      map[string][][]interface{}
 	invocationsMutex sync.RWMutex
 }
 
-func (fake *FakeTokenCache) AddToken(arg1 context.Context, arg2 string, arg3 string, arg4 time.Time) {
+func (fake *FakeTokenCache) AddToken(arg1 context.Context, arg2 jwt.CredentialKey, arg3 string, arg4 time.Time) error {
 	fake.addTokenMutex.Lock()
+	ret, specificReturn := fake.addTokenReturnsOnCall[len(fake.addTokenArgsForCall)]
 	fake.addTokenArgsForCall = append(fake.addTokenArgsForCall, struct {
 		arg1 context.Context
-		arg2 string
+		arg2 jwt.CredentialKey
 		arg3 string
 		arg4 time.Time
 	}{arg1, arg2, arg3, arg4})
+	stub := fake.AddTokenStub
+	fakeReturns := fake.addTokenReturns
 	fake.recordInvocation("AddToken", []interface{}{arg1, arg2, arg3, arg4})
 	fake.addTokenMutex.Unlock()
-	if fake.AddTokenStub != nil {
-		fake.AddTokenStub(arg1, arg2, arg3, arg4)
+	if stub != nil {
+		return stub(arg1, arg2, arg3, arg4)
 	}
+	if specificReturn {
+		return ret.result1
+	}
+	return fakeReturns.result1
 }
 
 func (fake *FakeTokenCache) AddTokenCallCount() int {
@@ -62,67 +72,60 @@ func (fake *FakeTokenCache) AddTokenCallCount() int {
 	return len(fake.addTokenArgsForCall)
 }
 
-func (fake *FakeTokenCache) AddTokenCalls(stub func(context.Context, string, string, time.Time)) {
+func (fake *FakeTokenCache) AddTokenCalls(stub func(context.Context, jwt.CredentialKey, string, time.Time) error) {
 	fake.addTokenMutex.Lock()
 	defer fake.addTokenMutex.Unlock()
 	fake.AddTokenStub = stub
 }
 
-func (fake *FakeTokenCache) AddTokenArgsForCall(i int) (context.Context, string, string, time.Time) {
+func (fake *FakeTokenCache) AddTokenArgsForCall(i int) (context.Context, jwt.CredentialKey, string, time.Time) {
 	fake.addTokenMutex.RLock()
 	defer fake.addTokenMutex.RUnlock()
 	argsForCall := fake.addTokenArgsForCall[i]
 	return argsForCall.arg1, argsForCall.arg2, argsForCall.arg3, argsForCall.arg4
 }
 
-func (fake *FakeTokenCache) Clean(arg1 context.Context) {
-	fake.cleanMutex.Lock()
-	fake.cleanArgsForCall = append(fake.cleanArgsForCall, struct {
-		arg1 context.Context
-	}{arg1})
-	fake.recordInvocation("Clean", []interface{}{arg1})
-	fake.cleanMutex.Unlock()
-	if fake.CleanStub != nil {
-		fake.CleanStub(arg1)
-	}
-}
-
-func (fake *FakeTokenCache) CleanCallCount() int {
-	fake.cleanMutex.RLock()
-	defer fake.cleanMutex.RUnlock()
-	return len(fake.cleanArgsForCall)
-}
-
-func (fake *FakeTokenCache) CleanCalls(stub func(context.Context)) {
-	fake.cleanMutex.Lock()
-	defer fake.cleanMutex.Unlock()
-	fake.CleanStub = stub
+func (fake *FakeTokenCache) AddTokenReturns(result1 error) {
+	fake.addTokenMutex.Lock()
+	defer fake.addTokenMutex.Unlock()
+	fake.AddTokenStub = nil
+	fake.addTokenReturns = struct {
+		result1 error
+	}{result1}
 }
 
-func (fake *FakeTokenCache) CleanArgsForCall(i int) context.Context {
-	fake.cleanMutex.RLock()
-	defer fake.cleanMutex.RUnlock()
-	argsForCall := fake.cleanArgsForCall[i]
-	return argsForCall.arg1
+func (fake *FakeTokenCache) AddTokenReturnsOnCall(i int, result1 error) {
+	fake.addTokenMutex.Lock()
+	defer fake.addTokenMutex.Unlock()
+	fake.AddTokenStub = nil
+	if fake.addTokenReturnsOnCall == nil {
+		fake.addTokenReturnsOnCall = make(map[int]struct {
+			result1 error
+		})
+	}
+	fake.addTokenReturnsOnCall[i] = struct {
+		result1 error
+	}{result1}
 }
 
-func (fake *FakeTokenCache) FetchToken(arg1 context.Context, arg2 string) (string, bool) {
+func (fake *FakeTokenCache) FetchToken(arg1 context.Context, arg2 jwt.CredentialKey) (string, bool, error) {
 	fake.fetchTokenMutex.Lock()
 	ret, specificReturn := fake.fetchTokenReturnsOnCall[len(fake.fetchTokenArgsForCall)]
 	fake.fetchTokenArgsForCall = append(fake.fetchTokenArgsForCall, struct {
 		arg1 context.Context
-		arg2 string
+		arg2 jwt.CredentialKey
 	}{arg1, arg2})
+	stub := fake.FetchTokenStub
+	fakeReturns := fake.fetchTokenReturns
 	fake.recordInvocation("FetchToken", []interface{}{arg1, arg2})
 	fake.fetchTokenMutex.Unlock()
-	if fake.FetchTokenStub != nil {
-		return fake.FetchTokenStub(arg1, arg2)
+	if stub != nil {
+		return stub(arg1, arg2)
 	}
 	if specificReturn {
-		return ret.result1, ret.result2
+		return ret.result1, ret.result2, ret.result3
 	}
-	fakeReturns := fake.fetchTokenReturns
-	return fakeReturns.result1, fakeReturns.result2
+	return fakeReturns.result1, fakeReturns.result2, fakeReturns.result3
 }
 
 func (fake *FakeTokenCache) FetchTokenCallCount() int {
@@ -131,30 +134,31 @@ func (fake *FakeTokenCache) FetchTokenCallCount() int {
 	return len(fake.fetchTokenArgsForCall)
 }
 
-func (fake *FakeTokenCache) FetchTokenCalls(stub func(context.Context, string) (string, bool)) {
+func (fake *FakeTokenCache) FetchTokenCalls(stub func(context.Context, jwt.CredentialKey) (string, bool, error)) {
 	fake.fetchTokenMutex.Lock()
 	defer fake.fetchTokenMutex.Unlock()
 	fake.FetchTokenStub = stub
 }
 
-func (fake *FakeTokenCache) FetchTokenArgsForCall(i int) (context.Context, string) {
+func (fake *FakeTokenCache) FetchTokenArgsForCall(i int) (context.Context, jwt.CredentialKey) {
 	fake.fetchTokenMutex.RLock()
 	defer fake.fetchTokenMutex.RUnlock()
 	argsForCall := fake.fetchTokenArgsForCall[i]
 	return argsForCall.arg1, argsForCall.arg2
 }
 
-func (fake *FakeTokenCache) FetchTokenReturns(result1 string, result2 bool) {
+func (fake *FakeTokenCache) FetchTokenReturns(result1 string, result2 bool, result3 error) {
 	fake.fetchTokenMutex.Lock()
 	defer fake.fetchTokenMutex.Unlock()
 	fake.FetchTokenStub = nil
 	fake.fetchTokenReturns = struct {
 		result1 string
 		result2 bool
-	}{result1, result2}
+		result3 error
+	}{result1, result2, result3}
 }
 
-func (fake *FakeTokenCache) FetchTokenReturnsOnCall(i int, result1 string, result2 bool) {
+func (fake *FakeTokenCache) FetchTokenReturnsOnCall(i int, result1 string, result2 bool, result3 error) {
 	fake.fetchTokenMutex.Lock()
 	defer fake.fetchTokenMutex.Unlock()
 	fake.FetchTokenStub = nil
@@ -162,12 +166,14 @@ func (fake *FakeTokenCache) FetchTokenReturnsOnCall(i int, result1 string, resul
 		fake.fetchTokenReturnsOnCall = make(map[int]struct {
 			result1 string
 			result2 bool
+			result3 error
 		})
 	}
 	fake.fetchTokenReturnsOnCall[i] = struct {
 		result1 string
 		result2 bool
-	}{result1, result2}
+		result3 error
+	}{result1, result2, result3}
 }
 
 func (fake *FakeTokenCache) Invocations() map[string][][]interface{} {
@@ -175,8 +181,6 @@ func (fake *FakeTokenCache) Invocations() map[string][][]interface{} {
 	defer fake.invocationsMutex.RUnlock()
 	fake.addTokenMutex.RLock()
 	defer fake.addTokenMutex.RUnlock()
-	fake.cleanMutex.RLock()
-	defer fake.cleanMutex.RUnlock()
 	fake.fetchTokenMutex.RLock()
 	defer fake.fetchTokenMutex.RUnlock()
 	copiedInvocations := map[string][][]interface{}{}
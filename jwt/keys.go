@@ -1,29 +1,24 @@
 package jwt
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"math"
 	"net/http"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
+	"github.com/sirupsen/logrus"
 	jose "gopkg.in/square/go-jose.v2"
 )
 
-type Key struct {
-	ID string `json:"kid"`
-
-	Algorithm string `json:"alg"`
-	KeyType   string `json:"kty"`
-
-	RSAModulus         string `json:"n"`
-	RSAExponent        string `json:"e"`
-	RSAPrivateExponent string `json:"d"`
-}
-
 //go:generate counterfeiter . Keys
 type Keys interface {
-	Fetch(kid string) (jose.JSONWebKey, error)
+	Fetch(ctx context.Context, kid string) (jose.JSONWebKey, error)
 }
 
 //go:generate counterfeiter . Doer
@@ -31,68 +26,430 @@ type Doer interface {
 	Do(*http.Request) (*http.Response, error)
 }
 
-func NewKeyServer(uri string, doer Doer) *KeyServer {
+func NewKeyServer(logger *logrus.Logger, uri string, doer Doer) *KeyServer {
 	return &KeyServer{
-		keysURI:  uri,
-		doer:     doer,
-		cacheTTL: 15 * time.Minute,
+		logger:  logger,
+		keysURI: uri,
+		doer:    doer,
+
+		MinRefreshInterval:  time.Minute,
+		MaxRefreshInterval:  15 * time.Minute,
+		MissRefreshInterval: 5 * time.Second,
+		UnknownKIDTTL:       30 * time.Second,
+		StaleGracePeriod:    time.Hour,
+		Now:                 time.Now,
 	}
 }
 
+// maxUnknownKIDs bounds the negative cache, so that a flood of random key
+// IDs can't grow it without limit.
+const maxUnknownKIDs = 1024
+
+// KeyServer implements Keys by fetching a JWKS over HTTP. It is safe for
+// concurrent use, and at most one refresh is in flight at a time.
+//
+// The keys are kept for as long as the JWKS response's Cache-Control
+// max-age allows, bounded by MinRefreshInterval and MaxRefreshInterval, and
+// revalidated with its ETag. Run refreshes them on that schedule so that
+// requests don't wait on it.
 type KeyServer struct {
+	logger  *logrus.Logger
 	keysURI string
-	keys    map[string]jose.JSONWebKey
 	doer    Doer
 
-	cacheTTL             time.Duration
+	// MinRefreshInterval and MaxRefreshInterval bound how long a JWKS
+	// response is kept, whatever its Cache-Control header says. Responses
+	// without a max-age are kept for MaxRefreshInterval.
+	MinRefreshInterval time.Duration
+	MaxRefreshInterval time.Duration
+	// MissRefreshInterval is the minimum time between refreshes that are
+	// triggered by a token with an unknown key ID, and between background
+	// refreshes while stale keys are being served.
+	MissRefreshInterval time.Duration
+	// UnknownKIDTTL is how long a key ID that wasn't found after a refresh
+	// is remembered, so that it doesn't trigger another one.
+	UnknownKIDTTL time.Duration
+	// StaleGracePeriod is how long after the keys should have been
+	// refreshed they're still trusted if the refreshes keep failing.
+	StaleGracePeriod time.Duration
+
+	Now func() time.Time
+
+	mutex                sync.RWMutex
+	keys                 map[string]jose.JSONWebKey
+	etag                 string
 	lastFetchedTimestamp time.Time
+	expires              time.Time
+	lastRefreshAttempt   time.Time
+	inflight             *refreshCall
+
+	unknownKIDs      map[string]time.Time
+	throttled        int
+	lastThrottledLog time.Time
+
+	stale        bool
+	lastStaleLog time.Time
 }
 
-var ErrUnrecognizedPublicKey = errors.New("unrecognized public key")
+type refreshCall struct {
+	done chan struct{}
+	err  error
+}
 
-func (ks *KeyServer) Fetch(kid string) (jose.JSONWebKey, error) {
-	_, ok := ks.keys[kid]
-	if !ok || time.Since(ks.lastFetchedTimestamp) > ks.cacheTTL {
-		if err := ks.refresh(); err != nil {
-			return jose.JSONWebKey{}, err
-		}
+var (
+	ErrUnrecognizedPublicKey = errors.New("unrecognized public key")
+	// ErrKeysUnavailable is returned when the keys have to be refreshed to
+	// verify a token, and the JWKS can't be fetched
+	ErrKeysUnavailable = errors.New("keys unavailable")
+)
+
+func (ks *KeyServer) Fetch(ctx context.Context, kid string) (jose.JSONWebKey, error) {
+	key, ok, overdue := ks.lookup(kid)
+	if ok && overdue <= 0 {
+		return key, nil
+	}
+
+	// Keys that are past their TTL are almost certainly still valid, so
+	// rather than rejecting every token while the identity provider is
+	// unreachable, they're served for a grace period while refreshes are
+	// retried in the background.
+	usable := overdue <= ks.StaleGracePeriod
+	if ok && usable {
+		ks.revalidate()
+		return key, nil
+	}
+
+	// When the keys are usable but don't include this kid, the token is
+	// either signed with a newly rotated key or is garbage, so we only
+	// refresh if we haven't recently.
+	if usable && !ks.allowMissRefresh(kid) {
+		return jose.JSONWebKey{}, ErrUnrecognizedPublicKey
+	}
+
+	if err := ks.awaitRefresh(ctx); err != nil {
+		return jose.JSONWebKey{}, providerError{ErrKeysUnavailable, err}
 	}
 
-	if key, ok := ks.keys[kid]; ok {
+	if key, ok, _ = ks.lookup(kid); ok {
 		return key, nil
 	}
 
+	ks.rememberUnknown(kid)
 	return jose.JSONWebKey{}, ErrUnrecognizedPublicKey
 }
 
+// Ready fetches the keys if they've never been fetched, so that readiness
+// checks can wait for the gateway to be able to verify tokens.
+func (ks *KeyServer) Ready(ctx context.Context) error {
+	ks.mutex.RLock()
+	loaded := ks.keys != nil
+	ks.mutex.RUnlock()
+
+	if loaded {
+		return nil
+	}
+	return ks.awaitRefresh(ctx)
+}
+
+// lookup returns the key with the given kid, if it's known, and how long
+// ago the keys should have been refreshed, which is negative while they're
+// fresh. Keys that have never been fetched are as overdue as can be.
+func (ks *KeyServer) lookup(kid string) (key jose.JSONWebKey, ok bool, overdue time.Duration) {
+	ks.mutex.RLock()
+	defer ks.mutex.RUnlock()
+
+	if ks.keys == nil {
+		return key, false, math.MaxInt64
+	}
+
+	key, ok = ks.keys[kid]
+	return key, ok, ks.Now().Sub(ks.expires)
+}
+
+// Run keeps the keys fresh by refreshing them in the background whenever
+// they expire, until ctx is done. A failed refresh is retried after
+// MissRefreshInterval.
+func (ks *KeyServer) Run(ctx context.Context) {
+	for {
+		ks.mutex.RLock()
+		wait := ks.expires.Sub(ks.Now())
+		ks.mutex.RUnlock()
+
+		if wait > 0 {
+			timer := time.NewTimer(wait)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return
+			case <-timer.C:
+			}
+		}
+
+		if err := ks.awaitRefresh(ctx); err != nil {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(ks.MissRefreshInterval):
+			}
+		}
+	}
+}
+
+// revalidate starts a refresh in the background while keys past their TTL
+// are served, unless one is already in flight or was attempted recently.
+func (ks *KeyServer) revalidate() {
+	ks.mutex.Lock()
+	defer ks.mutex.Unlock()
+
+	if ks.stale {
+		jwksStaleKeysServed.WithLabelValues(ks.keysURI).Inc()
+	}
+
+	if ks.inflight != nil || ks.Now().Sub(ks.lastRefreshAttempt) < ks.MissRefreshInterval {
+		return
+	}
+	ks.startRefresh()
+}
+
+// allowMissRefresh decides whether an unknown kid may trigger a refresh.
+// Joining a refresh that's already in flight is always allowed.
+func (ks *KeyServer) allowMissRefresh(kid string) bool {
+	ks.mutex.Lock()
+	defer ks.mutex.Unlock()
+
+	if ks.inflight != nil {
+		return true
+	}
+
+	now := ks.Now()
+
+	var reason string
+	if expy, ok := ks.unknownKIDs[kid]; ok && now.Before(expy) {
+		reason = "unknown_kid"
+	} else if now.Sub(ks.lastRefreshAttempt) < ks.MissRefreshInterval {
+		reason = "interval"
+	} else {
+		return true
+	}
+
+	jwksRefreshThrottled.WithLabelValues(reason).Inc()
+
+	// Log at most once per interval, so that a flood of bad tokens doesn't
+	// turn into a flood of log lines.
+	ks.throttled++
+	if now.Sub(ks.lastThrottledLog) >= ks.MissRefreshInterval {
+		ks.logger.Warnf("throttled %v JWKS refreshes for unknown key IDs, most recently `%s` (%s)", ks.throttled, kid, reason)
+		ks.throttled = 0
+		ks.lastThrottledLog = now
+	}
+
+	return false
+}
+
+func (ks *KeyServer) rememberUnknown(kid string) {
+	ks.mutex.Lock()
+	defer ks.mutex.Unlock()
+
+	now := ks.Now()
+	if len(ks.unknownKIDs) >= maxUnknownKIDs {
+		for k, expy := range ks.unknownKIDs {
+			if !now.Before(expy) {
+				delete(ks.unknownKIDs, k)
+			}
+		}
+	}
+	if ks.unknownKIDs == nil || len(ks.unknownKIDs) >= maxUnknownKIDs {
+		ks.unknownKIDs = map[string]time.Time{}
+	}
+
+	ks.unknownKIDs[kid] = now.Add(ks.UnknownKIDTTL)
+}
+
+// awaitRefresh joins the refresh that's already in flight, or starts one if
+// there isn't one, and waits for its result or for ctx to be done.
+func (ks *KeyServer) awaitRefresh(ctx context.Context) error {
+	ks.mutex.Lock()
+	call := ks.inflight
+	if call == nil {
+		call = ks.startRefresh()
+	}
+	ks.mutex.Unlock()
+
+	select {
+	case <-call.done:
+		return call.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// startRefresh fetches the keys in the background. The caller must hold the
+// mutex.
+func (ks *KeyServer) startRefresh() *refreshCall {
+	call := &refreshCall{done: make(chan struct{})}
+	ks.inflight = call
+	ks.lastRefreshAttempt = ks.Now()
+
+	go func() {
+		// The refresh is shared between every waiting request, so it
+		// mustn't be bound to any one of their contexts.
+		call.err = ks.refresh(context.Background())
+
+		ks.mutex.Lock()
+		ks.inflight = nil
+		if call.err != nil && ks.keys != nil {
+			ks.markStale(call.err)
+		}
+		ks.mutex.Unlock()
+		close(call.done)
+	}()
+
+	return call
+}
+
+// markStale records a failed refresh of keys that are past their TTL, and
+// logs it at most once per MissRefreshInterval. The caller must hold the
+// mutex.
+func (ks *KeyServer) markStale(err error) {
+	now := ks.Now()
+	if now.Before(ks.expires) {
+		return
+	}
+
+	if !ks.stale {
+		ks.stale = true
+		jwksStale.WithLabelValues(ks.keysURI).Set(1)
+	}
+
+	if now.Sub(ks.lastStaleLog) < ks.MissRefreshInterval {
+		return
+	}
+	ks.lastStaleLog = now
+
+	age := now.Sub(ks.lastFetchedTimestamp)
+	if now.Sub(ks.expires) > ks.StaleGracePeriod {
+		ks.logger.Errorf("JWKS keys fetched %s ago are past their grace period, so tokens are being rejected: %s", age.Round(time.Second), err.Error())
+	} else {
+		ks.logger.Warnf("serving stale JWKS keys fetched %s ago: %s", age.Round(time.Second), err.Error())
+	}
+}
+
 type keysResponse struct {
 	Keys []jose.JSONWebKey `json:"keys"`
 }
 
-func (ks *KeyServer) refresh() error {
-	now := time.Now()
+func (ks *KeyServer) refresh(ctx context.Context) (err error) {
+	defer observeDuration(jwksRefreshDuration, time.Now(), &err)
+	now := ks.Now()
+
+	req, err := http.NewRequestWithContext(ctx, "GET", ks.keysURI, nil)
+	if err != nil {
+		return fmt.Errorf("failed building JWKs request: %w", err)
+	}
+	req.Header.Set("accept", "application/json")
+
+	ks.mutex.RLock()
+	if ks.keys != nil && ks.etag != "" {
+		req.Header.Set("if-none-match", ks.etag)
+	}
+	ks.mutex.RUnlock()
 
-	req, _ := http.NewRequest("GET", ks.keysURI, nil)
 	resp, err := ks.doer.Do(req)
 	if err != nil {
 		return fmt.Errorf("failed fetching JWKs: %w", err)
 	}
+	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
+	var newKeys map[string]jose.JSONWebKey
+	switch resp.StatusCode {
+	case http.StatusOK:
+		var keys keysResponse
+		if err = json.NewDecoder(resp.Body).Decode(&keys); err != nil {
+			return fmt.Errorf("malformed JWK payload: %w", err)
+		}
+
+		newKeys = make(map[string]jose.JSONWebKey)
+		for _, k := range keys.Keys {
+			newKeys[k.KeyID] = k
+		}
+	case http.StatusNotModified:
+		// The keys we hold are still current
+	default:
 		return fmt.Errorf("failed fetching JWKs: status code %v", resp.StatusCode)
 	}
 
-	var keys keysResponse
-	if err = json.NewDecoder(resp.Body).Decode(&keys); err != nil {
-		return fmt.Errorf("malformed JWK payload: %w", err)
+	ks.mutex.Lock()
+	if newKeys != nil {
+		ks.keys = newKeys
+		ks.etag = resp.Header.Get("etag")
 	}
-
-	newKeys := make(map[string]jose.JSONWebKey)
-	for _, k := range keys.Keys {
-		newKeys[k.KeyID] = k
+	ks.lastFetchedTimestamp = now
+	ks.expires = now.Add(ks.ttl(resp.Header))
+	count := len(ks.keys)
+	if ks.stale {
+		ks.stale = false
+		ks.logger.Infof("refreshed JWKS from `%s`, and stopped serving stale keys", ks.keysURI)
 	}
+	ks.mutex.Unlock()
+
+	jwksStale.WithLabelValues(ks.keysURI).Set(0)
+
+	jwksKeys.WithLabelValues(ks.keysURI).Set(float64(count))
+	jwksLastRefresh.WithLabelValues(ks.keysURI).Set(float64(now.Unix()))
 
-	ks.keys = newKeys
-	ks.lastFetchedTimestamp = now
 	return nil
 }
+
+// ttl is how long a JWKS response may be kept, from its Cache-Control
+// max-age less its Age, bounded by MinRefreshInterval and MaxRefreshInterval.
+func (ks *KeyServer) ttl(h http.Header) time.Duration {
+	ttl := ks.MaxRefreshInterval
+	if maxAge, ok := maxAge(h); ok {
+		ttl = maxAge
+	}
+
+	if ttl < ks.MinRefreshInterval {
+		ttl = ks.MinRefreshInterval
+	}
+	if ttl > ks.MaxRefreshInterval {
+		ttl = ks.MaxRefreshInterval
+	}
+	return ttl
+}
+
+// maxAge reads the freshness lifetime left on a response from its
+// Cache-Control and Age headers. no-cache and no-store leave none.
+func maxAge(h http.Header) (time.Duration, bool) {
+	var (
+		maxAge time.Duration
+		found  bool
+	)
+	for _, directive := range strings.Split(strings.Join(h["Cache-Control"], ","), ",") {
+		name, value := strings.TrimSpace(directive), ""
+		if i := strings.Index(name, "="); i >= 0 {
+			name, value = strings.TrimSpace(name[:i]), strings.Trim(strings.TrimSpace(name[i+1:]), `"`)
+		}
+
+		switch strings.ToLower(name) {
+		case "no-cache", "no-store":
+			return 0, true
+		case "max-age":
+			seconds, err := strconv.ParseInt(value, 10, 64)
+			if err != nil || seconds < 0 {
+				continue
+			}
+			if seconds > math.MaxInt64/int64(time.Second) {
+				seconds = math.MaxInt64 / int64(time.Second)
+			}
+			maxAge, found = time.Duration(seconds)*time.Second, true
+		}
+	}
+	if !found {
+		return 0, false
+	}
+
+	if age, err := strconv.ParseInt(h.Get("age"), 10, 64); err == nil && age > 0 {
+		maxAge -= time.Duration(age) * time.Second
+	}
+	return maxAge, true
+}
@@ -1,33 +1,96 @@
 package jwt_test
 
 import (
+	"context"
 	"errors"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
 	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
 	"github.com/smartatransit/api-gateway/jwt"
 	"github.com/smartatransit/api-gateway/jwt/jwtfakes"
 	jose "gopkg.in/square/go-jose.v2"
 )
 
+const jwksPayload = `{"keys": [{"kid": "requested-kid", "kty": "RSA",   "n"   : "pjdss8ZaDfEH6K6U7GeW2nxDqR4IP049fk1fK0lndimbMMVBdPv_hSpm8T8EtBDxrUdi1OHZfMhUixGaut-3nQ4GG9nM249oxhCtxqqNvEXrmQRGqczyLxuh-fKn9Fg--hS9UpazHpfVAFnB5aCfXoNhPuI8oByyFKMKaOVgHNqP5NBEqabiLftZD3W_lsFCPGuzr4Vp0YS7zS2hDYScC2oOMu4rGU1LcMZf39p3153Cq7bS2Xh6Y-vw5pwzFYZdjQxDn8x8BG3fJ6j8TGLXQsbKH1218_HcUJRvMwdpbUQG5nvA2GXVqLqdwp054Lzk9_B_f1lVrmOKuHjTNHq48w", "e"   : "AQAB"}]}`
+
+func jwksWithKIDs(kids ...string) string {
+	var keys []string
+	for _, kid := range kids {
+		keys = append(keys, strings.Replace(jwksPayload[len(`{"keys": [`):len(jwksPayload)-len(`]}`)], "requested-kid", kid, 1))
+	}
+	return `{"keys": [` + strings.Join(keys, ",") + `]}`
+}
+
+// metricValue reads a counter, gauge, or histogram sample count from the
+// default registry
+func metricValue(name string, labels map[string]string) float64 {
+	families, err := prometheus.DefaultGatherer.Gather()
+	Expect(err).To(BeNil())
+
+	for _, family := range families {
+		if family.GetName() != name {
+			continue
+		}
+	metrics:
+		for _, m := range family.GetMetric() {
+			for _, l := range m.GetLabel() {
+				if labels[l.GetName()] != l.GetValue() {
+					continue metrics
+				}
+			}
+			switch {
+			case m.Counter != nil:
+				return m.GetCounter().GetValue()
+			case m.Histogram != nil:
+				return float64(m.GetHistogram().GetSampleCount())
+			default:
+				return m.GetGauge().GetValue()
+			}
+		}
+	}
+	return 0
+}
+
+// closeTracker is a response body that records whether it was closed
+type closeTracker struct {
+	io.Reader
+	closed bool
+}
+
+func (c *closeTracker) Close() error {
+	c.closed = true
+	return nil
+}
+
 var _ = Describe("KeyServer", func() {
 	var (
-		doer *jwtfakes.FakeDoer
-		ks   *jwt.KeyServer
+		doer   *jwtfakes.FakeDoer
+		logger *logrus.Logger
+		hook   *test.Hook
+		ks     *jwt.KeyServer
 	)
 	BeforeEach(func() {
 		doer = &jwtfakes.FakeDoer{}
+		logger, hook = test.NewNullLogger()
 
 		doer.DoReturns(&http.Response{
 			StatusCode: http.StatusOK,
-			Body:       ioutil.NopCloser(strings.NewReader(`{"keys": [{"kid": "requested-kid", "kty": "RSA",   "n"   : "pjdss8ZaDfEH6K6U7GeW2nxDqR4IP049fk1fK0lndimbMMVBdPv_hSpm8T8EtBDxrUdi1OHZfMhUixGaut-3nQ4GG9nM249oxhCtxqqNvEXrmQRGqczyLxuh-fKn9Fg--hS9UpazHpfVAFnB5aCfXoNhPuI8oByyFKMKaOVgHNqP5NBEqabiLftZD3W_lsFCPGuzr4Vp0YS7zS2hDYScC2oOMu4rGU1LcMZf39p3153Cq7bS2Xh6Y-vw5pwzFYZdjQxDn8x8BG3fJ6j8TGLXQsbKH1218_HcUJRvMwdpbUQG5nvA2GXVqLqdwp054Lzk9_B_f1lVrmOKuHjTNHq48w", "e"   : "AQAB"}]}`)),
+			Body:       ioutil.NopCloser(strings.NewReader(jwksPayload)),
 		}, nil)
 	})
 	JustBeforeEach(func() {
-		ks = jwt.NewKeyServer("uri", doer)
+		ks = jwt.NewKeyServer(logger, "uri", doer)
 	})
 	Describe("Fetch", func() {
 		var (
@@ -35,7 +98,7 @@ var _ = Describe("KeyServer", func() {
 			err error
 		)
 		JustBeforeEach(func() {
-			jwk, err = ks.Fetch("requested-kid")
+			jwk, err = ks.Fetch(context.Background(), "requested-kid")
 		})
 		When("a refresh is required", func() {
 			When("the request fails", func() {
@@ -44,12 +107,14 @@ var _ = Describe("KeyServer", func() {
 				})
 				It("fails", func() {
 					Expect(err).To(MatchError("failed fetching JWKs: request failed"))
+					Expect(errors.Is(err, jwt.ErrKeysUnavailable)).To(BeTrue())
 				})
 			})
 			When("the status code is non-normal", func() {
 				BeforeEach(func() {
 					doer.DoReturns(&http.Response{
 						StatusCode: http.StatusFound,
+						Body:       ioutil.NopCloser(strings.NewReader("")),
 					}, nil)
 				})
 				It("fails", func() {
@@ -84,6 +149,412 @@ var _ = Describe("KeyServer", func() {
 				Expect(err).To(BeNil())
 				Expect(jwk.KeyID).To(Equal("requested-kid"))
 			})
+			It("records the key count and refresh time", func() {
+				labels := map[string]string{"jwks_uri": "uri"}
+				Expect(metricValue("api_gateway_jwks_keys", labels)).To(Equal(1.0))
+				Expect(metricValue("api_gateway_jwks_last_refresh_timestamp_seconds", labels)).To(BeNumerically("~", time.Now().Unix(), 5))
+			})
+		})
+	})
+	Describe("refresh", func() {
+		It("closes the response body", func() {
+			body := &closeTracker{Reader: strings.NewReader(jwksPayload)}
+			doer.DoReturns(&http.Response{StatusCode: http.StatusOK, Body: body}, nil)
+
+			_, err := ks.Fetch(context.Background(), "requested-kid")
+			Expect(err).To(BeNil())
+			Expect(body.closed).To(BeTrue())
+		})
+	})
+	Describe("Ready", func() {
+		It("fetches the keys the first time", func() {
+			Expect(ks.Ready(context.Background())).To(Succeed())
+			Expect(ks.Ready(context.Background())).To(Succeed())
+			Expect(doer.DoCallCount()).To(Equal(1))
+
+			_, err := ks.Fetch(context.Background(), "requested-kid")
+			Expect(err).To(BeNil())
+			Expect(doer.DoCallCount()).To(Equal(1))
+		})
+		It("fails until the keys can be fetched", func() {
+			doer.DoReturns(nil, errors.New("request failed"))
+			Expect(ks.Ready(context.Background())).To(MatchError("failed fetching JWKs: request failed"))
+		})
+	})
+	Describe("concurrent Fetch calls", func() {
+		var (
+			release chan struct{}
+			status  int
+		)
+		BeforeEach(func() {
+			release = make(chan struct{})
+			status = http.StatusOK
+			doer.DoStub = func(*http.Request) (*http.Response, error) {
+				<-release
+				return &http.Response{
+					StatusCode: status,
+					Body:       ioutil.NopCloser(strings.NewReader(jwksPayload)),
+				}, nil
+			}
+		})
+		fetchConcurrently := func(n int) []error {
+			var wg sync.WaitGroup
+			errs := make([]error, n)
+			for i := 0; i < n; i++ {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					var jwk jose.JSONWebKey
+					jwk, errs[i] = ks.Fetch(context.Background(), "requested-kid")
+					if errs[i] == nil && jwk.KeyID != "requested-kid" {
+						errs[i] = errors.New("wrong key")
+					}
+				}(i)
+			}
+
+			Consistently(doer.DoCallCount, 100*time.Millisecond).Should(BeNumerically("<=", 1))
+			close(release)
+			wg.Wait()
+			return errs
+		}
+		It("share a single refresh", func() {
+			for _, err := range fetchConcurrently(100) {
+				Expect(err).To(BeNil())
+			}
+			Expect(doer.DoCallCount()).To(Equal(1))
+
+			_, err := ks.Fetch(context.Background(), "requested-kid")
+			Expect(err).To(BeNil())
+			Expect(doer.DoCallCount()).To(Equal(1))
+		})
+		It("lets a caller give up waiting without abandoning the refresh", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			errs := make(chan error, 1)
+			go func() {
+				_, err := ks.Fetch(ctx, "requested-kid")
+				errs <- err
+			}()
+			Eventually(doer.DoCallCount).Should(Equal(1))
+
+			cancel()
+			Expect(<-errs).To(MatchError(context.Canceled))
+
+			close(release)
+			Eventually(func() error {
+				_, err := ks.Fetch(context.Background(), "requested-kid")
+				return err
+			}).Should(Succeed())
+			Expect(doer.DoCallCount()).To(Equal(1))
+		})
+		When("the shared refresh fails", func() {
+			BeforeEach(func() {
+				status = http.StatusBadGateway
+			})
+			It("reports the failure to every caller, and retries on the next call", func() {
+				for _, err := range fetchConcurrently(100) {
+					Expect(err).To(MatchError("failed fetching JWKs: status code 502"))
+				}
+				Expect(doer.DoCallCount()).To(Equal(1))
+
+				status = http.StatusOK
+				_, err := ks.Fetch(context.Background(), "requested-kid")
+				Expect(err).To(BeNil())
+				Expect(doer.DoCallCount()).To(Equal(2))
+			})
+		})
+	})
+	Describe("stale keys", func() {
+		var (
+			now    time.Time
+			status int
+			labels = map[string]string{"jwks_uri": "uri"}
+			served = func() float64 {
+				return metricValue("api_gateway_jwks_stale_keys_served_total", labels)
+			}
+			fetch = func() error {
+				_, err := ks.Fetch(context.Background(), "requested-kid")
+				return err
+			}
+			servedBefore float64
+		)
+		BeforeEach(func() {
+			now = time.Unix(1600000000, 0)
+			status = http.StatusOK
+			doer.DoStub = func(*http.Request) (*http.Response, error) {
+				return &http.Response{
+					StatusCode: status,
+					Body:       ioutil.NopCloser(strings.NewReader(jwksPayload)),
+				}, nil
+			}
+			servedBefore = served()
+		})
+		JustBeforeEach(func() {
+			ks.Now = func() time.Time { return now }
+			Expect(fetch()).To(Succeed())
+			Expect(doer.DoCallCount()).To(Equal(1))
+		})
+		It("are served while they're revalidated in the background", func() {
+			now = now.Add(16 * time.Minute)
+			Expect(fetch()).To(Succeed())
+			Eventually(func() float64 {
+				return metricValue("api_gateway_jwks_last_refresh_timestamp_seconds", labels)
+			}).Should(Equal(float64(now.Unix())))
+
+			Expect(doer.DoCallCount()).To(Equal(2))
+			Expect(metricValue("api_gateway_jwks_stale", labels)).To(Equal(0.0))
+			Expect(served() - servedBefore).To(Equal(0.0))
+			Expect(hook.Entries).To(BeEmpty())
+		})
+		When("the refreshes fail", func() {
+			JustBeforeEach(func() {
+				status = http.StatusBadGateway
+				now = now.Add(16 * time.Minute)
+				Expect(fetch()).To(Succeed())
+				Eventually(hook.AllEntries).Should(HaveLen(1))
+				Expect(metricValue("api_gateway_jwks_stale", labels)).To(Equal(1.0))
+			})
+			It("keeps serving them, retrying at most once per miss interval", func() {
+				Expect(hook.LastEntry().Level).To(Equal(logrus.WarnLevel))
+				Expect(hook.LastEntry().Message).To(Equal("serving stale JWKS keys fetched 16m0s ago: failed fetching JWKs: status code 502"))
+
+				Expect(fetch()).To(Succeed())
+				Expect(fetch()).To(Succeed())
+				Expect(doer.DoCallCount()).To(Equal(2))
+				Expect(served() - servedBefore).To(Equal(2.0))
+
+				now = now.Add(5 * time.Second)
+				Expect(fetch()).To(Succeed())
+				Eventually(hook.AllEntries).Should(HaveLen(2))
+				Expect(doer.DoCallCount()).To(Equal(3))
+			})
+			It("recovers once a refresh succeeds", func() {
+				status = http.StatusOK
+				now = now.Add(5 * time.Second)
+				Expect(fetch()).To(Succeed())
+				Eventually(func() float64 {
+					return metricValue("api_gateway_jwks_stale", labels)
+				}).Should(Equal(0.0))
+
+				Expect(hook.LastEntry().Level).To(Equal(logrus.InfoLevel))
+				Expect(hook.LastEntry().Message).To(Equal("refreshed JWKS from `uri`, and stopped serving stale keys"))
+			})
+			It("refuses them once the grace period is over", func() {
+				now = now.Add(time.Hour)
+				Expect(fetch()).To(MatchError("failed fetching JWKs: status code 502"))
+				Expect(doer.DoCallCount()).To(Equal(3))
+
+				Expect(hook.LastEntry().Level).To(Equal(logrus.ErrorLevel))
+				Expect(hook.LastEntry().Message).To(Equal("JWKS keys fetched 1h16m0s ago are past their grace period, so tokens are being rejected: failed fetching JWKs: status code 502"))
+			})
+		})
+	})
+	Describe("caching headers", func() {
+		var (
+			now       time.Time
+			responses []*http.Response
+		)
+		BeforeEach(func() {
+			now = time.Unix(1600000000, 0)
+			responses = nil
+			doer.DoStub = func(*http.Request) (*http.Response, error) {
+				resp := *responses[0]
+				resp.Body = ioutil.NopCloser(strings.NewReader(jwksPayload))
+				if len(responses) > 1 {
+					responses = responses[1:]
+				}
+				return &resp, nil
+			}
+		})
+		JustBeforeEach(func() {
+			ks.Now = func() time.Time { return now }
+		})
+		respond := func(status int, header http.Header) *http.Response {
+			if header == nil {
+				header = http.Header{}
+			}
+			return &http.Response{StatusCode: status, Header: header}
+		}
+		// lastRefresh is polled to wait for a background refresh to finish
+		lastRefresh := func() float64 {
+			return metricValue("api_gateway_jwks_last_refresh_timestamp_seconds", map[string]string{"jwks_uri": "uri"})
+		}
+		DescribeTable("keep the keys for the response's max-age, within the floor and ceiling",
+			func(header http.Header, ttl time.Duration) {
+				responses = []*http.Response{respond(http.StatusOK, header)}
+				_, err := ks.Fetch(context.Background(), "requested-kid")
+				Expect(err).To(BeNil())
+
+				now = now.Add(ttl - time.Second)
+				_, err = ks.Fetch(context.Background(), "requested-kid")
+				Expect(err).To(BeNil())
+				Expect(doer.DoCallCount()).To(Equal(1))
+
+				now = now.Add(2 * time.Second)
+				_, err = ks.Fetch(context.Background(), "requested-kid")
+				Expect(err).To(BeNil())
+				Eventually(lastRefresh).Should(Equal(float64(now.Unix())))
+				Expect(doer.DoCallCount()).To(Equal(2))
+			},
+			Entry("max-age", http.Header{"Cache-Control": {"public, max-age=120"}}, 2*time.Minute),
+			Entry("max-age less Age", http.Header{"Cache-Control": {"max-age=300"}, "Age": {"60"}}, 4*time.Minute),
+			Entry("max-age below the floor", http.Header{"Cache-Control": {"max-age=15, stale-while-revalidate=15"}}, time.Minute),
+			Entry("max-age above the ceiling", http.Header{"Cache-Control": {"max-age=86400"}}, 15*time.Minute),
+			Entry("no-cache", http.Header{"Cache-Control": {"no-cache"}}, time.Minute),
+			Entry("no Cache-Control", nil, 15*time.Minute),
+		)
+		It("revalidate the keys with their ETag", func() {
+			responses = []*http.Response{
+				respond(http.StatusOK, http.Header{"Etag": {`"v1"`}, "Cache-Control": {"max-age=60"}}),
+				respond(http.StatusNotModified, http.Header{"Cache-Control": {"max-age=120"}}),
+			}
+			_, err := ks.Fetch(context.Background(), "requested-kid")
+			Expect(err).To(BeNil())
+			Expect(doer.DoArgsForCall(0).Header.Get("If-None-Match")).To(BeEmpty())
+
+			now = now.Add(61 * time.Second)
+			_, err = ks.Fetch(context.Background(), "requested-kid")
+			Expect(err).To(BeNil())
+			Eventually(lastRefresh).Should(Equal(float64(now.Unix())))
+			Expect(doer.DoArgsForCall(1).Header.Get("If-None-Match")).To(Equal(`"v1"`))
+			Expect(metricValue("api_gateway_jwks_keys", map[string]string{"jwks_uri": "uri"})).To(Equal(1.0))
+
+			now = now.Add(119 * time.Second)
+			jwk, err := ks.Fetch(context.Background(), "requested-kid")
+			Expect(err).To(BeNil())
+			Expect(jwk.KeyID).To(Equal("requested-kid"))
+			Expect(doer.DoCallCount()).To(Equal(2))
+		})
+	})
+	Describe("Run", func() {
+		var (
+			ctx    context.Context
+			cancel context.CancelFunc
+			done   chan struct{}
+			status int32
+		)
+		BeforeEach(func() {
+			atomic.StoreInt32(&status, http.StatusOK)
+			doer.DoStub = func(*http.Request) (*http.Response, error) {
+				return &http.Response{
+					StatusCode: int(atomic.LoadInt32(&status)),
+					Body:       ioutil.NopCloser(strings.NewReader(jwksPayload)),
+				}, nil
+			}
+		})
+		JustBeforeEach(func() {
+			ks.MinRefreshInterval = 20 * time.Millisecond
+			ks.MaxRefreshInterval = 20 * time.Millisecond
+			ks.MissRefreshInterval = 20 * time.Millisecond
+
+			ctx, cancel = context.WithCancel(context.Background())
+			done = make(chan struct{})
+			go func() {
+				defer close(done)
+				ks.Run(ctx)
+			}()
+		})
+		AfterEach(func() {
+			cancel()
+			Eventually(done).Should(BeClosed())
+		})
+		It("loads the keys, and refreshes them as they expire", func() {
+			Eventually(func() error { return ks.Ready(context.Background()) }).Should(Succeed())
+			Eventually(doer.DoCallCount).Should(BeNumerically(">=", 3))
+		})
+		It("stops when its context is done", func() {
+			Eventually(doer.DoCallCount).Should(Equal(1))
+			cancel()
+			Eventually(done).Should(BeClosed())
+
+			calls := doer.DoCallCount()
+			Consistently(doer.DoCallCount, 100*time.Millisecond).Should(Equal(calls))
+		})
+		When("the refreshes fail", func() {
+			BeforeEach(func() {
+				atomic.StoreInt32(&status, http.StatusBadGateway)
+			})
+			It("retries them", func() {
+				Eventually(doer.DoCallCount).Should(BeNumerically(">=", 3))
+
+				atomic.StoreInt32(&status, http.StatusOK)
+				Eventually(func() error { return ks.Ready(context.Background()) }).Should(Succeed())
+			})
+		})
+	})
+	Describe("unknown key IDs", func() {
+		var (
+			now      time.Time
+			payload  string
+			throttle = func(reason string) float64 {
+				return metricValue("api_gateway_jwks_refresh_throttled_total", map[string]string{"reason": reason})
+			}
+			intervalBefore float64
+			unknownBefore  float64
+		)
+		BeforeEach(func() {
+			now = time.Unix(1600000000, 0)
+			payload = jwksPayload
+			doer.DoStub = func(*http.Request) (*http.Response, error) {
+				return &http.Response{
+					StatusCode: http.StatusOK,
+					Body:       ioutil.NopCloser(strings.NewReader(payload)),
+				}, nil
+			}
+			intervalBefore = throttle("interval")
+			unknownBefore = throttle("unknown_kid")
+		})
+		JustBeforeEach(func() {
+			ks.Now = func() time.Time { return now }
+
+			_, err := ks.Fetch(context.Background(), "requested-kid")
+			Expect(err).To(BeNil())
+			Expect(doer.DoCallCount()).To(Equal(1))
+		})
+		It("doesn't refresh more often than the miss interval", func() {
+			_, err := ks.Fetch(context.Background(), "random-kid-1")
+			Expect(err).To(MatchError(jwt.ErrUnrecognizedPublicKey))
+			_, err = ks.Fetch(context.Background(), "random-kid-2")
+			Expect(err).To(MatchError(jwt.ErrUnrecognizedPublicKey))
+			Expect(doer.DoCallCount()).To(Equal(1))
+
+			Expect(throttle("interval") - intervalBefore).To(Equal(2.0))
+			Expect(hook.Entries).To(HaveLen(1))
+			Expect(hook.LastEntry().Level).To(Equal(logrus.WarnLevel))
+			Expect(hook.LastEntry().Message).To(Equal("throttled 1 JWKS refreshes for unknown key IDs, most recently `random-kid-1` (interval)"))
+
+			now = now.Add(6 * time.Second)
+			_, err = ks.Fetch(context.Background(), "random-kid-3")
+			Expect(err).To(MatchError(jwt.ErrUnrecognizedPublicKey))
+			Expect(doer.DoCallCount()).To(Equal(2))
+		})
+		It("remembers key IDs that weren't found", func() {
+			now = now.Add(6 * time.Second)
+			_, err := ks.Fetch(context.Background(), "random-kid")
+			Expect(err).To(MatchError(jwt.ErrUnrecognizedPublicKey))
+			Expect(doer.DoCallCount()).To(Equal(2))
+
+			now = now.Add(6 * time.Second)
+			_, err = ks.Fetch(context.Background(), "random-kid")
+			Expect(err).To(MatchError(jwt.ErrUnrecognizedPublicKey))
+			Expect(doer.DoCallCount()).To(Equal(2))
+			Expect(throttle("unknown_kid") - unknownBefore).To(Equal(1.0))
+
+			now = now.Add(31 * time.Second)
+			_, err = ks.Fetch(context.Background(), "random-kid")
+			Expect(err).To(MatchError(jwt.ErrUnrecognizedPublicKey))
+			Expect(doer.DoCallCount()).To(Equal(3))
+		})
+		It("picks up a rotated key within the miss interval", func() {
+			payload = jwksWithKIDs("requested-kid", "rotated-kid")
+
+			_, err := ks.Fetch(context.Background(), "rotated-kid")
+			Expect(err).To(MatchError(jwt.ErrUnrecognizedPublicKey))
+
+			now = now.Add(5 * time.Second)
+			jwk, err := ks.Fetch(context.Background(), "rotated-kid")
+			Expect(err).To(BeNil())
+			Expect(jwk.KeyID).To(Equal("rotated-kid"))
+			Expect(doer.DoCallCount()).To(Equal(2))
 		})
 	})
 })
@@ -0,0 +1,92 @@
+package jwt
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	jwksRefreshThrottled = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "api_gateway",
+		Subsystem: "jwks",
+		Name:      "refresh_throttled_total",
+		Help:      "JWKS refreshes for unknown key IDs that were skipped, by reason.",
+	}, []string{"reason"})
+
+	jwksRefreshDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "api_gateway",
+		Subsystem: "jwks",
+		Name:      "refresh_duration_seconds",
+		Help:      "Time taken to fetch a JWKS, by result.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"result"})
+
+	jwksKeys = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "api_gateway",
+		Subsystem: "jwks",
+		Name:      "keys",
+		Help:      "Number of keys in the last JWKS fetched, by JWKS URI.",
+	}, []string{"jwks_uri"})
+
+	jwksLastRefresh = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "api_gateway",
+		Subsystem: "jwks",
+		Name:      "last_refresh_timestamp_seconds",
+		Help:      "Unix time of the last successful JWKS fetch, by JWKS URI. Subtract it from time() for its age.",
+	}, []string{"jwks_uri"})
+
+	jwksStale = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "api_gateway",
+		Subsystem: "jwks",
+		Name:      "stale",
+		Help:      "1 while keys past their TTL are being served because the JWKS couldn't be refreshed, by JWKS URI.",
+	}, []string{"jwks_uri"})
+
+	jwksStaleKeysServed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "api_gateway",
+		Subsystem: "jwks",
+		Name:      "stale_keys_served_total",
+		Help:      "Keys served past their TTL while the JWKS couldn't be refreshed, by JWKS URI.",
+	}, []string{"jwks_uri"})
+
+	tokenRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "api_gateway",
+		Subsystem: "token_endpoint",
+		Name:      "request_duration_seconds",
+		Help:      "Time taken to obtain a token from the identity provider, by result.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"result"})
+
+	internalTokensMinted = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "api_gateway",
+		Subsystem: "internal_token",
+		Name:      "minted_total",
+		Help:      "Internal tokens minted for upstream services, by result (success or error).",
+	}, []string{"result"})
+
+	tokenCacheRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "api_gateway",
+		Subsystem: "token_cache",
+		Name:      "requests_total",
+		Help:      "Lookups of cached API key tokens, by result (hit, miss, or error).",
+	}, []string{"result"})
+
+	tokenCacheTokens = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: "api_gateway",
+		Subsystem: "token_cache",
+		Name:      "tokens",
+		Help:      "Number of cached API key tokens, as last counted. Replicas sharing a store count the same tokens.",
+	})
+)
+
+// observeDuration records the time since start in h, labelled with whether
+// *err is nil. It's meant to be deferred with a named error result.
+func observeDuration(h *prometheus.HistogramVec, start time.Time, err *error) {
+	result := "success"
+	if *err != nil {
+		result = "error"
+	}
+	h.WithLabelValues(result).Observe(time.Since(start).Seconds())
+}
@@ -0,0 +1,104 @@
+package jwt_test
+
+import (
+	"context"
+	"time"
+
+	jwtgo "github.com/dgrijalva/jwt-go"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/smartatransit/api-gateway/jwt"
+	"github.com/smartatransit/api-gateway/store"
+	"github.com/smartatransit/api-gateway/store/storefakes"
+)
+
+var _ = Describe("TokenMinter", func() {
+	var (
+		keys   *jwt.SigningKeys
+		minter *jwt.TokenMinter
+		now    time.Time
+		auth   jwt.Authorization
+	)
+	BeforeEach(func() {
+		now = time.Now().Truncate(time.Second)
+		keys = newSigningKeys(store.NewMemory("test", 100))
+		minter = jwt.NewMinter(keys, "api-gateway", "upstreams", 5*time.Minute)
+		minter.Now = func() time.Time { return now }
+
+		auth = jwt.Authorization{
+			StandardClaims: jwtgo.StandardClaims{
+				Subject:   "auth0|user",
+				ExpiresAt: now.Add(time.Hour).Unix(),
+			},
+			Session:    "Session-Value",
+			Role:       "Role-Value",
+			IssuerName: "default",
+			Claims:     map[string]interface{}{"azp": "client"},
+		}
+	})
+
+	// verify checks a minted token against the published keys, as an
+	// upstream service would
+	verify := func(token string) jwt.InternalClaims {
+		published, err := keys.PublicKeys(context.Background())
+		Expect(err).To(BeNil())
+
+		var claims jwt.InternalClaims
+		_, err = new(jwtgo.Parser).ParseWithClaims(token, &claims, func(t *jwtgo.Token) (interface{}, error) {
+			Expect(t.Method.Alg()).To(Equal("ES256"))
+			for _, k := range published {
+				if k.KeyID == t.Header["kid"] {
+					return k.Key, nil
+				}
+			}
+			return nil, jwt.ErrUnrecognizedPublicKey
+		})
+		Expect(err).To(BeNil())
+		return claims
+	}
+
+	It("mints a token with the normalized identity", func() {
+		token, err := minter.Mint(context.Background(), auth)
+		Expect(err).To(BeNil())
+
+		Expect(verify(token)).To(Equal(jwt.InternalClaims{
+			StandardClaims: jwtgo.StandardClaims{
+				Issuer:    "api-gateway",
+				Audience:  "upstreams",
+				Subject:   "auth0|user",
+				IssuedAt:  now.Unix(),
+				ExpiresAt: now.Add(5 * time.Minute).Unix(),
+			},
+			Session:    "Session-Value",
+			Role:       "Role-Value",
+			ClientID:   "client",
+			IssuerName: "default",
+		}))
+	})
+	It("doesn't outlive the token it was minted from", func() {
+		auth.ExpiresAt = now.Add(time.Minute).Unix()
+
+		token, err := minter.Mint(context.Background(), auth)
+		Expect(err).To(BeNil())
+		Expect(verify(token).ExpiresAt).To(Equal(auth.ExpiresAt))
+	})
+	It("can still be verified after the keys rotate", func() {
+		token, err := minter.Mint(context.Background(), auth)
+		Expect(err).To(BeNil())
+
+		keys.Now = func() time.Time { return now.Add(time.Hour) }
+		verify(token)
+	})
+	When("the signing key can't be loaded", func() {
+		BeforeEach(func() {
+			fake := &storefakes.FakeStore{}
+			fake.GetReturns("", context.DeadlineExceeded)
+			keys = newSigningKeys(fake)
+			minter = jwt.NewMinter(keys, "api-gateway", "upstreams", 5*time.Minute)
+		})
+		It("fails", func() {
+			_, err := minter.Mint(context.Background(), auth)
+			Expect(err).To(MatchError(context.DeadlineExceeded))
+		})
+	})
+})
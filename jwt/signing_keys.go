@@ -0,0 +1,216 @@
+package jwt
+
+import (
+	"context"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/smartatransit/api-gateway/store"
+	jose "gopkg.in/square/go-jose.v2"
+)
+
+// MinKeySecretLength is the shortest secret that SigningKeys accept
+const MinKeySecretLength = 32
+
+// NewSigningKeys creates a SigningKeys that keeps its keys in s, encrypted
+// with a key derived from secret, and rotates them every `rotation`.
+// Replicas sharing s must share the secret.
+func NewSigningKeys(s store.Store, secret []byte, rotation time.Duration) (*SigningKeys, error) {
+	if len(secret) < MinKeySecretLength {
+		return nil, fmt.Errorf("signing key secret must be at least %v bytes", MinKeySecretLength)
+	}
+
+	encryption := sha256.Sum256(secret)
+	block, err := aes.NewCipher(encryption[:])
+	if err != nil {
+		return nil, fmt.Errorf("failed creating signing key cipher: %w", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("failed creating signing key cipher: %w", err)
+	}
+
+	return &SigningKeys{
+		store:    s,
+		aead:     aead,
+		rotation: rotation,
+		keys:     map[int64]jose.JSONWebKey{},
+		Now:      time.Now,
+	}, nil
+}
+
+// SigningKeys manages the ES256 keys that the gateway signs its internal
+// tokens with. Time is divided into periods of the rotation interval, and
+// each period has its own key, which is generated by whichever replica
+// needs it first and shared with the others through the store.
+//
+// A period's key is published for the period before it, so that verifiers
+// have it before it's used, and the period after it, so that they can still
+// verify the tokens it signed until those expire.
+//
+// Keys are encrypted with AES-GCM in the store, bound to their period, so
+// that reading the store doesn't reveal them, and a key planted in it by
+// anyone without the secret is refused rather than signed with.
+type SigningKeys struct {
+	store    store.Store
+	aead     cipher.AEAD
+	rotation time.Duration
+
+	Now func() time.Time
+
+	// keys caches the keys by period, since they never change
+	mutex sync.Mutex
+	keys  map[int64]jose.JSONWebKey
+}
+
+// Current returns the private key to sign with now
+func (sk *SigningKeys) Current(ctx context.Context) (jose.JSONWebKey, error) {
+	return sk.key(ctx, sk.period())
+}
+
+// PublicKeys returns the public halves of the keys that verifiers should
+// accept now
+func (sk *SigningKeys) PublicKeys(ctx context.Context) ([]jose.JSONWebKey, error) {
+	current := sk.period()
+
+	var keys []jose.JSONWebKey
+	for period := current - 1; period <= current+1; period++ {
+		key, err := sk.key(ctx, period)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key.Public())
+	}
+
+	return keys, nil
+}
+
+// Ready checks that the current key can be loaded from the store
+func (sk *SigningKeys) Ready(ctx context.Context) error {
+	_, err := sk.PublicKeys(ctx)
+	return err
+}
+
+func (sk *SigningKeys) period() int64 {
+	return sk.Now().UnixNano() / int64(sk.rotation)
+}
+
+// key loads the key for a period, generating it if no replica has yet
+func (sk *SigningKeys) key(ctx context.Context, period int64) (jose.JSONWebKey, error) {
+	sk.mutex.Lock()
+	key, ok := sk.keys[period]
+	sk.mutex.Unlock()
+	if ok {
+		return key, nil
+	}
+
+	storeKey := "encrypted-signing-key:" + strconv.FormatInt(period, 10)
+	// Keys are kept until they're no longer published
+	ttl := 3 * sk.rotation
+
+	value, err := sk.store.Get(ctx, storeKey)
+	if errors.Is(err, store.ErrNotFound) {
+		var generated string
+		generated, err = generateSigningKey()
+		if err != nil {
+			return jose.JSONWebKey{}, err
+		}
+		generated, err = sk.seal(storeKey, generated)
+		if err != nil {
+			return jose.JSONWebKey{}, err
+		}
+
+		var ok bool
+		ok, err = sk.store.SetNX(ctx, storeKey, generated, ttl)
+		if err == nil && ok {
+			value = generated
+		} else if err == nil {
+			// Another replica got there first
+			value, err = sk.store.Get(ctx, storeKey)
+		}
+	}
+	if err != nil {
+		return jose.JSONWebKey{}, fmt.Errorf("failed loading signing key: %w", err)
+	}
+
+	plaintext, err := sk.open(storeKey, value)
+	if err != nil {
+		return jose.JSONWebKey{}, err
+	}
+	if err := json.Unmarshal(plaintext, &key); err != nil {
+		return jose.JSONWebKey{}, fmt.Errorf("malformed signing key: %w", err)
+	}
+
+	sk.mutex.Lock()
+	defer sk.mutex.Unlock()
+	sk.keys[period] = key
+	current := sk.period()
+	for p := range sk.keys {
+		if p < current-1 {
+			delete(sk.keys, p)
+		}
+	}
+
+	return key, nil
+}
+
+// seal encrypts a serialized key for storage at storeKey
+func (sk *SigningKeys) seal(storeKey, key string) (string, error) {
+	nonce := make([]byte, sk.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", fmt.Errorf("failed encrypting signing key: %w", err)
+	}
+
+	sealed := sk.aead.Seal(nonce, nonce, []byte(key), []byte(storeKey))
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// open decrypts the key stored at storeKey. It fails for keys that weren't
+// sealed for storeKey with the same secret.
+func (sk *SigningKeys) open(storeKey, value string) ([]byte, error) {
+	sealed, err := base64.StdEncoding.DecodeString(value)
+	if err != nil || len(sealed) < sk.aead.NonceSize() {
+		return nil, errors.New("malformed signing key: not encrypted")
+	}
+
+	nonce, ciphertext := sealed[:sk.aead.NonceSize()], sealed[sk.aead.NonceSize():]
+	plaintext, err := sk.aead.Open(nil, nonce, ciphertext, []byte(storeKey))
+	if err != nil {
+		return nil, fmt.Errorf("malformed signing key: failed decrypting it: %w", err)
+	}
+	return plaintext, nil
+}
+
+// generateSigningKey creates a new ES256 key, identified by its RFC 7638
+// thumbprint, and serializes it as a JWK
+func generateSigningKey() (string, error) {
+	private, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return "", fmt.Errorf("failed generating signing key: %w", err)
+	}
+
+	key := jose.JSONWebKey{Key: private, Algorithm: "ES256", Use: "sig"}
+	thumbprint, err := key.Thumbprint(crypto.SHA256)
+	if err != nil {
+		return "", fmt.Errorf("failed generating signing key: %w", err)
+	}
+	key.KeyID = base64.RawURLEncoding.EncodeToString(thumbprint)
+
+	b, err := json.Marshal(key)
+	if err != nil {
+		return "", fmt.Errorf("failed generating signing key: %w", err)
+	}
+	return string(b), nil
+}
@@ -0,0 +1,181 @@
+package jwt_test
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/smartatransit/api-gateway/jwt"
+	"github.com/smartatransit/api-gateway/store"
+	"github.com/smartatransit/api-gateway/store/storefakes"
+	jose "gopkg.in/square/go-jose.v2"
+)
+
+const signingKeySecret = "0123456789abcdef0123456789abcdef"
+
+// newSigningKeys creates SigningKeys that rotate hourly
+func newSigningKeys(s store.Store) *jwt.SigningKeys {
+	sk, err := jwt.NewSigningKeys(s, []byte(signingKeySecret), time.Hour)
+	Expect(err).To(BeNil())
+	return sk
+}
+
+var _ = Describe("SigningKeys", func() {
+	var (
+		s   store.Store
+		now time.Time
+		sk  *jwt.SigningKeys
+	)
+	BeforeEach(func() {
+		s = store.NewMemory("test", 100)
+		now = time.Unix(1600000000, 0)
+	})
+	JustBeforeEach(func() {
+		sk = newSigningKeys(s)
+		sk.Now = func() time.Time { return now }
+	})
+	kids := func(keys []jose.JSONWebKey) []string {
+		var ids []string
+		for _, k := range keys {
+			ids = append(ids, k.KeyID)
+		}
+		return ids
+	}
+
+	It("signs with a private ES256 key", func() {
+		key, err := sk.Current(context.Background())
+		Expect(err).To(BeNil())
+		Expect(key.IsPublic()).To(BeFalse())
+		Expect(key.Algorithm).To(Equal("ES256"))
+		Expect(key.KeyID).NotTo(BeEmpty())
+	})
+	It("publishes the previous, current, and next keys, without their private halves", func() {
+		current, err := sk.Current(context.Background())
+		Expect(err).To(BeNil())
+
+		published, err := sk.PublicKeys(context.Background())
+		Expect(err).To(BeNil())
+		Expect(published).To(HaveLen(3))
+		Expect(published[1].KeyID).To(Equal(current.KeyID))
+		for _, k := range published {
+			Expect(k.IsPublic()).To(BeTrue())
+		}
+	})
+	It("rotates the keys with overlap", func() {
+		before, err := sk.PublicKeys(context.Background())
+		Expect(err).To(BeNil())
+		current, err := sk.Current(context.Background())
+		Expect(err).To(BeNil())
+
+		now = now.Add(time.Hour)
+		after, err := sk.PublicKeys(context.Background())
+		Expect(err).To(BeNil())
+		Expect(kids(after)[:2]).To(Equal(kids(before)[1:]))
+
+		next, err := sk.Current(context.Background())
+		Expect(err).To(BeNil())
+		Expect(next.KeyID).NotTo(Equal(current.KeyID))
+		Expect(next.KeyID).To(Equal(kids(before)[2]))
+	})
+	It("shares the keys with other replicas through the store", func() {
+		key, err := sk.Current(context.Background())
+		Expect(err).To(BeNil())
+
+		other := newSigningKeys(s)
+		other.Now = func() time.Time { return now }
+		otherKey, err := other.Current(context.Background())
+		Expect(err).To(BeNil())
+		Expect(otherKey.KeyID).To(Equal(key.KeyID))
+	})
+	When("another replica generates the key at the same time", func() {
+		var (
+			fake  *storefakes.FakeStore
+			their jose.JSONWebKey
+		)
+		BeforeEach(func() {
+			shared := store.NewMemory("test", 100)
+			other := newSigningKeys(shared)
+			other.Now = func() time.Time { return now }
+
+			var err error
+			their, err = other.Current(context.Background())
+			Expect(err).To(BeNil())
+
+			// The first lookup misses, as though the other replica hadn't
+			// stored its key yet
+			fake = &storefakes.FakeStore{}
+			fake.GetStub = func(ctx context.Context, key string) (string, error) {
+				if fake.GetCallCount() == 1 {
+					return "", store.ErrNotFound
+				}
+				return shared.Get(ctx, key)
+			}
+			fake.SetNXReturns(false, nil)
+			s = fake
+		})
+		It("uses theirs", func() {
+			key, err := sk.Current(context.Background())
+			Expect(err).To(BeNil())
+			Expect(key.KeyID).To(Equal(their.KeyID))
+			Expect(fake.SetNXCallCount()).To(Equal(1))
+		})
+	})
+	It("doesn't store the private keys in plaintext", func() {
+		key, err := sk.Current(context.Background())
+		Expect(err).To(BeNil())
+
+		stored, err := s.Get(context.Background(), "encrypted-signing-key:444444")
+		Expect(err).To(BeNil())
+		Expect(stored).NotTo(ContainSubstring(key.KeyID))
+		Expect(stored).NotTo(ContainSubstring(`"d"`))
+	})
+	When("a key was planted by someone without the secret", func() {
+		BeforeEach(func() {
+			planter, err := jwt.NewSigningKeys(s, []byte("fedcba9876543210fedcba9876543210"), time.Hour)
+			Expect(err).To(BeNil())
+			planter.Now = func() time.Time { return now }
+			_, err = planter.Current(context.Background())
+			Expect(err).To(BeNil())
+		})
+		It("refuses to sign with it", func() {
+			_, err := sk.Current(context.Background())
+			Expect(err).To(MatchError(ContainSubstring("malformed signing key: failed decrypting it")))
+		})
+	})
+	When("a key is moved to another period", func() {
+		BeforeEach(func() {
+			other := newSigningKeys(s)
+			other.Now = func() time.Time { return now }
+			_, err := other.PublicKeys(context.Background())
+			Expect(err).To(BeNil())
+
+			next, err := s.Get(context.Background(), "encrypted-signing-key:444445")
+			Expect(err).To(BeNil())
+			Expect(s.Set(context.Background(), "encrypted-signing-key:444444", next, time.Hour)).To(Succeed())
+		})
+		It("refuses to sign with it", func() {
+			_, err := sk.Current(context.Background())
+			Expect(err).To(MatchError(ContainSubstring("malformed signing key: failed decrypting it")))
+		})
+	})
+	When("the secret is too short", func() {
+		It("fails", func() {
+			_, err := jwt.NewSigningKeys(s, []byte("secret"), time.Hour)
+			Expect(err).To(MatchError("signing key secret must be at least 32 bytes"))
+		})
+	})
+	When("the store fails", func() {
+		BeforeEach(func() {
+			fake := &storefakes.FakeStore{}
+			fake.GetReturns("", errors.New("connection refused"))
+			s = fake
+		})
+		It("fails", func() {
+			_, err := sk.Current(context.Background())
+			Expect(err).To(MatchError("failed loading signing key: connection refused"))
+			Expect(sk.Ready(context.Background())).NotTo(Succeed())
+		})
+	})
+})
@@ -2,58 +2,89 @@ package jwt
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"time"
-)
 
-// CachedToken represents a cached token
-type CachedToken struct {
-	token string
-	expy  time.Time
-}
+	"github.com/smartatransit/api-gateway/store"
+)
 
-// TokenCache parses a JWT into an Authorization struct
+// TokenCache holds the tokens minted for API keys until they expire
 //go:generate counterfeiter . TokenCache
 type TokenCache interface {
-	FetchToken(ctx context.Context, key string) (string, bool)
-	Clean(ctx context.Context)
-	AddToken(ctx context.Context, key string, token string, expy time.Time)
+	FetchToken(ctx context.Context, key CredentialKey) (string, bool, error)
+	AddToken(ctx context.Context, key CredentialKey, token string, expy time.Time) error
 }
 
-// NewTokenCache creates a new TokenCache
-func NewTokenCache() *TokenAgent {
+// NewTokenCache creates a new TokenCache that keeps tokens in s
+func NewTokenCache(s store.Store) *TokenAgent {
 	return &TokenAgent{
-		tokens: map[string]CachedToken{},
+		store: s,
+		Now:   time.Now,
 	}
 }
 
-// TokenAgent implements TokenCache
+// TokenAgent implements TokenCache. Tokens are indexed by the digest of
+// their CredentialKey, so no secrets are stored, and the store expires
+// them along with the token.
 type TokenAgent struct {
-	tokens map[string]CachedToken
+	store store.Store
+
+	Now func() time.Time
 }
 
 // FetchToken gets a token for the key if there is an unexpired one
-func (a *TokenAgent) FetchToken(ctx context.Context, key string) (string, bool) {
-	a.Clean(ctx)
+func (a *TokenAgent) FetchToken(ctx context.Context, key CredentialKey) (string, bool, error) {
+	token, err := a.store.Get(ctx, tokenCacheKey(key))
+	if errors.Is(err, store.ErrNotFound) {
+		tokenCacheRequests.WithLabelValues("miss").Inc()
+		return "", false, nil
+	}
+	if err != nil {
+		tokenCacheRequests.WithLabelValues("error").Inc()
+		return "", false, fmt.Errorf("failed fetching cached token: %w", err)
+	}
+
+	tokenCacheRequests.WithLabelValues("hit").Inc()
+	return token, true, nil
+}
 
-	if v, ok := a.tokens[key]; ok {
-		return v.token, true
+// AddToken adds a token to cache. Tokens that have already expired aren't
+// cached.
+func (a *TokenAgent) AddToken(ctx context.Context, key CredentialKey, token string, expy time.Time) error {
+	ttl := expy.Sub(a.Now())
+	if ttl <= 0 {
+		return nil
 	}
-	return "", false
+
+	if err := a.store.Set(ctx, tokenCacheKey(key), token, ttl); err != nil {
+		return fmt.Errorf("failed caching token: %w", err)
+	}
+	return nil
 }
 
-// Clean clears out any expired tokens
-func (a *TokenAgent) Clean(ctx context.Context) {
-	for k, v := range a.tokens {
-		if time.Now().After(v.expy) {
-			delete(a.tokens, k)
+// Measure counts the cached tokens every interval until ctx is done, so
+// that the cache's size can be monitored whichever store holds it. Failed
+// counts leave the last one in place.
+func (a *TokenAgent) Measure(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if n, err := a.store.Count(ctx, tokenCacheKeyPrefix); err == nil {
+			tokenCacheTokens.Set(float64(n))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
 		}
 	}
 }
 
-// AddToken adds a token to cache
-func (a *TokenAgent) AddToken(ctx context.Context, key string, token string, expy time.Time) {
-	a.tokens[key] = CachedToken{
-		token: token,
-		expy:  expy,
-	}
+const tokenCacheKeyPrefix = "token:"
+
+func tokenCacheKey(key CredentialKey) string {
+	return tokenCacheKeyPrefix + key.Digest
 }
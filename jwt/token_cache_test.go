@@ -2,56 +2,134 @@ package jwt_test
 
 import (
 	"context"
+	"errors"
 	"time"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
+
 	"github.com/smartatransit/api-gateway/jwt"
+	"github.com/smartatransit/api-gateway/store"
+	"github.com/smartatransit/api-gateway/store/storefakes"
 )
 
+func credentialKey(clientID string) jwt.CredentialKey {
+	return jwt.CredentialKey{ClientID: clientID, Digest: "digest-" + clientID}
+}
+
 var _ = Describe("TokenCache", func() {
 	var (
-		tc *jwt.TokenAgent
+		mem *store.Memory
+		tc  *jwt.TokenAgent
+		now time.Time
+		key = credentialKey("my-api-key")
 	)
 	BeforeEach(func() {
-		tc = jwt.NewTokenCache()
+		now = time.Unix(1600000000, 0)
+		mem = store.NewMemory("test", 3)
+		mem.Now = func() time.Time { return now }
+		tc = jwt.NewTokenCache(mem)
+		tc.Now = func() time.Time { return now }
 	})
 	Describe("FetchToken", func() {
 		var (
 			token string
 			ok    bool
+			err   error
 		)
 		JustBeforeEach(func() {
-			token, ok = tc.FetchToken(context.Background(), "my-api-key")
+			token, ok, err = tc.FetchToken(context.Background(), key)
 		})
 		When("there's no match", func() {
-			It("fails", func() {
+			It("misses", func() {
+				Expect(err).To(BeNil())
+				Expect(ok).To(BeFalse())
+			})
+		})
+		When("the token has expired", func() {
+			BeforeEach(func() {
+				Expect(tc.AddToken(context.Background(), key, "my-token", now.Add(time.Minute))).To(Succeed())
+				now = now.Add(2 * time.Minute)
+			})
+			It("misses", func() {
+				Expect(err).To(BeNil())
 				Expect(ok).To(BeFalse())
 			})
 		})
 		When("there's a match", func() {
 			BeforeEach(func() {
-				tc.AddToken(context.Background(), "my-api-key", "my-token", time.Now().Add(time.Hour))
+				Expect(tc.AddToken(context.Background(), key, "my-token", now.Add(time.Hour))).To(Succeed())
 			})
-			It("fails", func() {
+			It("succeeds", func() {
+				Expect(err).To(BeNil())
 				Expect(ok).To(BeTrue())
 				Expect(token).To(Equal("my-token"))
 			})
 		})
+		It("counts hits and misses", func() {
+			requests := func(result string) float64 {
+				return metricValue("api_gateway_token_cache_requests_total", map[string]string{"result": result})
+			}
+			hits, misses := requests("hit"), requests("miss")
+
+			_, _, _ = tc.FetchToken(context.Background(), credentialKey("other"))
+			_, _, _ = tc.FetchToken(context.Background(), credentialKey("other"))
+			Expect(tc.AddToken(context.Background(), key, "my-token", now.Add(time.Hour))).To(Succeed())
+			_, _, _ = tc.FetchToken(context.Background(), key)
+
+			Expect(requests("hit") - hits).To(Equal(1.0))
+			Expect(requests("miss") - misses).To(Equal(2.0))
+		})
 	})
-	Describe("Clean", func() {
-		It("works", func() {
-			_, ok := tc.FetchToken(context.Background(), "my-api-key")
-			Expect(ok).To(BeFalse())
-			tc.AddToken(context.Background(), "my-api-key", "my-token", time.Now().Add(time.Second))
+	Describe("AddToken", func() {
+		It("stores the token under its digest until it expires", func() {
+			Expect(tc.AddToken(context.Background(), key, "my-token", now.Add(time.Hour))).To(Succeed())
+			Expect(mem.Get(context.Background(), "token:digest-my-api-key")).To(Equal("my-token"))
 
-			_, ok = tc.FetchToken(context.Background(), "my-api-key")
-			Expect(ok).To(BeTrue())
+			now = now.Add(time.Hour + time.Second)
+			_, err := mem.Get(context.Background(), "token:digest-my-api-key")
+			Expect(err).To(Equal(store.ErrNotFound))
+		})
+		It("doesn't store tokens that have already expired", func() {
+			Expect(tc.AddToken(context.Background(), key, "my-token", now.Add(-time.Second))).To(Succeed())
+			Expect(mem.Len()).To(Equal(0))
+		})
+	})
+	Describe("Measure", func() {
+		It("counts the cached tokens", func() {
+			Expect(tc.AddToken(context.Background(), key, "my-token", now.Add(time.Hour))).To(Succeed())
+			Expect(tc.AddToken(context.Background(), credentialKey("other"), "other-token", now.Add(time.Hour))).To(Succeed())
+			Expect(mem.Set(context.Background(), "app-nonce:ios:n0nce", "1", time.Hour)).To(Succeed())
 
-			time.Sleep(2 * time.Second)
+			ctx, cancel := context.WithCancel(context.Background())
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				tc.Measure(ctx, time.Hour)
+			}()
 
-			_, ok = tc.FetchToken(context.Background(), "my-api-key")
+			Eventually(func() float64 {
+				return metricValue("api_gateway_token_cache_tokens", nil)
+			}).Should(Equal(2.0))
+			cancel()
+			Eventually(done).Should(BeClosed())
+		})
+	})
+	When("the store fails", func() {
+		var fake *storefakes.FakeStore
+		BeforeEach(func() {
+			fake = &storefakes.FakeStore{}
+			fake.GetReturns("", errors.New("connection refused"))
+			fake.SetReturns(errors.New("connection refused"))
+			tc = jwt.NewTokenCache(fake)
+		})
+		It("returns the error", func() {
+			_, ok, err := tc.FetchToken(context.Background(), key)
 			Expect(ok).To(BeFalse())
+			Expect(err).To(MatchError("failed fetching cached token: connection refused"))
+
+			err = tc.AddToken(context.Background(), key, "my-token", time.Now().Add(time.Hour))
+			Expect(err).To(MatchError("failed caching token: connection refused"))
 		})
 	})
 })
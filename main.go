@@ -1,25 +1,114 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
 
+	"github.com/go-redis/redis/v7"
 	"github.com/jessevdk/go-flags"
 	"github.com/sirupsen/logrus"
 
+	"github.com/smartatransit/api-gateway/admin"
+	"github.com/smartatransit/api-gateway/appsig"
+	"github.com/smartatransit/api-gateway/clientcert"
 	"github.com/smartatransit/api-gateway/endpoint"
 	"github.com/smartatransit/api-gateway/jwt"
+	"github.com/smartatransit/api-gateway/outbound"
+	"github.com/smartatransit/api-gateway/policy"
+	"github.com/smartatransit/api-gateway/store"
 )
 
 var options struct {
-	Auth0TenantURL      string `long:"auth0-tenant-url" env:"AUTH0_TENANT_URL" required:"true"`
-	ClientID            string `long:"client-id" env:"CLIENT_ID" required:"true"`
-	ClientSecret        string `long:"client-secret" env:"CLIENT_SECRET" required:"true"`
-	Auth0ClientAudience string `long:"auth0-client-audience" env:"AUTH0_CLIENT_AUDIENCE" required:"true"`
+	IssuerURL               string `long:"issuer-url" env:"ISSUER_URL" description:"OpenID Connect issuer whose discovery document locates the JWKS and token endpoint"`
+	JWKSURI                 string `long:"jwks-uri" env:"JWKS_URI" description:"overrides the discovered jwks_uri"`
+	TokenEndpoint           string `long:"token-endpoint" env:"TOKEN_ENDPOINT" description:"overrides the discovered token_endpoint"`
+	TokenEndpointAuthMethod string `long:"token-endpoint-auth-method" env:"TOKEN_ENDPOINT_AUTH_METHOD" choice:"client_secret_basic" choice:"client_secret_post" description:"overrides the discovered token endpoint auth method"`
+	IssuerName              string `long:"issuer-name" env:"ISSUER_NAME" default:"default" description:"name reported in X-Smarta-Auth-Issuer for tokens from --issuer-url"`
+	IssuersFile             string `long:"issuers-file" env:"ISSUERS_FILE" description:"JSON file listing additional trusted issuers"`
+	ClientID                string `long:"client-id" env:"CLIENT_ID" required:"true"`
+	ClientSecret            string `long:"client-secret" env:"CLIENT_SECRET" required:"true"`
+	Audience                string `long:"audience" env:"AUDIENCE" description:"audience to request tokens for, and that tokens from --issuer-url must be for"`
+	AllowAnyAudience        bool   `long:"allow-any-audience" env:"ALLOW_ANY_AUDIENCE" description:"accept tokens for any audience from issuers without one, including tokens the provider issued for other APIs"`
 
-	Port int `long:"port" env:"PORT" default:"8080"`
+	// Deprecated: these are aliases for --issuer-url and --audience from when
+	// the gateway only supported Auth0.
+	Auth0TenantURL      string `long:"auth0-tenant-url" env:"AUTH0_TENANT_URL" description:"deprecated: use --issuer-url"`
+	Auth0ClientAudience string `long:"auth0-client-audience" env:"AUTH0_CLIENT_AUDIENCE" description:"deprecated: use --audience"`
+
+	ClaimNamespaces  []string `long:"claim-namespace" env:"CLAIM_NAMESPACES" env-delim:"," default:"https://jwt.smartatransit.com/" description:"namespace of the custom claims on tokens from --issuer-url; repeat to accept several during a transition"`
+	ClaimHeadersFile string   `long:"claim-headers-file" env:"CLAIM_HEADERS_FILE" description:"JSON file mapping claims on tokens from --issuer-url to headers forwarded upstream"`
+
+	Algorithms      []string      `long:"algorithm" env:"ALGORITHMS" env-delim:"," default:"RS256" default:"ES256" default:"ES384" default:"EdDSA" description:"signing algorithms accepted on tokens (repeatable)"`
+	ClockSkewLeeway time.Duration `long:"clock-skew-leeway" env:"CLOCK_SKEW_LEEWAY" default:"30s" description:"leeway allowed when checking token exp, nbf, and iat"`
+
+	JWKSMinRefreshInterval  time.Duration `long:"jwks-min-refresh-interval" env:"JWKS_MIN_REFRESH_INTERVAL" default:"1m" description:"shortest time JWKS keys are kept, whatever the identity provider's Cache-Control max-age"`
+	JWKSMaxRefreshInterval  time.Duration `long:"jwks-max-refresh-interval" env:"JWKS_MAX_REFRESH_INTERVAL" default:"15m" description:"longest time JWKS keys are kept before they're refreshed, whatever the identity provider's Cache-Control max-age"`
+	JWKSMissRefreshInterval time.Duration `long:"jwks-miss-refresh-interval" env:"JWKS_MISS_REFRESH_INTERVAL" default:"5s" description:"minimum time between JWKS refreshes triggered by unknown key IDs"`
+	JWKSUnknownKIDTTL       time.Duration `long:"jwks-unknown-kid-ttl" env:"JWKS_UNKNOWN_KID_TTL" default:"30s" description:"how long an unknown key ID is remembered before it can trigger another JWKS refresh"`
+	JWKSStaleGracePeriod    time.Duration `long:"jwks-stale-grace-period" env:"JWKS_STALE_GRACE_PERIOD" default:"1h" description:"how long JWKS keys are still trusted after they should have been refreshed, while the identity provider is unreachable"`
+
+	AnonymousTokenExpiryMargin time.Duration `long:"anonymous-token-expiry-margin" env:"ANONYMOUS_TOKEN_EXPIRY_MARGIN" default:"1m" description:"how long before expiry a cached anonymous token stops being handed out, or a quarter of its lifetime if that's shorter"`
+	AnonymousTokenRefreshAhead time.Duration `long:"anonymous-token-refresh-ahead" env:"ANONYMOUS_TOKEN_REFRESH_AHEAD" default:"10m" description:"how long before expiry a replacement anonymous token is fetched in the background, or half its lifetime if that's shorter"`
+
+	CacheKeyPepper string `long:"cache-key-pepper" env:"CACHE_KEY_PEPPER" description:"secret used to hash API key credentials into cache keys; random per process if unset, so it's required for --store=redis"`
+
+	Store                   string        `long:"store" env:"STORE" default:"memory" choice:"memory" choice:"redis" description:"where shared state such as cached API key tokens is kept"`
+	RedisURL                string        `long:"redis-url" env:"REDIS_URL" description:"redis:// URL of the Redis server, for --store=redis"`
+	RedisKeyPrefix          string        `long:"redis-key-prefix" env:"REDIS_KEY_PREFIX" default:"api-gateway:" description:"prefix for every key the gateway stores in Redis"`
+	TokenCacheMaxSize       int           `long:"token-cache-max-size" env:"TOKEN_CACHE_MAX_SIZE" default:"100000" description:"maximum number of keys held by --store=memory; only its evictions are counted in metrics, as Redis evicts keys by its own policy"`
+	TokenCacheSweepInterval time.Duration `long:"token-cache-sweep-interval" env:"TOKEN_CACHE_SWEEP_INTERVAL" default:"1m" description:"how often expired keys are removed by --store=memory"`
+	TokenCacheCountInterval time.Duration `long:"token-cache-count-interval" env:"TOKEN_CACHE_COUNT_INTERVAL" default:"1m" description:"how often cached API key tokens are counted for metrics, which scans the keys of --store=redis"`
+
+	InternalTokenHeader      string        `long:"internal-token-header" env:"INTERNAL_TOKEN_HEADER" description:"header to forward a gateway-minted token for each verified request in, which traefik must be configured to pass upstream; no tokens are minted if unset; requires --store=redis"`
+	InternalTokenIssuer      string        `long:"internal-token-issuer" env:"INTERNAL_TOKEN_ISSUER" default:"api-gateway" description:"iss claim of gateway-minted tokens"`
+	InternalTokenAudience    string        `long:"internal-token-audience" env:"INTERNAL_TOKEN_AUDIENCE" description:"aud claim of gateway-minted tokens, which upstreams should check; required for --internal-token-header"`
+	InternalTokenKeySecret   string        `long:"internal-token-key-secret" env:"INTERNAL_TOKEN_KEY_SECRET" description:"secret of at least 32 bytes that the keys signing gateway-minted tokens are encrypted with in Redis, shared by every replica; required for --internal-token-header"`
+	InternalTokenTTL         time.Duration `long:"internal-token-ttl" env:"INTERNAL_TOKEN_TTL" default:"5m" description:"how long gateway-minted tokens are valid, at most"`
+	InternalTokenKeyRotation time.Duration `long:"internal-token-key-rotation" env:"INTERNAL_TOKEN_KEY_ROTATION" default:"24h" description:"how often the key that signs gateway-minted tokens is replaced; the keys are shared through Redis"`
+
+	ClientCAFile             string `long:"client-ca-file" env:"CLIENT_CA_FILE" description:"PEM bundle of the CAs that client certificates must chain to; client certificates aren't accepted if unset"`
+	ClientCertIdentitiesFile string `long:"client-cert-identities-file" env:"CLIENT_CERT_IDENTITIES_FILE" description:"JSON file mapping client certificate names to roles and sessions"`
+	TrustForwardedClientCert bool   `long:"trust-forwarded-client-cert" env:"TRUST_FORWARDED_CLIENT_CERT" description:"accept client certificates forwarded by traefik in X-Forwarded-Tls-Client-Cert, which traefik must be configured to overwrite"`
+	TLSCertFile              string `long:"tls-cert-file" env:"TLS_CERT_FILE" description:"serve --port over TLS with this certificate, requesting client certificates"`
+	TLSKeyFile               string `long:"tls-key-file" env:"TLS_KEY_FILE" description:"private key for --tls-cert-file"`
+
+	AppsFile            string        `long:"apps-file" env:"APPS_FILE" description:"JSON file listing our app versions and the secrets they sign requests with; request signatures aren't checked if unset. Requires --store=redis, unless --single-replica is set"`
+	AppSignatureWindow  time.Duration `long:"app-signature-window" env:"APP_SIGNATURE_WINDOW" default:"5m" description:"how far a request signature's timestamp may be from the gateway's clock"`
+	SingleReplica       bool          `long:"single-replica" env:"SINGLE_REPLICA" description:"allow --apps-file with --store=memory, which only rejects replayed nonces if a single gateway replica is running"`
+	RequireAppSignature bool          `long:"require-app-signature" env:"REQUIRE_APP_SIGNATURE" description:"only hand anonymous tokens to requests signed by one of the apps in --apps-file"`
+
+	PolicyFile  string `long:"policy-file" env:"POLICY_FILE" description:"JSON file listing which roles may reach which upstream routes, and where else than the Authorization header they accept credentials"`
+	TokenCookie string `long:"token-cookie" env:"TOKEN_COOKIE" description:"cookie that carries bearer tokens on routes that enable the cookie carrier"`
+
+	Port         int `long:"port" env:"PORT" default:"8080"`
+	AdminPort    int `long:"admin-port" env:"ADMIN_PORT" default:"9090" description:"port for /healthz, /readyz, /metrics, and /debug endpoints, which mustn't be exposed through traefik"`
+	InternalPort int `long:"internal-port" env:"INTERNAL_PORT" default:"9091" description:"port for /introspect and /.well-known/jwks.json, which internal services call directly, and which mustn't be exposed through traefik"`
+
+	IntrospectionCallersFile string `long:"introspection-callers-file" env:"INTROSPECTION_CALLERS_FILE" description:"JSON file listing the ids and secrets of internal services allowed to call /introspect"`
+
+	ReadHeaderTimeout time.Duration `long:"read-header-timeout" env:"READ_HEADER_TIMEOUT" default:"5s" description:"how long a client may take to send request headers"`
+	ReadTimeout       time.Duration `long:"read-timeout" env:"READ_TIMEOUT" default:"10s" description:"how long a client may take to send a whole request"`
+	WriteTimeout      time.Duration `long:"write-timeout" env:"WRITE_TIMEOUT" default:"15s" description:"how long a request may take to be handled and answered"`
+	IdleTimeout       time.Duration `long:"idle-timeout" env:"IDLE_TIMEOUT" default:"2m" description:"how long an idle keep-alive connection is held open"`
+	MaxHeaderBytes    int           `long:"max-header-bytes" env:"MAX_HEADER_BYTES" default:"131072" description:"largest request headers accepted, in bytes"`
+
+	ShutdownDelay   time.Duration `long:"shutdown-delay" env:"SHUTDOWN_DELAY" default:"5s" description:"how long /readyz fails after SIGTERM before connections are drained, so the gateway can be taken out of rotation"`
+	ShutdownTimeout time.Duration `long:"shutdown-timeout" env:"SHUTDOWN_TIMEOUT" default:"20s" description:"how long in-flight requests have to finish once connections are drained"`
+
+	OutboundTimeout         time.Duration `long:"outbound-timeout" env:"OUTBOUND_TIMEOUT" default:"5s" description:"how long each attempt at a request to the identity provider may take"`
+	OutboundMaxAttempts     int           `long:"outbound-max-attempts" env:"OUTBOUND_MAX_ATTEMPTS" default:"3" description:"how many times a failing request to the identity provider is tried"`
+	CircuitBreakerThreshold int           `long:"circuit-breaker-threshold" env:"CIRCUIT_BREAKER_THRESHOLD" default:"5" description:"consecutive failed requests to an identity provider endpoint before calls to it fail fast"`
+	CircuitBreakerCooldown  time.Duration `long:"circuit-breaker-cooldown" env:"CIRCUIT_BREAKER_COOLDOWN" default:"30s" description:"how long calls fail fast before a probe request is let through"`
 }
 
 func main() {
@@ -34,30 +123,474 @@ func main() {
 		log.Fatal()
 	}
 
-	keys := jwt.NewKeyServer(
-		options.Auth0TenantURL+"/.well-known/jwks.json",
-		http.DefaultClient,
-	)
-	parser := jwt.NewParser(keys)
-	anonymizer := jwt.NewTokener(
-		options.Auth0TenantURL+"/oauth/token",
-		options.ClientID,
-		options.ClientSecret,
-		options.Auth0ClientAudience,
-		http.DefaultClient,
+	provider, err := discoverProvider()
+	if err != nil {
+		logger.Errorf("failed locating the identity provider: %s", err.Error())
+		log.Fatal()
+	}
+
+	audience := options.Audience
+	if audience == "" {
+		audience = options.Auth0ClientAudience
+	}
+
+	authMethod := provider.PreferredAuthMethod()
+	if options.IssuerURL == "" {
+		// The gateway sent Auth0 the credentials in the body before it
+		// supported other providers, and existing Auth0 clients may only
+		// allow that
+		authMethod = jwt.ClientSecretPost
+	}
+	if options.TokenEndpointAuthMethod != "" {
+		authMethod = jwt.AuthMethod(options.TokenEndpointAuthMethod)
+	}
+
+	claims := jwt.ClaimMapping{Namespaces: options.ClaimNamespaces}
+	if options.ClaimHeadersFile != "" {
+		claims.Headers, err = jwt.LoadHeaderMappings(options.ClaimHeadersFile)
+		if err != nil {
+			logger.Errorf("failed loading claim headers: %s", err.Error())
+			log.Fatal()
+		}
+	}
+
+	issuers := []jwt.IssuerConfig{{
+		Name:     options.IssuerName,
+		Issuer:   provider.Issuer,
+		JWKSURI:  provider.JWKSURI,
+		Audience: audience,
+		Claims:   claims,
+	}}
+	if options.IssuersFile != "" {
+		more, err := jwt.LoadIssuers(options.IssuersFile)
+		if err != nil {
+			logger.Errorf("failed loading issuers: %s", err.Error())
+			log.Fatal()
+		}
+		issuers = append(issuers, more...)
+	}
+
+	// Without an audience, tokens the provider issued for its other APIs
+	// would be accepted
+	if !options.AllowAnyAudience {
+		if err := jwt.RequireAudiences(issuers); err != nil {
+			logger.Errorf("%s; set --audience, or `audience` in --issuers-file, or --allow-any-audience to accept tokens for any audience", err.Error())
+			log.Fatal()
+		}
+	}
+
+	// Background goroutines run until ctx is cancelled during shutdown
+	ctx, cancel := context.WithCancel(context.Background())
+	var background sync.WaitGroup
+
+	readiness := admin.NewReadiness()
+
+	var trusted []jwt.TrustedIssuer
+	for _, cfg := range issuers {
+		iss, err := newTrustedIssuer(ctx, &background, logger, readiness, cfg)
+		if err != nil {
+			logger.Errorf("failed configuring issuer `%s`: %s", cfg.Name, err.Error())
+			log.Fatal()
+		}
+		trusted = append(trusted, iss)
+	}
+
+	parser, err := jwt.NewMultiParser(trusted...)
+	if err != nil {
+		logger.Errorf("failed configuring issuers: %s", err.Error())
+		log.Fatal()
+	}
+	// The anonymous tokener and the API key exchange share one client, so
+	// that they share a circuit breaker for the token endpoint.
+	tokenEndpoint := newClient("token_endpoint")
+	anonymizer := jwt.NewCachingTokener(
+		logger,
+		jwt.NewTokener(
+			provider.TokenEndpoint,
+			options.ClientID,
+			options.ClientSecret,
+			audience,
+			authMethod,
+			tokenEndpoint,
+		),
+		options.AnonymousTokenExpiryMargin,
+		options.AnonymousTokenRefreshAhead,
 	)
 
-	tokenCache := jwt.NewTokenCache()
+	state, err := newStore(ctx, &background)
+	if err != nil {
+		logger.Errorf("failed configuring the store: %s", err.Error())
+		log.Fatal()
+	}
+	tokenCache := jwt.NewTokenCache(state)
+	background.Add(1)
+	go func() {
+		defer background.Done()
+		tokenCache.Measure(ctx, options.TokenCacheCountInterval)
+	}()
+
+	hasher, err := jwt.NewCredentialHasher([]byte(options.CacheKeyPepper))
+	if err != nil {
+		logger.Errorf("failed creating credential hasher: %s", err.Error())
+		log.Fatal()
+	}
+
 	tokenerFactor := jwt.NewTokenerFactory(
-		options.Auth0TenantURL+"/oauth/token",
-		options.Auth0ClientAudience,
-		http.DefaultClient,
+		provider.TokenEndpoint,
+		audience,
+		authMethod,
+		tokenEndpoint,
 	)
 
+	var authorizer policy.Table
+	if options.PolicyFile != "" {
+		authorizer, err = policy.Load(options.PolicyFile)
+		if err != nil {
+			logger.Errorf("failed loading policy: %s", err.Error())
+			log.Fatal()
+		}
+	}
+
+	internalMux := http.NewServeMux()
+
+	var minter jwt.Minter
+	if options.InternalTokenHeader != "" {
+		// Upstreams verify minted tokens against whichever replica's JWKS
+		// they fetched, so every replica has to sign with the same keys
+		if options.Store != "redis" {
+			logger.Error("--internal-token-header requires --store=redis, so that replicas share signing keys")
+			log.Fatal()
+		}
+		if options.InternalTokenTTL > options.InternalTokenKeyRotation {
+			logger.Error("--internal-token-ttl must not be longer than --internal-token-key-rotation")
+			log.Fatal()
+		}
+
+		if options.InternalTokenAudience == "" {
+			logger.Error("--internal-token-audience is required for --internal-token-header")
+			log.Fatal()
+		}
+
+		signingKeys, err := jwt.NewSigningKeys(state, []byte(options.InternalTokenKeySecret), options.InternalTokenKeyRotation)
+		if err != nil {
+			logger.Errorf("invalid --internal-token-key-secret: %s", err.Error())
+			log.Fatal()
+		}
+		minter = jwt.NewMinter(signingKeys, options.InternalTokenIssuer, options.InternalTokenAudience, options.InternalTokenTTL)
+		readiness.Add("signing_keys", signingKeys.Ready)
+		internalMux.Handle("/.well-known/jwks.json", endpoint.NewJWKSEndpoint(logger, signingKeys, options.InternalTokenKeyRotation/2))
+	}
+
+	var certs clientcert.Authenticator
+	if options.ClientCAFile != "" {
+		roots, err := clientcert.LoadRoots(options.ClientCAFile)
+		if err != nil {
+			logger.Errorf("failed loading client CAs: %s", err.Error())
+			log.Fatal()
+		}
+
+		var identities []clientcert.Identity
+		if options.ClientCertIdentitiesFile != "" {
+			identities, err = clientcert.LoadIdentities(options.ClientCertIdentitiesFile)
+			if err != nil {
+				logger.Errorf("failed loading client certificate identities: %s", err.Error())
+				log.Fatal()
+			}
+		}
+
+		certs = clientcert.NewVerifier(roots, identities, options.TrustForwardedClientCert)
+	}
+
+	var apps appsig.Authenticator
+	if options.AppsFile != "" {
+		appVersions, err := appsig.LoadApps(options.AppsFile)
+		if err != nil {
+			logger.Errorf("failed loading apps: %s", err.Error())
+			log.Fatal()
+		}
+
+		// Every replica has to see every nonce to reject replays
+		if options.Store != "redis" && !options.SingleReplica {
+			logger.Error("--apps-file requires --store=redis, or --single-replica if only one gateway replica runs")
+			log.Fatal()
+		}
+
+		verifier, err := appsig.NewVerifier(appVersions, newNonceStore(ctx, &background, state))
+		if err != nil {
+			logger.Errorf("failed loading apps: %s", err.Error())
+			log.Fatal()
+		}
+		verifier.Window = options.AppSignatureWindow
+		apps = verifier
+	} else if options.RequireAppSignature {
+		logger.Error("--apps-file is required for --require-app-signature")
+		log.Fatal()
+	}
+
+	var callers endpoint.Callers
+	if options.IntrospectionCallersFile != "" {
+		callers, err = endpoint.LoadCallers(options.IntrospectionCallersFile)
+		if err != nil {
+			logger.Errorf("failed loading introspection callers: %s", err.Error())
+			log.Fatal()
+		}
+	}
+
 	// NOTE: this service will receive requests forwarded from traefik, which were intended for
 	// other services. The `path` on the request will be the path of the _original_ request, so
-	// we listen for all requests on all paths, and always treat them the same.
-	http.Handle("/", endpoint.NewVerifyEndpoint(logger, parser, anonymizer, tokenCache, tokenerFactor))
+	// we listen for all requests on all paths, and use the X-Forwarded-* headers to decide which
+	// route policy applies. This mux is separate from http.DefaultServeMux, which pprof and expvar
+	// register themselves on.
+	mux := http.NewServeMux()
+	mux.Handle("/", endpoint.NewVerifyEndpoint(logger, parser, anonymizer, tokenCache, tokenerFactor, hasher, authorizer, endpoint.Carriers{Cookie: options.TokenCookie}, certs, apps, options.RequireAppSignature, minter, options.InternalTokenHeader))
+
+	readiness.Add("anonymous_token", func(ctx context.Context) error {
+		_, err := anonymizer.GetToken(ctx)
+		return err
+	})
+
+	internalMux.Handle("/introspect", endpoint.NewIntrospectEndpoint(logger, parser, callers))
+
+	// Verify requests leave a fifth of the write timeout to report
+	// identity provider failures in
+	server := newServer(options.Port, withDeadline(mux, options.WriteTimeout*4/5))
+	if options.TLSCertFile != "" {
+		// Client certificates are verified by the verify endpoint, against
+		// --client-ca-file, rather than during the handshake
+		server.TLSConfig = &tls.Config{
+			ClientAuth: tls.RequestClientCert,
+			MinVersion: tls.VersionTLS12,
+		}
+	}
+	internalServer := newServer(options.InternalPort, internalMux)
+	adminServer := newServer(options.AdminPort, admin.NewHandler(logger, readiness))
+	// Profiles are streamed for as long as they're requested
+	adminServer.WriteTimeout = 0
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, os.Interrupt)
+
+	serveErrs := make(chan error, 3)
+	go func() {
+		if server.TLSConfig != nil {
+			serveErrs <- server.ListenAndServeTLS(options.TLSCertFile, options.TLSKeyFile)
+		} else {
+			serveErrs <- server.ListenAndServe()
+		}
+	}()
+	for _, srv := range []*http.Server{internalServer, adminServer} {
+		go func(srv *http.Server) {
+			serveErrs <- srv.ListenAndServe()
+		}(srv)
+	}
+
+	select {
+	case err := <-serveErrs:
+		logger.Errorf("failed serving: %s", err.Error())
+		log.Fatal()
+	case sig := <-signals:
+		logger.Infof("received %s, draining connections in %s", sig, options.ShutdownDelay)
+	}
+
+	// Fail readiness first, so that no new requests are routed here while
+	// the in-flight ones finish.
+	readiness.Drain()
+	time.Sleep(options.ShutdownDelay)
+
+	shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), options.ShutdownTimeout)
+	defer cancelShutdown()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		logger.Errorf("failed draining connections: %s", err.Error())
+	}
+	if err := internalServer.Shutdown(shutdownCtx); err != nil {
+		logger.Errorf("failed draining internal connections: %s", err.Error())
+	}
+	if err := adminServer.Shutdown(shutdownCtx); err != nil {
+		logger.Errorf("failed draining admin connections: %s", err.Error())
+	}
+
+	cancel()
+	anonymizer.Close()
+	background.Wait()
+	if closer, ok := state.(io.Closer); ok {
+		if err := closer.Close(); err != nil {
+			logger.Errorf("failed closing the store: %s", err.Error())
+		}
+	}
+
+	logger.Info("shut down")
+}
+
+// newServer creates an HTTP server with the configured limits
+func newServer(port int, handler http.Handler) *http.Server {
+	return &http.Server{
+		Addr:              fmt.Sprintf(":%v", port),
+		Handler:           handler,
+		ReadHeaderTimeout: options.ReadHeaderTimeout,
+		ReadTimeout:       options.ReadTimeout,
+		WriteTimeout:      options.WriteTimeout,
+		IdleTimeout:       options.IdleTimeout,
+		MaxHeaderBytes:    options.MaxHeaderBytes,
+	}
+}
+
+// newClient builds a client for calls to the identity provider. Each name
+// has its own circuit breaker, so endpoints that fail independently should
+// use separate clients.
+func newClient(name string) *outbound.Client {
+	client := outbound.NewClient(name, &http.Client{})
+	client.Timeout = options.OutboundTimeout
+	client.MaxAttempts = options.OutboundMaxAttempts
+	client.FailureThreshold = options.CircuitBreakerThreshold
+	client.OpenDuration = options.CircuitBreakerCooldown
+	return client
+}
+
+// withDeadline gives each request's context a deadline, so that calls to
+// the identity provider give up before the write timeout cuts the response
+// off. A timeout of zero or less leaves requests without one.
+func withDeadline(handler http.Handler, timeout time.Duration) http.Handler {
+	if timeout <= 0 {
+		return handler
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+		handler.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// discoverProvider reads the issuer's discovery document, unless both the
+// JWKS URI and token endpoint have been configured explicitly.
+func discoverProvider() (jwt.ProviderMetadata, error) {
+	issuerURL := options.IssuerURL
+	if issuerURL == "" {
+		issuerURL = options.Auth0TenantURL
+	}
+
+	if issuerURL == "" {
+		return jwt.ProviderMetadata{}, errors.New("--issuer-url is required")
+	}
+
+	if options.JWKSURI != "" && options.TokenEndpoint != "" {
+		return jwt.ProviderMetadata{
+			Issuer:        issuerURL,
+			JWKSURI:       options.JWKSURI,
+			TokenEndpoint: options.TokenEndpoint,
+		}, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	md, err := jwt.Discover(ctx, issuerURL, newClient("discovery"))
+	if err != nil {
+		return jwt.ProviderMetadata{}, err
+	}
+
+	if options.JWKSURI != "" {
+		md.JWKSURI = options.JWKSURI
+	}
+	if options.TokenEndpoint != "" {
+		md.TokenEndpoint = options.TokenEndpoint
+	}
+
+	if md.TokenEndpoint == "" {
+		return jwt.ProviderMetadata{}, errors.New("the provider doesn't advertise a token_endpoint, so --token-endpoint is required")
+	}
+
+	return md, nil
+}
+
+// newNonceStore creates the store that remembers used nonces. Redis only
+// expires them, but the in-memory store evicts keys when it's full, which
+// would let a nonce be reused before its signature expires, so nonces get
+// an unbounded one of their own. Nonces are only stored for valid
+// signatures, and only for twice --app-signature-window.
+func newNonceStore(ctx context.Context, background *sync.WaitGroup, state store.Store) store.Store {
+	if options.Store == "redis" {
+		return state
+	}
+
+	return newMemory(ctx, background, "nonces", 0)
+}
+
+// newStore creates the store for shared state. The in-memory store is swept
+// for expired keys in the background until ctx is cancelled.
+func newStore(ctx context.Context, background *sync.WaitGroup) (store.Store, error) {
+	if options.Store != "redis" {
+		return newMemory(ctx, background, "token_cache", options.TokenCacheMaxSize), nil
+	}
+
+	if options.RedisURL == "" {
+		return nil, errors.New("--redis-url is required for --store=redis")
+	}
+	// Every replica has to hash credentials the same way to share tokens
+	if options.CacheKeyPepper == "" {
+		return nil, errors.New("--cache-key-pepper is required for --store=redis")
+	}
+
+	redisOptions, err := redis.ParseURL(options.RedisURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid --redis-url: %w", err)
+	}
+
+	client := redis.NewClient(redisOptions)
+
+	pingCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	if err := client.WithContext(pingCtx).Ping().Err(); err != nil {
+		return nil, fmt.Errorf("failed connecting to Redis: %w", err)
+	}
+
+	return store.NewRedis(client, options.RedisKeyPrefix), nil
+}
+
+// newTrustedIssuer builds the parser for an issuer, discovering its JWKS
+// URI if it isn't configured, and refreshes its keys in the background
+// until ctx is cancelled.
+func newTrustedIssuer(ctx context.Context, background *sync.WaitGroup, logger *logrus.Logger, readiness *admin.Readiness, cfg jwt.IssuerConfig) (jwt.TrustedIssuer, error) {
+	if cfg.JWKSURI == "" {
+		discoveryCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+		defer cancel()
+
+		md, err := jwt.Discover(discoveryCtx, cfg.Issuer, newClient("discovery:"+cfg.Name))
+		if err != nil {
+			return jwt.TrustedIssuer{}, err
+		}
+		cfg.JWKSURI = md.JWKSURI
+	}
+
+	keys := jwt.NewKeyServer(logger, cfg.JWKSURI, newClient("jwks:"+cfg.Name))
+	keys.MissRefreshInterval = options.JWKSMissRefreshInterval
+	keys.UnknownKIDTTL = options.JWKSUnknownKIDTTL
+	keys.StaleGracePeriod = options.JWKSStaleGracePeriod
+	keys.MinRefreshInterval = options.JWKSMinRefreshInterval
+	keys.MaxRefreshInterval = options.JWKSMaxRefreshInterval
+	readiness.Add("jwks:"+cfg.Name, keys.Ready)
+
+	background.Add(1)
+	go func() {
+		defer background.Done()
+		keys.Run(ctx)
+	}()
+
+	return jwt.TrustedIssuer{
+		Name:   cfg.Name,
+		Issuer: cfg.Issuer,
+		Parser: jwt.NewParser(keys, cfg.Validation(options.Algorithms, options.ClockSkewLeeway), cfg.Claims),
+	}, nil
+}
 
-	log.Fatal(http.ListenAndServe(fmt.Sprintf(":%v", options.Port), nil))
+// newMemory creates an in-memory store holding at most maxSize keys, whose
+// metrics are labelled with name, and sweeps it for expired keys in the
+// background until ctx is cancelled
+func newMemory(ctx context.Context, background *sync.WaitGroup, name string, maxSize int) *store.Memory {
+	mem := store.NewMemory(name, maxSize)
+	background.Add(1)
+	go func() {
+		defer background.Done()
+		mem.Sweep(ctx, options.TokenCacheSweepInterval)
+	}()
+	return mem
 }
@@ -0,0 +1,285 @@
+package outbound
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is returned without making a request while the circuit
+// breaker is open
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+// Doer makes HTTP requests
+type Doer interface {
+	Do(*http.Request) (*http.Response, error)
+}
+
+// NewClient wraps doer with per-call timeouts, retries, and a circuit
+// breaker. The name identifies the upstream in errors and metrics.
+func NewClient(name string, doer Doer) *Client {
+	return &Client{
+		name: name,
+		doer: doer,
+
+		Timeout:          5 * time.Second,
+		MaxAttempts:      3,
+		BaseBackoff:      100 * time.Millisecond,
+		MaxBackoff:       2 * time.Second,
+		MaxRetryAfter:    10 * time.Second,
+		FailureThreshold: 5,
+		OpenDuration:     30 * time.Second,
+		Now:              time.Now,
+
+		rnd: rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+// Client implements Doer. It is safe for concurrent use.
+type Client struct {
+	name string
+	doer Doer
+
+	// Timeout bounds each attempt, including reading the response body
+	Timeout time.Duration
+	// MaxAttempts is how many times a request is tried before giving up
+	MaxAttempts int
+	// Retries wait a random time up to BaseBackoff, doubling with each
+	// attempt up to MaxBackoff, unless the upstream sends Retry-After.
+	BaseBackoff time.Duration
+	MaxBackoff  time.Duration
+	// MaxRetryAfter is the longest Retry-After that will be waited for;
+	// longer ones fail the request instead.
+	MaxRetryAfter time.Duration
+	// FailureThreshold consecutive failed requests open the circuit
+	// breaker for OpenDuration, after which a single request is let through
+	// to probe whether the upstream has recovered.
+	FailureThreshold int
+	OpenDuration     time.Duration
+
+	Now func() time.Time
+
+	mutex sync.Mutex
+	// rnd jitters backoffs. It's seeded per client, so that replicas don't
+	// retry in lockstep.
+	rnd       *rand.Rand
+	failures  int
+	openUntil time.Time
+	probing   bool
+}
+
+// Do sends the request, retrying transient failures: network errors, 429s,
+// and 5xx responses. Requests with a body can only be retried if the body
+// can be rewound with req.GetBody. Retries stop once they'd run past the
+// request context's deadline.
+func (c *Client) Do(req *http.Request) (*http.Response, error) {
+	if err := c.allow(); err != nil {
+		return nil, err
+	}
+
+	var (
+		resp *http.Response
+		err  error
+	)
+	for attempt := 1; ; attempt++ {
+		resp, err = c.attempt(req)
+		if !retryable(resp, err) || req.Context().Err() != nil {
+			break
+		}
+
+		if attempt >= c.MaxAttempts || (req.Body != nil && req.GetBody == nil) {
+			break
+		}
+
+		wait, ok := c.backoff(attempt, resp)
+		if !ok {
+			break
+		}
+		// A retry that can't start before the caller's deadline would only
+		// keep the caller from reporting the failure in time
+		if deadline, ok := req.Context().Deadline(); ok && wait >= time.Until(deadline) {
+			break
+		}
+
+		status := "error"
+		if resp != nil {
+			status = strconv.Itoa(resp.StatusCode)
+			drain(resp.Body)
+		}
+		outboundRetries.WithLabelValues(c.name, status).Inc()
+
+		if err := sleep(req.Context(), wait); err != nil {
+			c.release()
+			return nil, fmt.Errorf("%s: %w", c.name, err)
+		}
+	}
+
+	// A request abandoned by the caller says nothing about the upstream
+	if req.Context().Err() != nil {
+		c.release()
+	} else {
+		c.record(retryable(resp, err))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", c.name, err)
+	}
+	return resp, nil
+}
+
+func (c *Client) attempt(req *http.Request) (*http.Response, error) {
+	ctx, cancel := context.WithTimeout(req.Context(), c.Timeout)
+
+	r := req.Clone(ctx)
+	if req.Body != nil && req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			cancel()
+			return nil, err
+		}
+		r.Body = body
+	}
+
+	resp, err := c.doer.Do(r)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	// The timeout has to cover reading the body too, so it's only released
+	// once the caller closes it.
+	resp.Body = &cancelOnClose{ReadCloser: resp.Body, cancel: cancel}
+	return resp, nil
+}
+
+func retryable(resp *http.Response, err error) bool {
+	if err != nil {
+		return true
+	}
+	return resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= http.StatusInternalServerError
+}
+
+// backoff decides how long to wait before the next attempt, and whether to
+// make one at all.
+func (c *Client) backoff(attempt int, resp *http.Response) (time.Duration, bool) {
+	if resp != nil {
+		if wait, ok := retryAfter(resp.Header.Get("Retry-After"), c.Now()); ok {
+			return wait, wait <= c.MaxRetryAfter
+		}
+	}
+
+	ceiling := c.BaseBackoff << uint(attempt-1)
+	if ceiling > c.MaxBackoff || ceiling <= 0 {
+		ceiling = c.MaxBackoff
+	}
+	if ceiling <= 0 {
+		return 0, true
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return time.Duration(c.rnd.Int63n(int64(ceiling))), true
+}
+
+// retryAfter parses a Retry-After header, which is either a number of
+// seconds or an HTTP date.
+func retryAfter(header string, now time.Time) (time.Duration, bool) {
+	if header == "" {
+		return 0, false
+	}
+
+	if seconds, err := strconv.Atoi(header); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+
+	if at, err := http.ParseTime(header); err == nil {
+		if wait := at.Sub(now); wait > 0 {
+			return wait, true
+		}
+		return 0, true
+	}
+
+	return 0, false
+}
+
+// allow fails fast while the circuit breaker is open. Once it's been open
+// for OpenDuration, one request at a time is let through as a probe.
+func (c *Client) allow() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.failures < c.FailureThreshold {
+		return nil
+	}
+
+	if c.Now().Before(c.openUntil) || c.probing {
+		outboundRejected.WithLabelValues(c.name).Inc()
+		return fmt.Errorf("%s: %w", c.name, ErrCircuitOpen)
+	}
+
+	c.probing = true
+	return nil
+}
+
+// record updates the circuit breaker with the outcome of a request
+func (c *Client) record(failed bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.probing = false
+	if !failed {
+		c.failures = 0
+		circuitOpen.WithLabelValues(c.name).Set(0)
+		return
+	}
+
+	c.failures++
+	if c.failures >= c.FailureThreshold {
+		c.openUntil = c.Now().Add(c.OpenDuration)
+		circuitOpen.WithLabelValues(c.name).Set(1)
+	}
+}
+
+// release ends a probe without recording its outcome
+func (c *Client) release() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.probing = false
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// drain reads and closes a response body that won't be used, so that the
+// connection can be reused.
+func drain(body io.ReadCloser) {
+	_, _ = io.Copy(ioutil.Discard, io.LimitReader(body, 64<<10))
+	body.Close()
+}
+
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelOnClose) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
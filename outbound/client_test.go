@@ -0,0 +1,249 @@
+package outbound_test
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/smartatransit/api-gateway/outbound"
+)
+
+var _ = Describe("Client", func() {
+	var (
+		server *httptest.Server
+		client *outbound.Client
+		now    time.Time
+
+		mutex     sync.Mutex
+		responses []func(w http.ResponseWriter)
+		bodies    []string
+	)
+	BeforeEach(func() {
+		now = time.Unix(1600000000, 0)
+		responses, bodies = nil, nil
+
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, _ := ioutil.ReadAll(r.Body)
+
+			mutex.Lock()
+			bodies = append(bodies, string(body))
+			respond := func(w http.ResponseWriter) { _, _ = w.Write([]byte("ok")) }
+			if len(responses) > 0 {
+				respond, responses = responses[0], responses[1:]
+			}
+			mutex.Unlock()
+
+			respond(w)
+		}))
+
+		client = outbound.NewClient("idp", server.Client())
+		client.BaseBackoff = time.Millisecond
+		client.MaxBackoff = 5 * time.Millisecond
+		client.Now = func() time.Time { return now }
+	})
+	AfterEach(func() {
+		server.Close()
+	})
+
+	status := func(code int) func(w http.ResponseWriter) {
+		return func(w http.ResponseWriter) { w.WriteHeader(code) }
+	}
+	requests := func() int {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return len(bodies)
+	}
+	post := func(ctx context.Context) (*http.Response, error) {
+		req, _ := http.NewRequestWithContext(ctx, "POST", server.URL, strings.NewReader("grant_type=client_credentials"))
+		return client.Do(req)
+	}
+
+	It("passes successful responses through", func() {
+		resp, err := post(context.Background())
+		Expect(err).To(BeNil())
+		defer resp.Body.Close()
+
+		body, err := ioutil.ReadAll(resp.Body)
+		Expect(err).To(BeNil())
+		Expect(string(body)).To(Equal("ok"))
+	})
+	It("retries 5xx responses, resending the body", func() {
+		responses = append(responses, status(http.StatusBadGateway), status(http.StatusServiceUnavailable))
+
+		resp, err := post(context.Background())
+		Expect(err).To(BeNil())
+		Expect(resp.StatusCode).To(Equal(http.StatusOK))
+		Expect(bodies).To(Equal([]string{
+			"grant_type=client_credentials",
+			"grant_type=client_credentials",
+			"grant_type=client_credentials",
+		}))
+	})
+	It("gives up after MaxAttempts", func() {
+		responses = append(responses, status(500), status(500), status(500), status(500))
+
+		resp, err := post(context.Background())
+		Expect(err).To(BeNil())
+		Expect(resp.StatusCode).To(Equal(http.StatusInternalServerError))
+		Expect(requests()).To(Equal(3))
+	})
+	It("doesn't retry client errors", func() {
+		responses = append(responses, status(http.StatusUnauthorized))
+
+		resp, err := post(context.Background())
+		Expect(err).To(BeNil())
+		Expect(resp.StatusCode).To(Equal(http.StatusUnauthorized))
+		Expect(requests()).To(Equal(1))
+	})
+	It("retries network errors", func() {
+		server.Close()
+		_, err := post(context.Background())
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(HavePrefix("idp: "))
+	})
+	Describe("Retry-After", func() {
+		It("waits as long as a 429 asks", func() {
+			responses = append(responses, func(w http.ResponseWriter) {
+				w.Header().Set("Retry-After", "1")
+				w.WriteHeader(http.StatusTooManyRequests)
+			})
+
+			start := time.Now()
+			resp, err := post(context.Background())
+			Expect(err).To(BeNil())
+			Expect(resp.StatusCode).To(Equal(http.StatusOK))
+			Expect(time.Since(start)).To(BeNumerically(">=", time.Second))
+		})
+		It("accepts an HTTP date", func() {
+			responses = append(responses, func(w http.ResponseWriter) {
+				w.Header().Set("Retry-After", now.Add(-time.Second).UTC().Format(http.TimeFormat))
+				w.WriteHeader(http.StatusTooManyRequests)
+			})
+
+			resp, err := post(context.Background())
+			Expect(err).To(BeNil())
+			Expect(resp.StatusCode).To(Equal(http.StatusOK))
+		})
+		It("doesn't wait past the caller's deadline", func() {
+			responses = append(responses, func(w http.ResponseWriter) {
+				w.Header().Set("Retry-After", "1")
+				w.WriteHeader(http.StatusTooManyRequests)
+			})
+
+			ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+			defer cancel()
+
+			start := time.Now()
+			resp, err := post(ctx)
+			Expect(err).To(BeNil())
+			Expect(resp.StatusCode).To(Equal(http.StatusTooManyRequests))
+			Expect(requests()).To(Equal(1))
+			Expect(time.Since(start)).To(BeNumerically("<", 500*time.Millisecond))
+		})
+		It("doesn't wait longer than MaxRetryAfter", func() {
+			responses = append(responses, func(w http.ResponseWriter) {
+				w.Header().Set("Retry-After", "3600")
+				w.WriteHeader(http.StatusTooManyRequests)
+			})
+
+			resp, err := post(context.Background())
+			Expect(err).To(BeNil())
+			Expect(resp.StatusCode).To(Equal(http.StatusTooManyRequests))
+			Expect(requests()).To(Equal(1))
+		})
+	})
+	Describe("timeouts and cancellation", func() {
+		var release chan struct{}
+		BeforeEach(func() {
+			release = make(chan struct{})
+			responses = append(responses, func(w http.ResponseWriter) { <-release })
+		})
+		AfterEach(func() {
+			close(release)
+		})
+		It("times out each attempt", func() {
+			client.Timeout = 20 * time.Millisecond
+			client.MaxAttempts = 1
+
+			_, err := post(context.Background())
+			Expect(errors.Is(err, context.DeadlineExceeded)).To(BeTrue())
+		})
+		It("stops when the caller's context is cancelled", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			go func() {
+				time.Sleep(20 * time.Millisecond)
+				cancel()
+			}()
+
+			_, err := post(ctx)
+			Expect(errors.Is(err, context.Canceled)).To(BeTrue())
+			Expect(requests()).To(Equal(1))
+		})
+	})
+	Describe("circuit breaker", func() {
+		BeforeEach(func() {
+			client.MaxAttempts = 1
+			client.FailureThreshold = 2
+			client.OpenDuration = time.Minute
+			for i := 0; i < 10; i++ {
+				responses = append(responses, status(http.StatusBadGateway))
+			}
+		})
+		It("fails fast once open, and probes once it's been open long enough", func() {
+			for i := 0; i < 2; i++ {
+				resp, err := post(context.Background())
+				Expect(err).To(BeNil())
+				resp.Body.Close()
+			}
+
+			_, err := post(context.Background())
+			Expect(errors.Is(err, outbound.ErrCircuitOpen)).To(BeTrue())
+			Expect(err).To(MatchError("idp: circuit breaker is open"))
+			Expect(requests()).To(Equal(2))
+
+			now = now.Add(2 * time.Minute)
+			mutex.Lock()
+			responses = nil
+			mutex.Unlock()
+
+			resp, err := post(context.Background())
+			Expect(err).To(BeNil())
+			Expect(resp.StatusCode).To(Equal(http.StatusOK))
+
+			resp, err = post(context.Background())
+			Expect(err).To(BeNil())
+			Expect(resp.StatusCode).To(Equal(http.StatusOK))
+		})
+		It("reopens if the probe fails", func() {
+			for i := 0; i < 2; i++ {
+				_, _ = post(context.Background())
+			}
+
+			now = now.Add(2 * time.Minute)
+			resp, err := post(context.Background())
+			Expect(err).To(BeNil())
+			Expect(resp.StatusCode).To(Equal(http.StatusBadGateway))
+
+			_, err = post(context.Background())
+			Expect(errors.Is(err, outbound.ErrCircuitOpen)).To(BeTrue())
+		})
+		It("resets after a success", func() {
+			mutex.Lock()
+			responses = []func(http.ResponseWriter){status(502), status(200), status(502)}
+			mutex.Unlock()
+
+			for i := 0; i < 3; i++ {
+				_, err := post(context.Background())
+				Expect(err).To(BeNil())
+			}
+		})
+	})
+})
@@ -0,0 +1,29 @@
+package outbound
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	outboundRetries = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "api_gateway",
+		Subsystem: "outbound",
+		Name:      "retries_total",
+		Help:      "Retried requests to upstreams such as the identity provider, by upstream and the status code (or error) that was retried.",
+	}, []string{"upstream", "status"})
+
+	outboundRejected = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "api_gateway",
+		Subsystem: "outbound",
+		Name:      "circuit_rejected_total",
+		Help:      "Requests failed fast because the upstream's circuit breaker was open, by upstream.",
+	}, []string{"upstream"})
+
+	circuitOpen = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "api_gateway",
+		Subsystem: "outbound",
+		Name:      "circuit_open",
+		Help:      "Whether the upstream's circuit breaker is open (1) or closed (0), by upstream.",
+	}, []string{"upstream"})
+)
@@ -0,0 +1,152 @@
+package policy
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// AnyRole can be listed in a route's roles to allow every authenticated role
+const AnyRole = "*"
+
+// The carriers that routes may accept credentials in, besides the
+// Authorization header
+const (
+	// CarrierAPIKey is an X-API-Key header holding `id|secret` credentials
+	CarrierAPIKey = "api_key"
+	// CarrierCookie is a cookie holding a bearer token
+	CarrierCookie = "cookie"
+	// CarrierQuery is an `access_token` query parameter holding a bearer
+	// token
+	CarrierQuery = "query"
+)
+
+// Authorizer decides whether a role may reach an upstream request, and
+// where the request's credentials may be carried
+//go:generate counterfeiter . Authorizer
+type Authorizer interface {
+	Authorize(req Request, role string) bool
+	Carriers(req Request) []string
+}
+
+// Route describes the roles that may reach a set of upstream requests.
+// Empty Methods or Host match any method or host. Path is either an exact
+// path, or a prefix ending in `/*` which matches that path and everything
+// beneath it; either is matched case-insensitively. Carriers lists where else than the Authorization header the
+// route accepts credentials.
+type Route struct {
+	Methods  []string `json:"methods"`
+	Host     string   `json:"host"`
+	Path     string   `json:"path"`
+	Roles    []string `json:"roles"`
+	Carriers []string `json:"carriers"`
+}
+
+// Table is an ordered list of routes. The first route that matches a
+// request decides whether it is allowed. Requests that match no route are
+// allowed unless DenyUnmatched is set.
+type Table struct {
+	Routes        []Route `json:"routes"`
+	DenyUnmatched bool    `json:"deny_unmatched"`
+}
+
+// Load reads a JSON policy table from disk
+func Load(filename string) (Table, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return Table{}, fmt.Errorf("failed opening policy file: %w", err)
+	}
+	defer f.Close()
+
+	var t Table
+	dec := json.NewDecoder(f)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&t); err != nil {
+		return Table{}, fmt.Errorf("malformed policy file: %w", err)
+	}
+
+	for i, route := range t.Routes {
+		if route.Path != "" && !strings.HasPrefix(route.Path, "/") {
+			return Table{}, fmt.Errorf("malformed policy file: route %v path must start with `/`", i)
+		}
+		for _, carrier := range route.Carriers {
+			switch carrier {
+			case CarrierAPIKey, CarrierCookie, CarrierQuery:
+			default:
+				return Table{}, fmt.Errorf("malformed policy file: route %v has unknown carrier `%s`", i, carrier)
+			}
+		}
+	}
+
+	return t, nil
+}
+
+// Authorize implements Authorizer
+func (t Table) Authorize(req Request, role string) bool {
+	for _, route := range t.Routes {
+		if route.matches(req) {
+			return route.allows(role)
+		}
+	}
+
+	return !t.DenyUnmatched
+}
+
+// Carriers implements Authorizer. Requests that match no route may only
+// carry their credentials in the Authorization header.
+func (t Table) Carriers(req Request) []string {
+	for _, route := range t.Routes {
+		if route.matches(req) {
+			return route.Carriers
+		}
+	}
+
+	return nil
+}
+
+func (r Route) matches(req Request) bool {
+	if len(r.Methods) > 0 {
+		var found bool
+		for _, m := range r.Methods {
+			if strings.EqualFold(m, req.Method) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	if r.Host != "" && normalizeHost(r.Host) != req.Host {
+		return false
+	}
+
+	return pathMatches(r.Path, req.Path)
+}
+
+func (r Route) allows(role string) bool {
+	for _, allowed := range r.Roles {
+		if allowed == AnyRole || allowed == role {
+			return true
+		}
+	}
+	return false
+}
+
+// pathMatches matches a route's path against a normalized, and so
+// lowercase, request path
+func pathMatches(pattern, p string) bool {
+	if pattern == "" || pattern == "/*" {
+		return true
+	}
+
+	pattern = strings.ToLower(pattern)
+
+	if prefix := strings.TrimSuffix(pattern, "/*"); prefix != pattern {
+		return p == prefix || strings.HasPrefix(p, prefix+"/")
+	}
+
+	return p == pattern
+}
@@ -0,0 +1,177 @@
+package policy_test
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/smartatransit/api-gateway/policy"
+)
+
+var _ = Describe("Table", func() {
+	var table policy.Table
+	BeforeEach(func() {
+		table = policy.Table{
+			Routes: []policy.Route{
+				{
+					Methods: []string{"POST", "DELETE"},
+					Path:    "/v1/admin/*",
+					Roles:   []string{"admin"},
+				},
+				{
+					Path:     "/v1/realtime/*",
+					Roles:    []string{policy.AnyRole},
+					Carriers: []string{policy.CarrierCookie, policy.CarrierQuery},
+				},
+				{
+					Host:  "partners.smartatransit.com",
+					Path:  "/v1/feeds",
+					Roles: []string{"partner", "admin"},
+				},
+				{
+					Path:  "/v1/*",
+					Roles: []string{policy.AnyRole},
+				},
+			},
+		}
+	})
+	Describe("Authorize", func() {
+		It("applies the first matching route", func() {
+			admin := policy.Request{Method: "POST", Host: "api.smartatransit.com", Path: "/v1/admin/users"}
+			Expect(table.Authorize(admin, "admin")).To(BeTrue())
+			Expect(table.Authorize(admin, "anonymous")).To(BeFalse())
+
+			admin.Method = "GET"
+			Expect(table.Authorize(admin, "anonymous")).To(BeTrue())
+		})
+		It("matches a prefix route's own path", func() {
+			req := policy.Request{Method: "POST", Path: "/v1/admin"}
+			Expect(table.Authorize(req, "anonymous")).To(BeFalse())
+		})
+		It("doesn't match a prefix route on a partial segment", func() {
+			req := policy.Request{Method: "POST", Path: "/v1/administrators"}
+			Expect(table.Authorize(req, "anonymous")).To(BeTrue())
+		})
+		It("matches paths case-insensitively", func() {
+			table.Routes[2].Path = "/v1/Feeds"
+			req := policy.Request{Method: "GET", Host: "partners.smartatransit.com", Path: "/v1/feeds"}
+			Expect(table.Authorize(req, "anonymous")).To(BeFalse())
+		})
+		It("can't be bypassed by spelling the path differently", func() {
+			for _, uri := range []string{"/V1/ADMIN/users", "/v1/admin;x/users", "/v1;x/Admin/users"} {
+				r, _ := http.NewRequest("POST", "http://gateway:8080/", nil)
+				r.Header.Set("X-Forwarded-Uri", uri)
+				req, err := policy.FromForwarded(r)
+				Expect(err).To(BeNil())
+				Expect(table.Authorize(req, "anonymous")).To(BeFalse(), uri)
+			}
+		})
+		It("matches hosts", func() {
+			req := policy.Request{Method: "GET", Host: "partners.smartatransit.com", Path: "/v1/feeds"}
+			Expect(table.Authorize(req, "partner")).To(BeTrue())
+			Expect(table.Authorize(req, "anonymous")).To(BeFalse())
+
+			req.Host = "api.smartatransit.com"
+			Expect(table.Authorize(req, "anonymous")).To(BeTrue())
+		})
+		When("no route matches", func() {
+			var req = policy.Request{Method: "GET", Path: "/v2/stops"}
+			It("allows the request by default", func() {
+				Expect(table.Authorize(req, "anonymous")).To(BeTrue())
+			})
+			It("denies it when DenyUnmatched is set", func() {
+				table.DenyUnmatched = true
+				Expect(table.Authorize(req, "anonymous")).To(BeFalse())
+			})
+		})
+	})
+	Describe("Carriers", func() {
+		It("returns the first matching route's carriers", func() {
+			req := policy.Request{Method: "GET", Path: "/v1/realtime/arrivals"}
+			Expect(table.Carriers(req)).To(ConsistOf(policy.CarrierCookie, policy.CarrierQuery))
+
+			req.Path = "/v1/stops"
+			Expect(table.Carriers(req)).To(BeEmpty())
+		})
+		It("returns none when no route matches", func() {
+			req := policy.Request{Method: "GET", Path: "/v2/realtime/arrivals"}
+			Expect(table.Carriers(req)).To(BeEmpty())
+		})
+	})
+})
+
+var _ = Describe("Load", func() {
+	var (
+		dir      string
+		filename string
+		contents string
+
+		table policy.Table
+		err   error
+	)
+	BeforeEach(func() {
+		dir, _ = ioutil.TempDir("", "policy")
+		filename = filepath.Join(dir, "policy.json")
+		contents = `{
+			"deny_unmatched": true,
+			"routes": [{"methods": ["GET"], "path": "/v1/*", "roles": ["*"]}]
+		}`
+	})
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+	JustBeforeEach(func() {
+		_ = ioutil.WriteFile(filename, []byte(contents), 0600)
+		table, err = policy.Load(filename)
+	})
+	When("the file doesn't exist", func() {
+		JustBeforeEach(func() {
+			table, err = policy.Load(filepath.Join(dir, "missing.json"))
+		})
+		It("fails", func() {
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(HavePrefix("failed opening policy file: "))
+		})
+	})
+	When("the file has unknown fields", func() {
+		BeforeEach(func() {
+			contents = `{"routes": [{"paths": ["/v1/*"]}]}`
+		})
+		It("fails", func() {
+			Expect(err).To(MatchError(`malformed policy file: json: unknown field "paths"`))
+		})
+	})
+	When("a path isn't rooted", func() {
+		BeforeEach(func() {
+			contents = `{"routes": [{"path": "v1/*"}]}`
+		})
+		It("fails", func() {
+			Expect(err).To(MatchError("malformed policy file: route 0 path must start with `/`"))
+		})
+	})
+	When("a carrier is unknown", func() {
+		BeforeEach(func() {
+			contents = `{"routes": [{"path": "/v1/*", "carriers": ["header"]}]}`
+		})
+		It("fails", func() {
+			Expect(err).To(MatchError("malformed policy file: route 0 has unknown carrier `header`"))
+		})
+	})
+	Context("otherwise", func() {
+		It("succeeds", func() {
+			Expect(err).To(BeNil())
+			Expect(table).To(Equal(policy.Table{
+				DenyUnmatched: true,
+				Routes: []policy.Route{{
+					Methods: []string{"GET"},
+					Path:    "/v1/*",
+					Roles:   []string{"*"},
+				}},
+			}))
+		})
+	})
+})
@@ -0,0 +1,187 @@
+// Code generated by counterfeiter. DO NOT EDIT.
+package policyfakes
+
+import (
+	"sync"
+
+	"github.com/smartatransit/api-gateway/policy"
+)
+
+type FakeAuthorizer struct {
+	AuthorizeStub        func(policy.Request, string) bool
+	authorizeMutex       sync.RWMutex
+	authorizeArgsForCall []struct {
+		arg1 policy.Request
+		arg2 string
+	}
+	authorizeReturns struct {
+		result1 bool
+	}
+	authorizeReturnsOnCall map[int]struct {
+		result1 bool
+	}
+	CarriersStub        func(policy.Request) []string
+	carriersMutex       sync.RWMutex
+	carriersArgsForCall []struct {
+		arg1 policy.Request
+	}
+	carriersReturns struct {
+		result1 []string
+	}
+	carriersReturnsOnCall map[int]struct {
+		result1 []string
+	}
+	invocations      map[string][][]interface{}
+	invocationsMutex sync.RWMutex
+}
+
+func (fake *FakeAuthorizer) Authorize(arg1 policy.Request, arg2 string) bool {
+	fake.authorizeMutex.Lock()
+	ret, specificReturn := fake.authorizeReturnsOnCall[len(fake.authorizeArgsForCall)]
+	fake.authorizeArgsForCall = append(fake.authorizeArgsForCall, struct {
+		arg1 policy.Request
+		arg2 string
+	}{arg1, arg2})
+	stub := fake.AuthorizeStub
+	fakeReturns := fake.authorizeReturns
+	fake.recordInvocation("Authorize", []interface{}{arg1, arg2})
+	fake.authorizeMutex.Unlock()
+	if stub != nil {
+		return stub(arg1, arg2)
+	}
+	if specificReturn {
+		return ret.result1
+	}
+	return fakeReturns.result1
+}
+
+func (fake *FakeAuthorizer) AuthorizeCallCount() int {
+	fake.authorizeMutex.RLock()
+	defer fake.authorizeMutex.RUnlock()
+	return len(fake.authorizeArgsForCall)
+}
+
+func (fake *FakeAuthorizer) AuthorizeCalls(stub func(policy.Request, string) bool) {
+	fake.authorizeMutex.Lock()
+	defer fake.authorizeMutex.Unlock()
+	fake.AuthorizeStub = stub
+}
+
+func (fake *FakeAuthorizer) AuthorizeArgsForCall(i int) (policy.Request, string) {
+	fake.authorizeMutex.RLock()
+	defer fake.authorizeMutex.RUnlock()
+	argsForCall := fake.authorizeArgsForCall[i]
+	return argsForCall.arg1, argsForCall.arg2
+}
+
+func (fake *FakeAuthorizer) AuthorizeReturns(result1 bool) {
+	fake.authorizeMutex.Lock()
+	defer fake.authorizeMutex.Unlock()
+	fake.AuthorizeStub = nil
+	fake.authorizeReturns = struct {
+		result1 bool
+	}{result1}
+}
+
+func (fake *FakeAuthorizer) AuthorizeReturnsOnCall(i int, result1 bool) {
+	fake.authorizeMutex.Lock()
+	defer fake.authorizeMutex.Unlock()
+	fake.AuthorizeStub = nil
+	if fake.authorizeReturnsOnCall == nil {
+		fake.authorizeReturnsOnCall = make(map[int]struct {
+			result1 bool
+		})
+	}
+	fake.authorizeReturnsOnCall[i] = struct {
+		result1 bool
+	}{result1}
+}
+
+func (fake *FakeAuthorizer) Carriers(arg1 policy.Request) []string {
+	fake.carriersMutex.Lock()
+	ret, specificReturn := fake.carriersReturnsOnCall[len(fake.carriersArgsForCall)]
+	fake.carriersArgsForCall = append(fake.carriersArgsForCall, struct {
+		arg1 policy.Request
+	}{arg1})
+	stub := fake.CarriersStub
+	fakeReturns := fake.carriersReturns
+	fake.recordInvocation("Carriers", []interface{}{arg1})
+	fake.carriersMutex.Unlock()
+	if stub != nil {
+		return stub(arg1)
+	}
+	if specificReturn {
+		return ret.result1
+	}
+	return fakeReturns.result1
+}
+
+func (fake *FakeAuthorizer) CarriersCallCount() int {
+	fake.carriersMutex.RLock()
+	defer fake.carriersMutex.RUnlock()
+	return len(fake.carriersArgsForCall)
+}
+
+func (fake *FakeAuthorizer) CarriersCalls(stub func(policy.Request) []string) {
+	fake.carriersMutex.Lock()
+	defer fake.carriersMutex.Unlock()
+	fake.CarriersStub = stub
+}
+
+func (fake *FakeAuthorizer) CarriersArgsForCall(i int) policy.Request {
+	fake.carriersMutex.RLock()
+	defer fake.carriersMutex.RUnlock()
+	argsForCall := fake.carriersArgsForCall[i]
+	return argsForCall.arg1
+}
+
+func (fake *FakeAuthorizer) CarriersReturns(result1 []string) {
+	fake.carriersMutex.Lock()
+	defer fake.carriersMutex.Unlock()
+	fake.CarriersStub = nil
+	fake.carriersReturns = struct {
+		result1 []string
+	}{result1}
+}
+
+func (fake *FakeAuthorizer) CarriersReturnsOnCall(i int, result1 []string) {
+	fake.carriersMutex.Lock()
+	defer fake.carriersMutex.Unlock()
+	fake.CarriersStub = nil
+	if fake.carriersReturnsOnCall == nil {
+		fake.carriersReturnsOnCall = make(map[int]struct {
+			result1 []string
+		})
+	}
+	fake.carriersReturnsOnCall[i] = struct {
+		result1 []string
+	}{result1}
+}
+
+func (fake *FakeAuthorizer) Invocations() map[string][][]interface{} {
+	fake.invocationsMutex.RLock()
+	defer fake.invocationsMutex.RUnlock()
+	fake.authorizeMutex.RLock()
+	defer fake.authorizeMutex.RUnlock()
+	fake.carriersMutex.RLock()
+	defer fake.carriersMutex.RUnlock()
+	copiedInvocations := map[string][][]interface{}{}
+	for key, value := range fake.invocations {
+		copiedInvocations[key] = value
+	}
+	return copiedInvocations
+}
+
+func (fake *FakeAuthorizer) recordInvocation(key string, args []interface{}) {
+	fake.invocationsMutex.Lock()
+	defer fake.invocationsMutex.Unlock()
+	if fake.invocations == nil {
+		fake.invocations = map[string][][]interface{}{}
+	}
+	if fake.invocations[key] == nil {
+		fake.invocations[key] = [][]interface{}{}
+	}
+	fake.invocations[key] = append(fake.invocations[key], args)
+}
+
+var _ policy.Authorizer = new(FakeAuthorizer)
@@ -0,0 +1,96 @@
+package policy
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// Request identifies the upstream request that traefik is asking us to
+// authorize.
+type Request struct {
+	Method string
+	Host   string
+	Path   string
+}
+
+// ErrMalformedPath is returned when the forwarded URI can't be normalized
+var ErrMalformedPath = errors.New("malformed request path")
+
+// FromForwarded builds a Request from the X-Forwarded-Method,
+// X-Forwarded-Host, and X-Forwarded-Uri headers that traefik sets on
+// forward-auth requests, falling back to the request itself when they're
+// absent.
+func FromForwarded(r *http.Request) (Request, error) {
+	method := r.Header.Get("X-Forwarded-Method")
+	if method == "" {
+		method = r.Method
+	}
+
+	host := r.Header.Get("X-Forwarded-Host")
+	if host == "" {
+		host = r.Host
+	}
+
+	uri := r.Header.Get("X-Forwarded-Uri")
+	if uri == "" {
+		uri = r.URL.RequestURI()
+	}
+
+	p, err := NormalizePath(uri)
+	if err != nil {
+		return Request{}, err
+	}
+
+	return Request{
+		Method: strings.ToUpper(method),
+		Host:   normalizeHost(host),
+		Path:   p,
+	}, nil
+}
+
+// NormalizePath strips the query and fragment from a request URI, decodes
+// it (including encoded slashes), strips `;` parameters from its segments,
+// lowercases it, and then resolves dot segments and collapses duplicate
+// slashes, so that every spelling of a path that an upstream might treat as
+// equivalent is matched the same way. Servlet containers ignore path
+// parameters, and many routers match case-insensitively.
+func NormalizePath(uri string) (string, error) {
+	if i := strings.IndexAny(uri, "?#"); i >= 0 {
+		uri = uri[:i]
+	}
+
+	decoded, err := url.PathUnescape(uri)
+	if err != nil {
+		return "", fmt.Errorf("%w: %s", ErrMalformedPath, err.Error())
+	}
+
+	if strings.ContainsRune(decoded, 0) {
+		return "", fmt.Errorf("%w: contains a null byte", ErrMalformedPath)
+	}
+
+	decoded = strings.ReplaceAll(decoded, `\`, "/")
+
+	segments := strings.Split(decoded, "/")
+	for i, segment := range segments {
+		if j := strings.IndexByte(segment, ';'); j >= 0 {
+			segments[i] = segment[:j]
+		}
+	}
+	decoded = strings.ToLower(strings.Join(segments, "/"))
+
+	// path.Clean on a rooted path resolves `.` and `..` segments (never
+	// climbing above the root) and collapses repeated slashes.
+	return path.Clean("/" + decoded), nil
+}
+
+func normalizeHost(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.TrimSuffix(strings.ToLower(host), ".")
+}
@@ -0,0 +1,83 @@
+package policy_test
+
+import (
+	"net/http"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+
+	"github.com/smartatransit/api-gateway/policy"
+)
+
+var _ = Describe("NormalizePath", func() {
+	DescribeTable("equivalent spellings",
+		func(uri, expected string) {
+			p, err := policy.NormalizePath(uri)
+			Expect(err).To(BeNil())
+			Expect(p).To(Equal(expected))
+		},
+		Entry("plain", "/admin/users", "/admin/users"),
+		Entry("query and fragment", "/admin/users?x=/public#frag", "/admin/users"),
+		Entry("dot segments", "/public/../admin/./users", "/admin/users"),
+		Entry("climbing above the root", "/../../admin", "/admin"),
+		Entry("duplicate slashes", "//admin///users", "/admin/users"),
+		Entry("encoded slashes", "/public%2F..%2Fadmin%2fusers", "/admin/users"),
+		Entry("encoded dots", "/public/%2e%2e/admin", "/admin"),
+		Entry("backslashes", `/public\..\admin`, "/admin"),
+		Entry("trailing slash", "/admin/", "/admin"),
+		Entry("relative", "admin", "/admin"),
+		Entry("empty", "", "/"),
+		Entry("upper case", "/ADMIN/Users", "/admin/users"),
+		Entry("path parameters", "/admin;x/users;jsessionid=1", "/admin/users"),
+		Entry("encoded path parameters", "/admin%3Bx/users", "/admin/users"),
+		Entry("path parameters on dot segments", "/admin/..;/public", "/public"),
+	)
+
+	When("the escaping is malformed", func() {
+		It("fails", func() {
+			_, err := policy.NormalizePath("/admin%zz")
+			Expect(err).To(MatchError(policy.ErrMalformedPath))
+		})
+	})
+	When("there's an encoded null byte", func() {
+		It("fails", func() {
+			_, err := policy.NormalizePath("/admin%00.json")
+			Expect(err).To(MatchError(policy.ErrMalformedPath))
+		})
+	})
+})
+
+var _ = Describe("FromForwarded", func() {
+	var r *http.Request
+	BeforeEach(func() {
+		r, _ = http.NewRequest("GET", "http://gateway:8080/verify?x=y", nil)
+	})
+	When("traefik has set the X-Forwarded headers", func() {
+		BeforeEach(func() {
+			r.Header.Set("X-Forwarded-Method", "delete")
+			r.Header.Set("X-Forwarded-Host", "API.smartatransit.com:443")
+			r.Header.Set("X-Forwarded-Uri", "/v1//things/../stops?id=1")
+		})
+		It("uses them", func() {
+			req, err := policy.FromForwarded(r)
+			Expect(err).To(BeNil())
+			Expect(req).To(Equal(policy.Request{
+				Method: "DELETE",
+				Host:   "api.smartatransit.com",
+				Path:   "/v1/stops",
+			}))
+		})
+	})
+	When("the headers are missing", func() {
+		It("falls back to the request itself", func() {
+			req, err := policy.FromForwarded(r)
+			Expect(err).To(BeNil())
+			Expect(req).To(Equal(policy.Request{
+				Method: "GET",
+				Host:   "gateway",
+				Path:   "/verify",
+			}))
+		})
+	})
+})
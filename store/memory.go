@@ -0,0 +1,231 @@
+package store
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// NewMemory creates a Store that keeps everything in this process, holding
+// at most maxSize keys and evicting the least recently used key when it's
+// full. A maxSize of zero or less leaves it unbounded. Its metrics are
+// labelled with name, which should be unique to each Memory.
+func NewMemory(name string, maxSize int) *Memory {
+	return &Memory{
+		maxSize:           maxSize,
+		keys:              memoryKeys.WithLabelValues(name),
+		capacityEvictions: memoryEvictions.WithLabelValues(name, "capacity"),
+		expiryEvictions:   memoryEvictions.WithLabelValues(name, "expired"),
+		entries:           map[string]*list.Element{},
+		lru:               list.New(),
+		Now:               time.Now,
+	}
+}
+
+// Memory implements Store. It is safe for concurrent use.
+type Memory struct {
+	maxSize int
+
+	keys              prometheus.Gauge
+	capacityEvictions prometheus.Counter
+	expiryEvictions   prometheus.Counter
+
+	Now func() time.Time
+
+	mutex   sync.Mutex
+	entries map[string]*list.Element
+	// lru holds an *entry for each key in entries, with the most recently
+	// used at the front.
+	lru *list.List
+}
+
+type entry struct {
+	key   string
+	value string
+	// expy is zero for keys that don't expire
+	expy time.Time
+}
+
+func (e *entry) expired(now time.Time) bool {
+	return !e.expy.IsZero() && now.After(e.expy)
+}
+
+// Get implements Store
+func (m *Memory) Get(ctx context.Context, key string) (string, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	e, ok := m.lookup(key)
+	if !ok {
+		return "", ErrNotFound
+	}
+	return e.value, nil
+}
+
+// Set implements Store
+func (m *Memory) Set(ctx context.Context, key, value string, ttl time.Duration) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.set(key, value, ttl)
+	return nil
+}
+
+// SetNX implements Store
+func (m *Memory) SetNX(ctx context.Context, key, value string, ttl time.Duration) (bool, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if _, ok := m.lookup(key); ok {
+		return false, nil
+	}
+
+	m.set(key, value, ttl)
+	return true, nil
+}
+
+// Incr implements Store
+func (m *Memory) Incr(ctx context.Context, key string, ttl time.Duration) (int64, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	e, ok := m.lookup(key)
+	if !ok {
+		m.set(key, "1", ttl)
+		return 1, nil
+	}
+
+	n, err := strconv.ParseInt(e.value, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("value at `%s` isn't an integer", key)
+	}
+
+	n++
+	e.value = strconv.FormatInt(n, 10)
+	return n, nil
+}
+
+// Delete implements Store
+func (m *Memory) Delete(ctx context.Context, key string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if el, ok := m.entries[key]; ok {
+		m.remove(el)
+	}
+	return nil
+}
+
+// Count implements Store
+func (m *Memory) Count(ctx context.Context, prefix string) (int64, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	now := m.Now()
+	var n int64
+	for key, el := range m.entries {
+		if strings.HasPrefix(key, prefix) && !el.Value.(*entry).expired(now) {
+			n++
+		}
+	}
+	return n, nil
+}
+
+// Clean clears out any expired keys
+func (m *Memory) Clean(ctx context.Context) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	now := m.Now()
+	for el := m.lru.Front(); el != nil; {
+		next := el.Next()
+		if el.Value.(*entry).expired(now) {
+			m.remove(el)
+			m.expiryEvictions.Inc()
+		}
+		el = next
+	}
+}
+
+// Len returns the number of keys, including any expired ones that haven't
+// been cleaned yet.
+func (m *Memory) Len() int {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	return m.lru.Len()
+}
+
+// Sweep calls Clean every interval until the context is cancelled.
+func (m *Memory) Sweep(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			m.Clean(ctx)
+		}
+	}
+}
+
+// lookup finds an unexpired entry and marks it as recently used. The caller
+// must hold the mutex.
+func (m *Memory) lookup(key string) (*entry, bool) {
+	el, ok := m.entries[key]
+	if !ok {
+		return nil, false
+	}
+
+	e := el.Value.(*entry)
+	if e.expired(m.Now()) {
+		m.remove(el)
+		m.expiryEvictions.Inc()
+		return nil, false
+	}
+
+	m.lru.MoveToFront(el)
+	return e, true
+}
+
+// set adds or replaces an entry. The caller must hold the mutex.
+func (m *Memory) set(key, value string, ttl time.Duration) {
+	var expy time.Time
+	if ttl > 0 {
+		expy = m.Now().Add(ttl)
+	}
+
+	if el, ok := m.entries[key]; ok {
+		e := el.Value.(*entry)
+		e.value, e.expy = value, expy
+		m.lru.MoveToFront(el)
+		return
+	}
+
+	m.entries[key] = m.lru.PushFront(&entry{
+		key:   key,
+		value: value,
+		expy:  expy,
+	})
+	m.keys.Inc()
+
+	for m.maxSize > 0 && m.lru.Len() > m.maxSize {
+		m.remove(m.lru.Back())
+		m.capacityEvictions.Inc()
+	}
+}
+
+// remove deletes an entry. The caller must hold the mutex.
+func (m *Memory) remove(el *list.Element) {
+	m.lru.Remove(el)
+	delete(m.entries, el.Value.(*entry).key)
+	m.keys.Dec()
+}
@@ -0,0 +1,64 @@
+package store_test
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/smartatransit/api-gateway/store"
+)
+
+const benchmarkKeys = 100000
+
+func filledMemory(maxSize int) *store.Memory {
+	m := store.NewMemory("bench", maxSize)
+	for i := 0; i < benchmarkKeys; i++ {
+		_ = m.Set(context.Background(), "client-"+strconv.Itoa(i), "token", time.Hour)
+	}
+	return m
+}
+
+func BenchmarkMemoryGet100k(b *testing.B) {
+	m := filledMemory(benchmarkKeys)
+	ctx := context.Background()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, _ = m.Get(ctx, "client-"+strconv.Itoa(i%benchmarkKeys))
+	}
+}
+
+func BenchmarkMemoryGet100kParallel(b *testing.B) {
+	m := filledMemory(benchmarkKeys)
+	ctx := context.Background()
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		var i int
+		for pb.Next() {
+			_, _ = m.Get(ctx, "client-"+strconv.Itoa(i%benchmarkKeys))
+			i++
+		}
+	})
+}
+
+func BenchmarkMemorySet100kEvicting(b *testing.B) {
+	m := filledMemory(benchmarkKeys)
+	ctx := context.Background()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_ = m.Set(ctx, "new-client-"+strconv.Itoa(i), "token", time.Hour)
+	}
+}
+
+func BenchmarkMemoryClean100k(b *testing.B) {
+	m := filledMemory(benchmarkKeys)
+	ctx := context.Background()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		m.Clean(ctx)
+	}
+}
@@ -0,0 +1,241 @@
+package store_test
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/smartatransit/api-gateway/store"
+)
+
+// metricValue reads a counter or gauge from the default registry
+func metricValue(name string, labels map[string]string) float64 {
+	families, err := prometheus.DefaultGatherer.Gather()
+	Expect(err).To(BeNil())
+
+	for _, family := range families {
+		if family.GetName() != name {
+			continue
+		}
+	metrics:
+		for _, m := range family.GetMetric() {
+			for _, l := range m.GetLabel() {
+				if labels[l.GetName()] != l.GetValue() {
+					continue metrics
+				}
+			}
+			if m.Counter != nil {
+				return m.GetCounter().GetValue()
+			}
+			return m.GetGauge().GetValue()
+		}
+	}
+	return 0
+}
+
+var _ = Describe("Memory", func() {
+	var (
+		m   *store.Memory
+		ctx = context.Background()
+		now time.Time
+		mu  sync.Mutex
+	)
+	BeforeEach(func() {
+		now = time.Unix(1600000000, 0)
+		m = store.NewMemory("token_cache", 3)
+		m.Now = func() time.Time {
+			mu.Lock()
+			defer mu.Unlock()
+			return now
+		}
+	})
+	advance := func(d time.Duration) {
+		mu.Lock()
+		defer mu.Unlock()
+		now = now.Add(d)
+	}
+
+	Describe("Get", func() {
+		It("fails for a missing key", func() {
+			_, err := m.Get(ctx, "a")
+			Expect(err).To(Equal(store.ErrNotFound))
+		})
+		It("fails for an expired key", func() {
+			Expect(m.Set(ctx, "a", "value", time.Minute)).To(Succeed())
+			advance(2 * time.Minute)
+			_, err := m.Get(ctx, "a")
+			Expect(err).To(Equal(store.ErrNotFound))
+			Expect(m.Len()).To(Equal(0))
+		})
+		It("returns the value", func() {
+			Expect(m.Set(ctx, "a", "value", time.Minute)).To(Succeed())
+			Expect(m.Get(ctx, "a")).To(Equal("value"))
+		})
+	})
+	Describe("Set", func() {
+		It("evicts the least recently used key once full", func() {
+			for _, key := range []string{"a", "b", "c"} {
+				Expect(m.Set(ctx, key, "value-"+key, time.Hour)).To(Succeed())
+			}
+
+			_, err := m.Get(ctx, "a")
+			Expect(err).To(BeNil())
+
+			Expect(m.Set(ctx, "d", "value-d", time.Hour)).To(Succeed())
+			Expect(m.Len()).To(Equal(3))
+
+			_, err = m.Get(ctx, "b")
+			Expect(err).To(Equal(store.ErrNotFound))
+			for _, key := range []string{"a", "c", "d"} {
+				Expect(m.Get(ctx, key)).To(Equal("value-" + key))
+			}
+		})
+		It("counts keys and evictions", func() {
+			evictions := func(reason string) float64 {
+				return metricValue("api_gateway_store_memory_evictions_total", map[string]string{"name": "token_cache", "reason": reason})
+			}
+			keys := metricValue("api_gateway_store_memory_keys", map[string]string{"name": "token_cache"})
+			capacity, expired := evictions("capacity"), evictions("expired")
+
+			for _, key := range []string{"a", "b", "c", "d"} {
+				Expect(m.Set(ctx, key, "value", time.Minute)).To(Succeed())
+			}
+			Expect(metricValue("api_gateway_store_memory_keys", map[string]string{"name": "token_cache"}) - keys).To(Equal(3.0))
+			Expect(evictions("capacity") - capacity).To(Equal(1.0))
+
+			advance(2 * time.Minute)
+			m.Clean(ctx)
+			Expect(metricValue("api_gateway_store_memory_keys", map[string]string{"name": "token_cache"}) - keys).To(Equal(0.0))
+			Expect(evictions("expired") - expired).To(Equal(3.0))
+		})
+		It("doesn't count the keys of other stores", func() {
+			series := func() []float64 {
+				return []float64{
+					metricValue("api_gateway_store_memory_keys", map[string]string{"name": "token_cache"}),
+					metricValue("api_gateway_store_memory_evictions_total", map[string]string{"name": "token_cache", "reason": "capacity"}),
+					metricValue("api_gateway_store_memory_evictions_total", map[string]string{"name": "token_cache", "reason": "expired"}),
+				}
+			}
+			before := series()
+			nonceKeys := metricValue("api_gateway_store_memory_keys", map[string]string{"name": "nonces"})
+
+			nonces := store.NewMemory("nonces", 0)
+			nonces.Now = m.Now
+			for i := 0; i < 10; i++ {
+				Expect(nonces.SetNX(ctx, fmt.Sprintf("app-nonce:ios:%v", i), "1", time.Minute)).To(BeTrue())
+			}
+			Expect(metricValue("api_gateway_store_memory_keys", map[string]string{"name": "nonces"}) - nonceKeys).To(Equal(10.0))
+
+			advance(2 * time.Minute)
+			nonces.Clean(ctx)
+			Expect(series()).To(Equal(before))
+		})
+		It("replaces an existing value and its expiry", func() {
+			Expect(m.Set(ctx, "a", "old", time.Minute)).To(Succeed())
+			Expect(m.Set(ctx, "a", "new", time.Hour)).To(Succeed())
+			Expect(m.Len()).To(Equal(1))
+
+			advance(30 * time.Minute)
+			Expect(m.Get(ctx, "a")).To(Equal("new"))
+		})
+		It("keeps keys without a ttl forever", func() {
+			Expect(m.Set(ctx, "a", "value", 0)).To(Succeed())
+			advance(24 * 365 * time.Hour)
+			Expect(m.Get(ctx, "a")).To(Equal("value"))
+		})
+	})
+	Describe("SetNX", func() {
+		It("only sets missing keys", func() {
+			Expect(m.SetNX(ctx, "a", "first", time.Minute)).To(BeTrue())
+			Expect(m.SetNX(ctx, "a", "second", time.Minute)).To(BeFalse())
+			Expect(m.Get(ctx, "a")).To(Equal("first"))
+		})
+		It("sets keys that have expired", func() {
+			Expect(m.SetNX(ctx, "a", "first", time.Minute)).To(BeTrue())
+			advance(2 * time.Minute)
+			Expect(m.SetNX(ctx, "a", "second", time.Minute)).To(BeTrue())
+			Expect(m.Get(ctx, "a")).To(Equal("second"))
+		})
+	})
+	Describe("Incr", func() {
+		It("counts up from zero", func() {
+			Expect(m.Incr(ctx, "n", time.Minute)).To(Equal(int64(1)))
+			Expect(m.Incr(ctx, "n", time.Minute)).To(Equal(int64(2)))
+			Expect(m.Get(ctx, "n")).To(Equal("2"))
+		})
+		It("keeps the expiry from when the key was created", func() {
+			Expect(m.Incr(ctx, "n", time.Minute)).To(Equal(int64(1)))
+			advance(45 * time.Second)
+			Expect(m.Incr(ctx, "n", time.Minute)).To(Equal(int64(2)))
+			advance(30 * time.Second)
+			Expect(m.Incr(ctx, "n", time.Minute)).To(Equal(int64(1)))
+		})
+		It("fails if the value isn't an integer", func() {
+			Expect(m.Set(ctx, "n", "abc", 0)).To(Succeed())
+			_, err := m.Incr(ctx, "n", time.Minute)
+			Expect(err).To(MatchError("value at `n` isn't an integer"))
+		})
+	})
+	Describe("Delete", func() {
+		It("removes the key", func() {
+			Expect(m.Set(ctx, "a", "value", 0)).To(Succeed())
+			Expect(m.Delete(ctx, "a")).To(Succeed())
+			Expect(m.Delete(ctx, "a")).To(Succeed())
+			_, err := m.Get(ctx, "a")
+			Expect(err).To(Equal(store.ErrNotFound))
+		})
+	})
+	Describe("Count", func() {
+		It("counts the unexpired keys with the prefix", func() {
+			Expect(m.Set(ctx, "token:a", "value", time.Minute)).To(Succeed())
+			Expect(m.Set(ctx, "token:b", "value", time.Hour)).To(Succeed())
+			Expect(m.Set(ctx, "nonce:a", "value", time.Hour)).To(Succeed())
+			Expect(m.Count(ctx, "token:")).To(Equal(int64(2)))
+
+			advance(2 * time.Minute)
+			Expect(m.Count(ctx, "token:")).To(Equal(int64(1)))
+		})
+	})
+	Describe("Sweep", func() {
+		It("removes expired keys until it's cancelled", func() {
+			Expect(m.Set(ctx, "a", "value-a", time.Minute)).To(Succeed())
+			Expect(m.Set(ctx, "b", "value-b", time.Hour)).To(Succeed())
+
+			ctx, cancel := context.WithCancel(context.Background())
+			done := make(chan struct{})
+			go func() {
+				m.Sweep(ctx, 10*time.Millisecond)
+				close(done)
+			}()
+
+			advance(2 * time.Minute)
+			Eventually(m.Len).Should(Equal(1))
+
+			cancel()
+			Eventually(done).Should(BeClosed())
+		})
+	})
+	It("is safe for concurrent use", func() {
+		var wg sync.WaitGroup
+		for i := 0; i < 8; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				for j := 0; j < 200; j++ {
+					key := fmt.Sprintf("key-%v", (i*j)%5)
+					_ = m.Set(ctx, key, "1", time.Hour)
+					_, _ = m.Get(ctx, key)
+					_, _ = m.Incr(ctx, key, time.Hour)
+					m.Clean(ctx)
+				}
+			}(i)
+		}
+		wg.Wait()
+		Expect(m.Len()).To(BeNumerically("<=", 3))
+	})
+})
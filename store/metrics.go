@@ -0,0 +1,22 @@
+package store
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	memoryKeys = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "api_gateway",
+		Subsystem: "store",
+		Name:      "memory_keys",
+		Help:      "Number of keys held by each in-memory store, including any expired ones that haven't been cleaned yet.",
+	}, []string{"name"})
+
+	memoryEvictions = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "api_gateway",
+		Subsystem: "store",
+		Name:      "memory_evictions_total",
+		Help:      "Keys removed from each in-memory store other than by Delete, by reason (capacity or expired). Keys evicted by Redis aren't counted.",
+	}, []string{"name", "reason"})
+)
@@ -0,0 +1,110 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v7"
+)
+
+// NewRedis creates a Store backed by Redis, so that it can be shared by
+// every gateway replica. Every key is prefixed with prefix, so that several
+// deployments can share a Redis server.
+func NewRedis(client *redis.Client, prefix string) *Redis {
+	return &Redis{
+		client: client,
+		prefix: prefix,
+	}
+}
+
+// Redis implements Store
+type Redis struct {
+	client *redis.Client
+	prefix string
+}
+
+// incrScript increments a key and sets its expiry only if the key is new,
+// which can't be done atomically with plain commands.
+var incrScript = redis.NewScript(`
+local n = redis.call("INCR", KEYS[1])
+if n == 1 and tonumber(ARGV[1]) > 0 then
+	redis.call("PEXPIRE", KEYS[1], ARGV[1])
+end
+return n
+`)
+
+// Get implements Store
+func (r *Redis) Get(ctx context.Context, key string) (string, error) {
+	value, err := r.client.WithContext(ctx).Get(r.prefix + key).Result()
+	if errors.Is(err, redis.Nil) {
+		return "", ErrNotFound
+	}
+	return value, err
+}
+
+// Set implements Store
+func (r *Redis) Set(ctx context.Context, key, value string, ttl time.Duration) error {
+	return r.client.WithContext(ctx).Set(r.prefix+key, value, expiration(ttl)).Err()
+}
+
+// SetNX implements Store
+func (r *Redis) SetNX(ctx context.Context, key, value string, ttl time.Duration) (bool, error) {
+	return r.client.WithContext(ctx).SetNX(r.prefix+key, value, expiration(ttl)).Result()
+}
+
+// Incr implements Store
+func (r *Redis) Incr(ctx context.Context, key string, ttl time.Duration) (int64, error) {
+	return incrScript.Run(r.client.WithContext(ctx), []string{r.prefix + key}, expiration(ttl).Milliseconds()).Int64()
+}
+
+// Delete implements Store
+func (r *Redis) Delete(ctx context.Context, key string) error {
+	return r.client.WithContext(ctx).Del(r.prefix + key).Err()
+}
+
+// Count implements Store by scanning the keys, a batch at a time so that
+// Redis isn't blocked
+func (r *Redis) Count(ctx context.Context, prefix string) (int64, error) {
+	client := r.client.WithContext(ctx)
+	match := globEscaper.Replace(r.prefix+prefix) + "*"
+
+	var (
+		n      int64
+		cursor uint64
+	)
+	for {
+		keys, next, err := client.Scan(cursor, match, 1000).Result()
+		if err != nil {
+			return 0, err
+		}
+		n += int64(len(keys))
+
+		if cursor = next; cursor == 0 {
+			return n, nil
+		}
+	}
+}
+
+// globEscaper escapes the characters that are special in SCAN patterns
+var globEscaper = strings.NewReplacer(`\`, `\\`, "*", `\*`, "?", `\?`, "[", `\[`, "]", `\]`)
+
+// Close closes the Redis client
+func (r *Redis) Close() error {
+	return r.client.Close()
+}
+
+// expiration converts a ttl to the go-redis convention, where zero means no
+// expiry. Redis expiries have millisecond precision, so shorter ttls are
+// rounded up rather than becoming permanent.
+func expiration(ttl time.Duration) time.Duration {
+	switch {
+	case ttl <= 0:
+		return 0
+	case ttl < time.Millisecond:
+		return time.Millisecond
+	default:
+		return ttl
+	}
+}
@@ -0,0 +1,119 @@
+package store_test
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v7"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/smartatransit/api-gateway/store"
+)
+
+var _ = Describe("Redis", func() {
+	var (
+		mr     *miniredis.Miniredis
+		client *redis.Client
+		r      *store.Redis
+		ctx    = context.Background()
+	)
+	BeforeEach(func() {
+		var err error
+		mr, err = miniredis.Run()
+		Expect(err).To(BeNil())
+
+		client = redis.NewClient(&redis.Options{Addr: mr.Addr()})
+		r = store.NewRedis(client, "gw:")
+	})
+	AfterEach(func() {
+		client.Close()
+		mr.Close()
+	})
+
+	Describe("Get", func() {
+		It("fails for a missing key", func() {
+			_, err := r.Get(ctx, "a")
+			Expect(err).To(Equal(store.ErrNotFound))
+		})
+		It("returns the value", func() {
+			Expect(r.Set(ctx, "a", "value", time.Minute)).To(Succeed())
+			Expect(r.Get(ctx, "a")).To(Equal("value"))
+		})
+		It("fails if Redis is unavailable", func() {
+			mr.Close()
+			_, err := r.Get(ctx, "a")
+			Expect(err).To(HaveOccurred())
+			Expect(err).NotTo(Equal(store.ErrNotFound))
+		})
+	})
+	Describe("Set", func() {
+		It("prefixes keys and maps the ttl to a Redis TTL", func() {
+			Expect(r.Set(ctx, "a", "value", time.Minute)).To(Succeed())
+			Expect(mr.Get("gw:a")).To(Equal("value"))
+			Expect(mr.TTL("gw:a")).To(Equal(time.Minute))
+
+			mr.FastForward(2 * time.Minute)
+			_, err := r.Get(ctx, "a")
+			Expect(err).To(Equal(store.ErrNotFound))
+		})
+		It("doesn't set a TTL without a ttl", func() {
+			Expect(r.Set(ctx, "a", "value", 0)).To(Succeed())
+			Expect(mr.TTL("gw:a")).To(BeZero())
+		})
+	})
+	Describe("SetNX", func() {
+		It("only sets missing keys", func() {
+			Expect(r.SetNX(ctx, "a", "first", time.Minute)).To(BeTrue())
+			Expect(r.SetNX(ctx, "a", "second", time.Minute)).To(BeFalse())
+			Expect(r.Get(ctx, "a")).To(Equal("first"))
+			Expect(mr.TTL("gw:a")).To(Equal(time.Minute))
+		})
+	})
+	Describe("Incr", func() {
+		It("counts up from zero", func() {
+			Expect(r.Incr(ctx, "n", time.Minute)).To(Equal(int64(1)))
+			Expect(r.Incr(ctx, "n", time.Minute)).To(Equal(int64(2)))
+			Expect(r.Get(ctx, "n")).To(Equal("2"))
+		})
+		It("keeps the expiry from when the key was created", func() {
+			Expect(r.Incr(ctx, "n", time.Minute)).To(Equal(int64(1)))
+			mr.FastForward(45 * time.Second)
+			Expect(r.Incr(ctx, "n", time.Minute)).To(Equal(int64(2)))
+			Expect(mr.TTL("gw:n")).To(Equal(15 * time.Second))
+			mr.FastForward(30 * time.Second)
+			Expect(r.Incr(ctx, "n", time.Minute)).To(Equal(int64(1)))
+		})
+		It("fails if the value isn't an integer", func() {
+			Expect(r.Set(ctx, "n", "abc", 0)).To(Succeed())
+			_, err := r.Incr(ctx, "n", time.Minute)
+			Expect(err).To(HaveOccurred())
+		})
+	})
+	Describe("Delete", func() {
+		It("removes the key", func() {
+			Expect(r.Set(ctx, "a", "value", 0)).To(Succeed())
+			Expect(r.Delete(ctx, "a")).To(Succeed())
+			Expect(mr.Exists("gw:a")).To(BeFalse())
+		})
+	})
+	Describe("Count", func() {
+		It("counts the keys with the prefix", func() {
+			for i := 0; i < 2500; i++ {
+				Expect(r.Set(ctx, fmt.Sprintf("token:%v", i), "value", time.Minute)).To(Succeed())
+			}
+			Expect(r.Set(ctx, "nonce:a", "value", time.Minute)).To(Succeed())
+			Expect(mr.Set("other:token:a", "value")).To(Succeed())
+
+			Expect(r.Count(ctx, "token:")).To(Equal(int64(2500)))
+		})
+		It("doesn't treat the prefix as a pattern", func() {
+			Expect(r.Set(ctx, "t*:a", "value", time.Minute)).To(Succeed())
+			Expect(r.Set(ctx, "token:a", "value", time.Minute)).To(Succeed())
+
+			Expect(r.Count(ctx, "t*:")).To(Equal(int64(1)))
+		})
+	})
+})
@@ -0,0 +1,30 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrNotFound is returned by Get when a key doesn't exist or has expired
+var ErrNotFound = errors.New("key not found")
+
+// Store holds state that can be shared between gateway replicas, such as
+// cached tokens, revocations, and counters. A ttl of zero or less means
+// the key doesn't expire.
+//go:generate counterfeiter . Store
+type Store interface {
+	Get(ctx context.Context, key string) (string, error)
+	Set(ctx context.Context, key, value string, ttl time.Duration) error
+	// SetNX sets the key only if it doesn't already exist, and reports
+	// whether it did.
+	SetNX(ctx context.Context, key, value string, ttl time.Duration) (bool, error)
+	// Incr adds one to the integer at key and returns the result. A key
+	// that doesn't exist starts from zero and expires after ttl; the expiry
+	// of an existing key is left alone.
+	Incr(ctx context.Context, key string, ttl time.Duration) (int64, error)
+	Delete(ctx context.Context, key string) error
+	// Count returns the number of unexpired keys that start with prefix. It
+	// may have to look at every key, so it's meant for occasional use.
+	Count(ctx context.Context, prefix string) (int64, error)
+}